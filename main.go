@@ -2,64 +2,308 @@ package main
 
 import (
 	"context"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/HarlamovBuldog/cart_api/pkg/api"
 	"github.com/HarlamovBuldog/cart_api/pkg/config"
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/metrics"
 	"github.com/HarlamovBuldog/cart_api/pkg/mongo"
-)
+	"github.com/HarlamovBuldog/cart_api/pkg/outbox"
+	"github.com/HarlamovBuldog/cart_api/pkg/products"
+	"github.com/HarlamovBuldog/cart_api/pkg/tlsconfig"
+	"github.com/HarlamovBuldog/cart_api/pkg/tracing"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+	"github.com/HarlamovBuldog/cart_api/pkg/webhook"
 
-const (
-	dbName  = "cart_api"
-	connStr = "mongodb://localhost:27018"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
+const serviceName = "cart-api"
+
 func main() {
-	dbConfig := new(config.DatabaseConfig)
-	dbConfig.Load(config.SERVICENAME)
+	cfg, err := config.Load(config.SERVICENAME, os.Args[1:])
+	if err != nil {
+		log.Fatalf("could not load config: %s", err)
+	}
 
-	var (
-		db  *mongo.DB
-		err error
-	)
+	lg, err := logger.New(logOutput(cfg), cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		log.Fatalf("could not create logger: %s", err)
+	}
+	slog.SetDefault(lg)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TraceExporter, serviceName, os.Stdout)
+	if err != nil {
+		lg.Error("could not set up tracing", logger.Err(err))
+		os.Exit(1)
+	}
 
-	if dbConfig.DBName != "" && dbConfig.ConnectionString != "" {
-		db, err = mongo.Connect(context.Background(), dbConfig.ConnectionString, dbConfig.DBName)
-	} else {
-		db, err = mongo.Connect(context.Background(), connStr, dbName)
+	dbOpts := []mongo.Option{
+		mongo.WithLogger(lg),
+		mongo.WithConnectTimeout(cfg.DBConnectTimeout),
+		mongo.WithPoolSize(cfg.DBMinPoolSize, cfg.DBMaxPoolSize),
+	}
+	hub := events.NewHub()
+	dbOpts = append(dbOpts, mongo.WithEvents(hub))
+	apiOpts := []api.Option{
+		api.WithLogger(lg),
+		api.WithMaxBodyBytes(cfg.MaxBodyBytes),
+		api.WithEvents(hub),
+	}
+	if cfg.UnitsFile != "" {
+		catalog, err := units.LoadCatalog(cfg.UnitsFile)
+		if err != nil {
+			lg.Error("could not load units", logger.Err(err))
+			os.Exit(1)
+		}
+		apiOpts = append(apiOpts, api.WithUnitCatalog(catalog))
+	}
+	if cfg.ProductsFile != "" {
+		catalog, err := products.LoadCatalog(cfg.ProductsFile)
+		if err != nil {
+			lg.Error("could not load products", logger.Err(err))
+			os.Exit(1)
+		}
+		apiOpts = append(apiOpts, api.WithProductCatalog(catalog))
+	}
+	if cfg.OutboxPublisher != "" {
+		dbOpts = append(dbOpts, mongo.WithOutbox())
+	}
+	if cfg.WebhooksEnabled {
+		dbOpts = append(dbOpts, mongo.WithWebhooks())
+	}
+	if cfg.ListsEnabled {
+		dbOpts = append(dbOpts, mongo.WithLists())
+	}
+	if cfg.InventoryEnabled {
+		dbOpts = append(dbOpts, mongo.WithInventory(cfg.ReservationTTL))
+	}
+	if cfg.MetricsEnabled {
+		m := metrics.New()
+		dbOpts = append(dbOpts, mongo.WithMetrics(m))
+		apiOpts = append(apiOpts, api.WithMetrics(m))
 	}
 
+	db, err := mongo.Connect(context.Background(), cfg.ConnectionString, cfg.DBName, dbOpts...)
 	if err != nil {
-		log.Fatal("could not connect to mongo")
+		lg.Error("could not connect to mongo", logger.Err(err))
+		os.Exit(1)
+	}
+
+	var stops []func()
+	if cfg.OutboxPublisher != "" {
+		publisher, err := outbox.NewPublisher(cfg.OutboxPublisher)
+		if err != nil {
+			lg.Error("could not create outbox publisher", logger.Err(err))
+			os.Exit(1)
+		}
+		if c, ok := publisher.(io.Closer); ok {
+			defer c.Close()
+		}
+		relay := outbox.NewRelay(db, publisher,
+			outbox.WithLogger(lg),
+			outbox.WithPollInterval(cfg.OutboxPollInterval))
+		stops = append(stops, startBackground(relay.Run))
+	}
+	if cfg.WebhooksEnabled {
+		// deliveries are scheduled in the transaction of the change, independently of the outbox publisher
+		apiOpts = append(apiOpts, api.WithWebhooks(db))
+		if cfg.TLSClientAuth == tlsconfig.ClientAuthNone {
+			lg.Warn("webhook routes serve only clients with a verified certificate, which tls_client_auth none never asks for")
+		}
+		stops = append(stops, startBackground(webhook.NewWorker(db, webhook.WithLogger(lg)).Run))
+	}
+	if cfg.AbandonedCartAfter > 0 {
+		stops = append(stops, startBackground(func(ctx context.Context) {
+			markAbandonedCarts(ctx, db, cfg.AbandonedCartAfter, lg)
+		}))
+	}
+
+	if cfg.InventoryEnabled {
+		stops = append(stops, startBackground(func(ctx context.Context) {
+			releaseExpiredReservations(ctx, db, lg)
+		}))
 	}
+
+	if cfg.ListsEnabled {
+		apiOpts = append(apiOpts, api.WithLists(db))
+	}
+	if cfg.InventoryEnabled {
+		apiOpts = append(apiOpts, api.WithInventory(db))
+	}
+	apiOpts = append(apiOpts, api.WithAuditLog(db), api.WithReadinessCheck(db, cfg.ReadinessTimeout))
+	apiServer := api.New(db, apiOpts...)
 	srv := &http.Server{
-		Addr:    ":27000",
-		Handler: api.New(db),
+		Addr:              cfg.ListenAddress,
+		Handler:           apiServer,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(lg.Handler(), slog.LevelError),
+	}
+	var grpcOpts []grpc.ServerOption
+	if cfg.TLSCertFile != "" {
+		reloader, err := tlsconfig.New(tlsconfig.Options{
+			CertFile:       cfg.TLSCertFile,
+			KeyFile:        cfg.TLSKeyFile,
+			ClientCAFile:   cfg.TLSClientCAFile,
+			ClientAuth:     cfg.TLSClientAuth,
+			ReloadInterval: cfg.TLSReloadInterval,
+		}, lg)
+		if err != nil {
+			lg.Error("could not load certificates", logger.Err(err))
+			os.Exit(1)
+		}
+		srv.TLSConfig = reloader.TLSConfig()
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(reloader.TLSConfig("h2"))))
+	}
+	var grpcServer *grpc.Server
+	if cfg.GRPCListenAddress != "" {
+		grpcListener, err := net.Listen("tcp", cfg.GRPCListenAddress)
+		if err != nil {
+			lg.Error("could not listen for gRPC", logger.Err(err))
+			os.Exit(1)
+		}
+		grpcServer = apiServer.GRPC(grpcOpts...)
+		go func() {
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				lg.Error("gRPC Serve()", logger.Err(err))
+			}
+		}()
+		lg.Info("gRPC server started", slog.String("address", cfg.GRPCListenAddress))
 	}
 
 	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			// certificates are provided by srv.TLSConfig
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
 		// returns ErrServerClosed on graceful close
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("ListenAndServe(): %s", err)
+		if err != nil && err != http.ErrServerClosed {
+			lg.Error("ListenAndServe()", logger.Err(err))
 		}
 	}()
+	lg.Info("Server started", slog.String("address", cfg.ListenAddress), slog.Bool("tls", srv.TLSConfig != nil),
+		slog.String("client_auth", cfg.TLSClientAuth))
 
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	<-stop
 
-	log.Print("Server shutting down...")
+	lg.Info("Server draining...")
+	apiServer.Drain()
+	time.Sleep(cfg.DrainDelay)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	lg.Info("Server shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("error shutdown server: %s", err)
+		lg.Error("error shutdown server", logger.Err(err))
+	}
+	if grpcServer != nil {
+		stopGRPC(ctx, grpcServer)
+	}
+	for _, stop := range stops {
+		stop()
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		lg.Error("error shutdown tracing", logger.Err(err))
 	}
 
-	log.Print("Server stopped")
+	lg.Info("Server stopped")
+}
+
+// logOutput returns writer of logs: stdout unless spans or outbox events are written there, so the streams
+// are not mixed.
+func logOutput(cfg *config.AppConfig) io.Writer {
+	if cfg.TraceExporter == tracing.ExporterStdout || cfg.OutboxPublisher == outbox.PublisherStdout {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// stopGRPC waits for pending gRPC calls to finish until ctx is done, then closes remaining connections.
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// startBackground calls run in a goroutine until the returned func is called. The func waits for run to return.
+func startBackground(run func(ctx context.Context)) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// markAbandonedCarts marks carts idle for longer than after every minute until ctx is done.
+func markAbandonedCarts(ctx context.Context, db *mongo.DB, after time.Duration, lg *slog.Logger) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		n, err := db.MarkAbandonedCarts(ctx, time.Now().Add(-after))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			lg.Warn("could not mark abandoned carts", logger.Err(err))
+		case n > 0:
+			lg.Info("carts are abandoned", slog.Int("count", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// releaseExpiredReservations returns stock reserved for carts not changed for reservation TTL every minute
+// until ctx is done.
+func releaseExpiredReservations(ctx context.Context, db *mongo.DB, lg *slog.Logger) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		n, err := db.ReleaseExpiredReservations(ctx, time.Now())
+		switch {
+		case err != nil && ctx.Err() == nil:
+			lg.Warn("could not release expired reservations", logger.Err(err))
+		case n > 0:
+			lg.Info("expired reservations are released", slog.Int("count", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
@@ -1,37 +1,186 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/HarlamovBuldog/cart_api/pkg/audit"
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/lists"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/metrics"
+	"github.com/HarlamovBuldog/cart_api/pkg/products"
 	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+	"github.com/HarlamovBuldog/cart_api/pkg/webhook"
 
 	"github.com/gorilla/mux"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
 )
 
 // Server contains http handler and service interface with database interaction futures.
 type Server struct {
 	http.Handler
+	router  *mux.Router
 	service service.Service
+	logger  *slog.Logger
+	metrics *metrics.Metrics
+
+	pinger           Pinger
+	readinessTimeout time.Duration
+	draining         atomic.Bool
+	drained          chan struct{}
+	drainOnce        sync.Once
+
+	maxBodyBytes int64
+	units        units.Catalog
+	products     products.Catalog
+
+	graphQLSchema *graphql.Schema
+	events        *events.Hub
+	rooms         rooms
+
+	webhooks  webhook.Store
+	resolver  webhook.Resolver
+	audit     audit.Log
+	lists     lists.Lists
+	inventory inventory.Ledger
+}
+
+// Option configures optional dependencies of Server.
+type Option func(*Server)
+
+// WithLogger sets logger used for access logs and handler errors.
+// Request scoped loggers derived from it are available to the service via logger.FromContext.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// WithMetrics enables collection of HTTP request metrics and exposes m at /metrics.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithUnitCatalog sets units and precision allowed for quantities of products.
+// Products missing from c accept units.DefaultSpec.
+func WithUnitCatalog(c units.Catalog) Option {
+	return func(s *Server) {
+		s.units = c
+	}
+}
+
+// WithProductCatalog sets lifecycle data and prices of products. Added lines take the price of their product,
+// cart validation reports discontinued products and changed prices.
+func WithProductCatalog(c products.Catalog) Option {
+	return func(s *Server) {
+		s.products = c
+	}
 }
 
 type newItem struct {
-	ProductName string  `json:"product"`
-	Quantity    float64 `json:"quantity"`
+	ProductName string             `json:"product"`
+	Quantity    float64            `json:"quantity"`
+	Unit        units.Unit         `json:"unit"`
+	VariantID   string             `json:"variant_id"`
+	Attributes  service.Attributes `json:"attributes"`
+}
+
+// cartItem returns line adding item, priced at the price of its product in catalog.
+func (item newItem) cartItem(catalog products.Catalog) service.CartItem {
+	return service.CartItem{
+		ProductName: item.ProductName,
+		Quantity:    item.Quantity,
+		Unit:        item.Unit,
+		VariantID:   item.VariantID,
+		Attributes:  item.Attributes,
+		Price:       catalog.Product(item.ProductName).Price,
+	}
 }
 
 // New initializes new api with router and entrypoints.
-func New(db service.Service) *Server {
+func New(db service.Service, opts ...Option) *Server {
 	router := mux.NewRouter()
 	s := Server{
-		service: db,
-		Handler: router,
+		router:           router,
+		service:          db,
+		logger:           slog.Default(),
+		readinessTimeout: defaultReadinessTimeout,
+		drained:          make(chan struct{}),
+		maxBodyBytes:     defaultMaxBodyBytes,
+		resolver:         net.DefaultResolver,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	s.graphQLSchema = newGraphQLSchema(&s)
+	s.Handler = chain(router,
+		withRequestID(s.logger),
+		withTracing(router),
+		withAccessLog(router),
+		withMetrics(router, s.metrics),
+		withRecovery,
+	)
+	router.HandleFunc("/openapi.json", s.openAPI).Methods("GET")
+	router.HandleFunc("/docs", s.docs).Methods("GET")
+	router.HandleFunc("/healthz", s.liveness).Methods("GET")
+	router.HandleFunc("/readyz", s.readiness).Methods("GET")
+	if s.metrics != nil {
+		router.Handle("/metrics", s.metrics.Handler()).Methods("GET")
 	}
 	router.HandleFunc("/carts", s.createCart).Methods("POST")
 	router.HandleFunc("/carts/{cart_id}/items", s.addToCart).Methods("POST")
+	router.HandleFunc("/carts/{cart_id}/items:batch", s.batchItems).Methods("POST")
 	router.HandleFunc("/carts/{cart_id}/items/{item_id}", s.removeFromCart).Methods("DELETE")
 	router.HandleFunc("/carts/{cart_id}", s.viewCart).Methods("GET")
+	router.HandleFunc("/carts/{cart_id}", s.patchCart).Methods("PATCH")
+	router.HandleFunc("/carts/{cart_id}/validate", s.validateCart).Methods("POST")
+	router.HandleFunc("/graphql", s.graphQL).Methods("POST")
+	if s.events != nil {
+		router.HandleFunc("/carts/{cart_id}/events", s.cartEvents).Methods("GET")
+		router.HandleFunc("/carts/{cart_id}/ws", s.cartWebSocket).Methods("GET")
+	}
+	if s.audit != nil {
+		router.HandleFunc("/carts/{cart_id}/history", s.cartHistory).Methods("GET")
+		router.HandleFunc("/carts/{cart_id}/undo", s.undo).Methods("POST")
+	}
+	if s.lists != nil {
+		router.HandleFunc("/carts/{cart_id}/items/{item_id}/move", s.moveItem).Methods("POST")
+		router.HandleFunc("/customers/{customer_id}/lists", s.customerLists).Methods("GET")
+		router.HandleFunc("/customers/{customer_id}/lists/{list}", s.viewList).Methods("GET")
+		router.HandleFunc("/customers/{customer_id}/lists/{list}/items", s.addToList).Methods("POST")
+		router.HandleFunc("/customers/{customer_id}/lists/{list}/items/{item_id}", s.removeFromList).Methods("DELETE")
+	}
+	if s.inventory != nil {
+		router.HandleFunc("/inventory", s.setStock).Methods("PUT")
+		router.HandleFunc("/inventory", s.viewStock).Methods("GET")
+	}
+	if s.webhooks != nil {
+		router.HandleFunc("/webhooks", requireClientCert(s.createWebhook)).Methods("POST")
+		router.HandleFunc("/webhooks", requireClientCert(s.listWebhooks)).Methods("GET")
+		router.HandleFunc("/webhooks/{webhook_id}", requireClientCert(s.getWebhook)).Methods("GET")
+		router.HandleFunc("/webhooks/{webhook_id}", requireClientCert(s.updateWebhook)).Methods("PUT")
+		router.HandleFunc("/webhooks/{webhook_id}", requireClientCert(s.deleteWebhook)).Methods("DELETE")
+		router.HandleFunc("/webhooks/{webhook_id}/deliveries", requireClientCert(s.webhookDeliveries)).Methods("GET")
+		router.HandleFunc("/webhooks/{webhook_id}/deliveries/{delivery_id}/retry",
+			requireClientCert(s.retryWebhookDelivery)).Methods("POST")
+	}
 
 	return &s
 }
@@ -39,6 +188,7 @@ func New(db service.Service) *Server {
 func (s *Server) createCart(w http.ResponseWriter, req *http.Request) {
 	cart, err := s.service.AddCart(req.Context())
 	if err != nil {
+		s.log(req).Error("could not add cart", logger.Err(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "could not add cart: %s", err)
 		return
@@ -54,10 +204,9 @@ func (s *Server) createCart(w http.ResponseWriter, req *http.Request) {
 
 func (s *Server) addToCart(w http.ResponseWriter, req *http.Request) {
 	var item newItem
-	err := json.NewDecoder(req.Body).Decode(&item)
+	err := s.decodeJSON(w, req, &item)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "could not decode request body: %s", err)
+		writeDecodeError(w, err)
 		return
 	}
 
@@ -68,14 +217,21 @@ func (s *Server) addToCart(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprint(w, "cart_id is not provided")
 		return
 	}
-	if valid := isNewItemDataValid(item); !valid {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "data from request body is not valid")
+	spec := s.units.Spec(item.ProductName)
+	if item.Unit == "" {
+		item.Unit = spec.DefaultUnit()
+	}
+	if err = item.validate(spec); err != nil {
+		writeValidationError(w, req, err)
 		return
 	}
 
-	cartItem, err := s.service.AddItemToCart(req.Context(), cartID, item.ProductName, item.Quantity)
+	cartItem, err := s.service.AddItemToCart(req.Context(), cartID, item.cartItem(s.products))
+	if writeStockError(w, req, errors.Wrap(err, "could not add item to cart")) {
+		return
+	}
 	if err != nil {
+		s.log(req).Error("could not add item to cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "could not add item to cart: %s", err)
 		return
@@ -106,6 +262,8 @@ func (s *Server) removeFromCart(w http.ResponseWriter, req *http.Request) {
 
 	err := s.service.RemoveItemFromCart(req.Context(), cartID, itemID)
 	if err != nil {
+		s.log(req).Error("could not remove item from cart",
+			slog.String(logger.CartIDKey, cartID), slog.String(logger.ItemIDKey, itemID), logger.Err(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "could not remove item from cart: %s", err)
 		return
@@ -120,15 +278,34 @@ func (s *Server) viewCart(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprint(w, "cart_id is not provided")
 		return
 	}
+	if at := req.URL.Query().Get("at"); at != "" {
+		s.viewCartAt(w, req, cartID, at)
+		return
+	}
 
 	cart, err := s.service.Cart(req.Context(), cartID)
 	if err != nil {
+		s.log(req).Error("could not get cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "could not get cart: %s", err)
 		return
 	}
+	writeCart(w, req, cart)
+}
 
-	err = json.NewEncoder(w).Encode(cart)
+// writeCart writes cart with items matching attribute filter of req.
+func writeCart(w http.ResponseWriter, req *http.Request, cart *service.Cart) {
+	if filter := attributeFilter(req); len(filter) > 0 {
+		items := []service.CartItem{}
+		for _, item := range cart.Items {
+			if item.Attributes.Matches(filter) {
+				items = append(items, item)
+			}
+		}
+		cart.Items = items
+	}
+
+	err := json.NewEncoder(w).Encode(cart)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "could not encode json: %s", err)
@@ -136,11 +313,132 @@ func (s *Server) viewCart(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func isNewItemDataValid(item newItem) bool {
-	switch {
-	case item.ProductName == "" || item.Quantity <= 0:
-		return false
-	default:
-		return true
+// log returns request scoped logger.
+func (s *Server) log(req *http.Request) *slog.Logger {
+	return logger.FromContext(req.Context(), s.logger)
+}
+
+// attributeFilterPrefix starts names of query parameters filtering items by attribute, e.g. ?attr.color=red.
+const attributeFilterPrefix = "attr."
+
+// attributeFilter returns attribute values items of the requested cart must have.
+func attributeFilter(req *http.Request) map[string]string {
+	filter := make(map[string]string)
+	for k, v := range req.URL.Query() {
+		if strings.HasPrefix(k, attributeFilterPrefix) && len(v) > 0 {
+			filter[strings.TrimPrefix(k, attributeFilterPrefix)] = v[0]
+		}
+	}
+	return filter
+}
+
+// Limits of item payloads.
+const (
+	maxProductNameLength = 200
+	maxItemQuantity      = 10000
+	maxVariantIDLength   = 64
+	maxAttributes        = 20
+	maxAttributeLength   = 100
+)
+
+var (
+	// productNameRe allows letters, digits, spaces and punctuation common in product names.
+	productNameRe = regexp.MustCompile(`^[\p{L}\p{N} \-_.,'&()/#+%]*$`)
+	variantIDRe   = regexp.MustCompile(`^[A-Za-z0-9_.\-]*$`)
+	attributeRe   = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+)
+
+// validate checks item against all rules and spec of its product and returns validation.Errors listing every violation.
+// Quantities of pieces must be whole numbers.
+func (item newItem) validate(spec units.Spec) error {
+	return validation.Validate(
+		validation.Field("product", item.ProductName,
+			validation.Required[string](),
+			validation.MaxLength(maxProductNameLength),
+			validation.Matches(productNameRe, "letters, digits, spaces and -_.,'&()/#+%"),
+		),
+		validation.Field("quantity", item.Quantity,
+			validation.Positive[float64](),
+			validation.Max[float64](maxItemQuantity),
+			validation.When(item.Unit == units.Piece, validation.Integer()),
+			validation.MaxDecimals(spec.Precision),
+		),
+		validation.Field("unit", item.Unit,
+			validation.OneOf(spec.Units...),
+		),
+		validation.Field("variant_id", item.VariantID,
+			validation.MaxLength(maxVariantIDLength),
+			validation.Matches(variantIDRe, "letters, digits and _.-"),
+		),
+		validation.Field("attributes", len(item.Attributes),
+			validation.Max(maxAttributes),
+		),
+		item.validateAttributes(),
+	)
+}
+
+// validateAttributes checks names and values of attributes in order of names.
+func (item newItem) validateAttributes() []validation.FieldError {
+	names := make([]string, 0, len(item.Attributes))
+	for name := range item.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []validation.FieldError
+	for _, name := range names {
+		field := "attributes." + name
+		errs = append(errs, validation.Field(field, name,
+			validation.Required[string](),
+			validation.Matches(attributeRe, "lowercase letters, digits and _ starting with a letter"))...)
+		switch v := item.Attributes[name].(type) {
+		case string:
+			errs = append(errs, validation.Field(field, v, validation.MaxLength(maxAttributeLength))...)
+		case float64, bool:
+		default:
+			errs = append(errs, validation.FieldError{Field: field, Rule: "type", Message: "must be a string, number or boolean"})
+		}
+	}
+	return errs
+}
+
+// updateItem sets quantity and unit of an item, keeping its unit if unit is empty.
+// The item is changed only if the cart is not changed since it was read.
+// Func returns validation.Errors for quantity and unit not allowed for the product, service.ErrNotFound
+// if there is no item, service.ErrVersionConflict if the cart is changed concurrently
+// and service.ErrOperationsFailed if the item could not be updated.
+func (s *Server) updateItem(ctx context.Context, cartID, itemID string, quantity float64, unit units.Unit) (*service.CartItem, error) {
+	cart, err := s.service.Cart(ctx, cartID)
+	if err != nil {
+		return nil, err
+	}
+	var orig *service.CartItem
+	for i := range cart.Items {
+		if cart.Items[i].ID.Hex() == itemID {
+			orig = &cart.Items[i]
+			break
+		}
+	}
+	if orig == nil {
+		return nil, errors.Wrapf(service.ErrNotFound, "item %s", itemID)
+	}
+	if unit == "" {
+		unit = withDefaultUnit(*orig).Unit
+	}
+	if err := validation.Validate(validateQuantity(quantity, unit, s.units.Spec(orig.ProductName))); err != nil {
+		return nil, err
+	}
+
+	results, err := s.service.ApplyItemOperations(ctx, cartID, cart.Version, []service.ItemOperation{{
+		Op:     service.OpUpdate,
+		ItemID: itemID,
+		Item:   service.CartItem{Quantity: quantity, Unit: unit},
+	}})
+	if errors.Cause(err) == service.ErrOperationsFailed && len(results) == 1 {
+		return nil, errors.Wrap(err, results[0].Error)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return results[0].Item, nil
 }
@@ -9,9 +9,11 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
 	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
 	"github.com/HarlamovBuldog/cart_api/pkg/mongo"
 	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
 
 	"github.com/golang/mock/gomock"
 	"github.com/pkg/errors"
@@ -39,7 +41,7 @@ func Test_createCart(t *testing.T) {
 			name:             "correct test",
 			method:           http.MethodPost,
 			request:          `{}`,
-			expectedResponse: fmt.Sprintf(`{"id":"%s","items":[]}`, cartObjIDSet[0].Hex()),
+			expectedResponse: fmt.Sprintf(`{"id":"%s","items":[],"version":0}`, cartObjIDSet[0].Hex()),
 			expectedStatus:   http.StatusOK,
 			addCrtOut: &addCartOut{
 				cart: &service.Cart{
@@ -105,9 +107,8 @@ func Test_addToCart(t *testing.T) {
 	cartObjIDSet := generatePrimObjIDSet(1)
 	cartItemObjIDSet := generatePrimObjIDSet(1)
 	type addToCartIn struct {
-		cartID      string
-		productName string
-		quantity    float64
+		cartID string
+		item   service.CartItem
 	}
 	type addToCartOut struct {
 		cartItem *service.CartItem
@@ -125,17 +126,17 @@ func Test_addToCart(t *testing.T) {
 		addToCrtOut      *addToCartOut
 	}{
 		{
-			name:    "correct test",
-			method:  http.MethodPost,
-			request: `{"product":"product_1", "quantity":10.0}`,
-			expectedResponse: fmt.Sprintf(`{"id":"%s","cart_id":"%s","product":"product_1","quantity":10}`,
+			name:        "correct test",
+			method:      http.MethodPost,
+			request:     `{"product":"product_1", "quantity":10.0}`,
+			contentType: "application/json",
+			expectedResponse: fmt.Sprintf(`{"id":"%s","cart_id":"%s","product":"product_1","quantity":10,"unit":"piece"}`,
 				cartItemObjIDSet[0].Hex(), cartObjIDSet[0].Hex()),
 			expectedStatus: http.StatusOK,
 			reqCartID:      cartObjIDSet[0].Hex(),
 			addToCrtIn: &addToCartIn{
-				cartID:      cartObjIDSet[0].Hex(),
-				productName: "product_1",
-				quantity:    10.0,
+				cartID: cartObjIDSet[0].Hex(),
+				item:   service.CartItem{ProductName: "product_1", Quantity: 10.0, Unit: units.Piece},
 			},
 			addToCrtOut: &addToCartOut{
 				cartItem: &service.CartItem{
@@ -143,10 +144,101 @@ func Test_addToCart(t *testing.T) {
 					CartID:      cartObjIDSet[0],
 					ProductName: "product_1",
 					Quantity:    10.0,
+					Unit:        units.Piece,
+				},
+				err: nil,
+			},
+		},
+		{
+			name:        "correct test: default unit of product",
+			method:      http.MethodPost,
+			request:     `{"product":"apples", "quantity":1.25}`,
+			contentType: "application/json",
+			expectedResponse: fmt.Sprintf(`{"id":"%s","cart_id":"%s","product":"apples","quantity":1.25,"unit":"kg"}`,
+				cartItemObjIDSet[0].Hex(), cartObjIDSet[0].Hex()),
+			expectedStatus: http.StatusOK,
+			reqCartID:      cartObjIDSet[0].Hex(),
+			addToCrtIn: &addToCartIn{
+				cartID: cartObjIDSet[0].Hex(),
+				item:   service.CartItem{ProductName: "apples", Quantity: 1.25, Unit: units.Kilogram},
+			},
+			addToCrtOut: &addToCartOut{
+				cartItem: &service.CartItem{
+					ID:          cartItemObjIDSet[0],
+					CartID:      cartObjIDSet[0],
+					ProductName: "apples",
+					Quantity:    1.25,
+					Unit:        units.Kilogram,
+				},
+				err: nil,
+			},
+		},
+		{
+			name:        "correct test: variant with attributes",
+			method:      http.MethodPost,
+			request:     `{"product":"t-shirt", "quantity":1, "variant_id":"TS-42", "attributes":{"size":"M","gift_wrap":true,"length":72.5}}`,
+			contentType: "application/json",
+			expectedResponse: fmt.Sprintf(`{"id":"%s","cart_id":"%s","product":"t-shirt","quantity":1,"unit":"piece",`+
+				`"variant_id":"TS-42","attributes":{"gift_wrap":true,"length":72.5,"size":"M"}}`,
+				cartItemObjIDSet[0].Hex(), cartObjIDSet[0].Hex()),
+			expectedStatus: http.StatusOK,
+			reqCartID:      cartObjIDSet[0].Hex(),
+			addToCrtIn: &addToCartIn{
+				cartID: cartObjIDSet[0].Hex(),
+				item: service.CartItem{
+					ProductName: "t-shirt",
+					Quantity:    1,
+					Unit:        units.Piece,
+					VariantID:   "TS-42",
+					Attributes:  service.Attributes{"size": "M", "gift_wrap": true, "length": 72.5},
+				},
+			},
+			addToCrtOut: &addToCartOut{
+				cartItem: &service.CartItem{
+					ID:          cartItemObjIDSet[0],
+					CartID:      cartObjIDSet[0],
+					ProductName: "t-shirt",
+					Quantity:    1,
+					Unit:        units.Piece,
+					VariantID:   "TS-42",
+					Attributes:  service.Attributes{"size": "M", "gift_wrap": true, "length": 72.5},
 				},
 				err: nil,
 			},
 		},
+		{
+			name:           "attributes are not valid",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			reqCartID:      cartObjIDSet[0].Hex(),
+			request:        `{"product":"t-shirt", "quantity":1, "variant_id":"TS 42", "attributes":{"Size":"M","print":{"text":"hi"}}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"variant_id","rule":"pattern","message":"must contain only letters, digits and _.-"},` +
+				`{"field":"attributes.Size","rule":"pattern","message":"must contain only lowercase letters, digits and _ starting with a letter"},` +
+				`{"field":"attributes.print","rule":"type","message":"must be a string, number or boolean"}]}`,
+		},
+		{
+			name:           "fractional quantity of pieces",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			reqCartID:      cartObjIDSet[0].Hex(),
+			request:        `{"product":"product_1", "quantity":1.5, "unit":"piece"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"quantity","rule":"integer","message":"must be a whole number"}]}`,
+		},
+		{
+			name:           "unit and precision not allowed for product",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			reqCartID:      cartObjIDSet[0].Hex(),
+			request:        `{"product":"apples", "quantity":1.255, "unit":"l"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"quantity","rule":"precision","message":"must not have more than 2 decimal places"},` +
+				`{"field":"unit","rule":"one_of","message":"must be one of [kg g piece]"}]}`,
+		},
 		{
 			name:           "incorrect method",
 			method:         http.MethodPatch,
@@ -157,42 +249,113 @@ func Test_addToCart(t *testing.T) {
 		{
 			name:             "bad request body: could not decode",
 			method:           http.MethodPost,
+			contentType:      "application/json",
 			reqCartID:        cartObjIDSet[0].Hex(),
 			request:          `{11:"product_1", 22:10.0}`,
 			expectedStatus:   http.StatusBadRequest,
 			expectedResponse: "could not decode request body: invalid character '1' looking for beginning of object key string",
 		},
 		{
-			name:             "data from request body is not valid",
+			name:             "bad request body: unknown field",
 			method:           http.MethodPost,
+			contentType:      "application/json",
 			reqCartID:        cartObjIDSet[0].Hex(),
-			request:          `{"product":"", "quantity":-10.0}`,
+			request:          `{"product":"product_1", "quantity":10.0, "price":1}`,
 			expectedStatus:   http.StatusBadRequest,
-			expectedResponse: "data from request body is not valid",
+			expectedResponse: `could not decode request body: json: unknown field "price"`,
+		},
+		{
+			name:             "bad request body: trailing data",
+			method:           http.MethodPost,
+			contentType:      "application/json",
+			reqCartID:        cartObjIDSet[0].Hex(),
+			request:          `{"product":"product_1", "quantity":10.0} {}`,
+			expectedStatus:   http.StatusBadRequest,
+			expectedResponse: "could not decode request body: unexpected data after JSON value",
+		},
+		{
+			name:             "bad request body: too large",
+			method:           http.MethodPost,
+			contentType:      "application/json",
+			reqCartID:        cartObjIDSet[0].Hex(),
+			request:          fmt.Sprintf(`{"product":"%s", "quantity":10.0}`, strings.Repeat("a", 2048)),
+			expectedStatus:   http.StatusRequestEntityTooLarge,
+			expectedResponse: "request body must not be larger than 1024 bytes",
+		},
+		{
+			name:             "unsupported content type",
+			method:           http.MethodPost,
+			contentType:      "text/plain",
+			reqCartID:        cartObjIDSet[0].Hex(),
+			request:          `{"product":"product_1", "quantity":10.0}`,
+			expectedStatus:   http.StatusUnsupportedMediaType,
+			expectedResponse: "content type must be application/json",
+		},
+		{
+			name:           "data from request body is not valid",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			reqCartID:      cartObjIDSet[0].Hex(),
+			request:        `{"product":"", "quantity":-10.0}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"product","rule":"required","message":"must be set"},` +
+				`{"field":"quantity","rule":"positive","message":"must be greater than 0"}]}`,
+		},
+		{
+			name:           "data from request body breaks limits",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			reqCartID:      cartObjIDSet[0].Hex(),
+			request:        fmt.Sprintf(`{"product":"%s<script>", "quantity":10001}`, strings.Repeat("a", 200)),
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"product","rule":"max_length","message":"must not be longer than 200 characters"},` +
+				`{"field":"product","rule":"pattern","message":"must contain only letters, digits, spaces and -_.,'\u0026()/#+%"},` +
+				`{"field":"quantity","rule":"max","message":"must not be greater than 10000"}]}`,
 		},
 		{
 			name:             "db error",
 			method:           http.MethodPost,
+			contentType:      "application/json; charset=utf-8",
 			request:          `{"product":"product_1", "quantity":10.0}`,
 			reqCartID:        cartObjIDSet[0].Hex(),
 			expectedResponse: "could not add item to cart: no carts: not found",
 			expectedStatus:   http.StatusInternalServerError,
 			addToCrtIn: &addToCartIn{
-				cartID:      cartObjIDSet[0].Hex(),
-				productName: "product_1",
-				quantity:    10.0,
+				cartID: cartObjIDSet[0].Hex(),
+				item:   service.CartItem{ProductName: "product_1", Quantity: 10.0, Unit: units.Piece},
 			},
 			addToCrtOut: &addToCartOut{
 				cartItem: nil,
 				err:      errors.Wrap(mongo.ErrNotFound, "no carts"),
 			},
 		},
+		{
+			name:        "insufficient stock",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			request:     `{"product":"apples", "quantity":1500, "unit":"g"}`,
+			reqCartID:   cartObjIDSet[0].Hex(),
+			expectedResponse: `{"error":"could not add item to cart: insufficient stock of apples: 1500 g requested, 1000 g available",` +
+				`"request_id":"test-request","stock":{"product":"apples","requested":1500,"available":1000,"unit":"g"}}`,
+			expectedStatus: http.StatusConflict,
+			addToCrtIn: &addToCartIn{
+				cartID: cartObjIDSet[0].Hex(),
+				item:   service.CartItem{ProductName: "apples", Quantity: 1500, Unit: units.Gram},
+			},
+			addToCrtOut: &addToCartOut{
+				err: &inventory.InsufficientStockError{Product: "apples", Requested: 1500, Available: 1000, Unit: units.Gram},
+			},
+		},
 	}
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mock := mocks.NewMockService(ctrl)
-	s := New(mock)
+	s := New(mock, WithMaxBodyBytes(1024), WithUnitCatalog(units.Catalog{
+		"apples": {Units: []units.Unit{units.Kilogram, units.Gram, units.Piece}, Precision: 2},
+	}))
 
 	server := httptest.NewServer(s)
 	defer server.Close()
@@ -200,11 +363,15 @@ func Test_addToCart(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			if tc.addToCrtOut != nil {
-				mock.EXPECT().AddItemToCart(gomock.Any(), tc.addToCrtIn.cartID, tc.addToCrtIn.productName, tc.addToCrtIn.quantity).
+				mock.EXPECT().AddItemToCart(gomock.Any(), tc.addToCrtIn.cartID, tc.addToCrtIn.item).
 					Times(1).Return(tc.addToCrtOut.cartItem, tc.addToCrtOut.err)
 			}
 			req, err := http.NewRequest(tc.method, fmt.Sprintf("%s/carts/%s/items", server.URL, tc.reqCartID), strings.NewReader(tc.request))
 			require.NoError(t, err, "could not create request")
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			req.Header.Set(RequestIDHeader, "test-request")
 
 			resp, err := http.DefaultClient.Do(req)
 			require.NoError(t, err, "could not get response")
@@ -321,6 +488,7 @@ func Test_viewCart(t *testing.T) {
 		method           string
 		request          string
 		requestCartID    string
+		query            string
 		expectedResponse string
 		expectedStatus   int
 		contentType      string
@@ -333,7 +501,7 @@ func Test_viewCart(t *testing.T) {
 			request:       `{}`,
 			requestCartID: cartObjIDSet[0].Hex(),
 			expectedResponse: fmt.Sprintf(`{"id":"%[1]s","items":[{"id":"%[2]s","cart_id":"%[1]s","product":"product_1","quantity":10},`+
-				`{"id":"%[3]s","cart_id":"%[1]s","product":"product_2","quantity":15}]}`,
+				`{"id":"%[3]s","cart_id":"%[1]s","product":"product_2","quantity":15}],"version":0}`,
 				cartObjIDSet[0].Hex(), itemObjIDSet[0].Hex(), itemObjIDSet[1].Hex()),
 			expectedStatus: http.StatusOK,
 			viewCrtIn:      cartObjIDSet[0].Hex(),
@@ -358,9 +526,51 @@ func Test_viewCart(t *testing.T) {
 				err: nil,
 			},
 		},
+		{
+			name:          "filter by attributes",
+			method:        http.MethodGet,
+			request:       `{}`,
+			requestCartID: cartObjIDSet[0].Hex(),
+			query:         "?attr.color=red&attr.gift_wrap=true",
+			expectedResponse: fmt.Sprintf(`{"id":"%[1]s","items":[{"id":"%[2]s","cart_id":"%[1]s","product":"product_1","quantity":1,`+
+				`"variant_id":"sku-1","attributes":{"color":"red","gift_wrap":true}}],"version":0}`,
+				cartObjIDSet[0].Hex(), itemObjIDSet[0].Hex()),
+			expectedStatus: http.StatusOK,
+			viewCrtIn:      cartObjIDSet[0].Hex(),
+			viewCrtOut: &viewCartOut{
+				cart: &service.Cart{
+					ID: cartObjIDSet[0],
+					Items: []service.CartItem{
+						{
+							ID:          itemObjIDSet[0],
+							CartID:      cartObjIDSet[0],
+							ProductName: "product_1",
+							Quantity:    1,
+							VariantID:   "sku-1",
+							Attributes:  service.Attributes{"color": "red", "gift_wrap": true},
+						},
+						{
+							ID:          itemObjIDSet[1],
+							CartID:      cartObjIDSet[0],
+							ProductName: "product_1",
+							Quantity:    1,
+							VariantID:   "sku-1",
+							Attributes:  service.Attributes{"color": "red"},
+						},
+						{
+							ID:          itemObjIDSet[2],
+							CartID:      cartObjIDSet[0],
+							ProductName: "product_2",
+							Quantity:    15,
+						},
+					},
+				},
+				err: nil,
+			},
+		},
 		{
 			name:           "incorrect method",
-			method:         http.MethodPatch,
+			method:         http.MethodPut,
 			request:        `{}`,
 			requestCartID:  cartObjIDSet[0].Hex(),
 			expectedStatus: http.StatusMethodNotAllowed,
@@ -394,7 +604,7 @@ func Test_viewCart(t *testing.T) {
 			}
 			req, err := http.NewRequest(
 				tc.method,
-				fmt.Sprintf("%s/carts/%s", server.URL, tc.requestCartID),
+				fmt.Sprintf("%s/carts/%s%s", server.URL, tc.requestCartID, tc.query),
 				strings.NewReader(tc.request))
 			require.NoError(t, err, "could not create request")
 
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/products"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// maxBatchOperations limits number of operations in a single batch request.
+const maxBatchOperations = 100
+
+// batchOperation is an operation of a batch request. Fields of newItem are used by add operations,
+// quantity and unit by update operations.
+type batchOperation struct {
+	Op     service.ItemOp `json:"op"`
+	ItemID string         `json:"item_id"`
+	newItem
+}
+
+type batchRequest struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchResponse struct {
+	Results []service.ItemOperationResult `json:"results"`
+}
+
+func (s *Server) batchItems(w http.ResponseWriter, req *http.Request) {
+	var batch batchRequest
+	err := s.decodeJSON(w, req, &batch)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	vars := mux.Vars(req)
+	cartID, ok := vars["cart_id"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "cart_id is not provided")
+		return
+	}
+	lines, err := s.updatedLines(req.Context(), cartID, batch.Operations)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Cause(err) == service.ErrNotFound {
+			status = http.StatusNotFound
+		} else {
+			s.log(req).Error("could not get cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+		}
+		writeJSONError(w, req, status, err.Error())
+		return
+	}
+	ops, err := s.itemOperations(batch, lines)
+	if err != nil {
+		writeValidationError(w, req, err)
+		return
+	}
+
+	results, err := s.service.ApplyItemOperations(req.Context(), cartID, service.AnyVersion, ops)
+	if errors.Cause(err) == service.ErrOperationsFailed {
+		writeErrorResponse(w, req, http.StatusUnprocessableEntity, errorResponse{
+			Error:   "no operations are applied: " + service.ErrOperationsFailed.Error(),
+			Results: results,
+		})
+		return
+	}
+	if writeStockError(w, req, errors.Wrap(err, "no operations are applied")) {
+		return
+	}
+	if err != nil {
+		s.log(req).Error("could not apply item operations", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "could not apply item operations: %s", err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(batchResponse{Results: results})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "could not encode json: %s", err)
+		return
+	}
+}
+
+// updatedLines returns lines of a cart updated by ops by their IDs, with default units set.
+// The cart is read only if ops update anything.
+func (s *Server) updatedLines(ctx context.Context, cartID string, ops []batchOperation) (map[string]service.CartItem, error) {
+	lines := make(map[string]service.CartItem)
+	for _, op := range ops {
+		if op.Op != service.OpUpdate || op.ItemID == "" {
+			continue
+		}
+		cart, err := s.service.Cart(ctx, cartID)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get cart")
+		}
+		for _, item := range cart.Items {
+			lines[item.ID.Hex()] = withDefaultUnit(item)
+		}
+		break
+	}
+	return lines, nil
+}
+
+// itemOperations validates every operation of batch and converts them to service operations.
+// Update operations are validated against lines they update.
+// Fields of violations are prefixed with index of the operation, e.g. operations[2].quantity.
+func (s *Server) itemOperations(batch batchRequest, lines map[string]service.CartItem) ([]service.ItemOperation, error) {
+	errs := validation.Field("operations", len(batch.Operations),
+		validation.Positive[int](),
+		validation.Max(maxBatchOperations),
+	)
+	ops := make([]service.ItemOperation, 0, len(batch.Operations))
+	for i, op := range batch.Operations {
+		prefix := fmt.Sprintf("operations[%d].", i)
+		errs = append(errs, prefixed(prefix, op.validate(s.units, lines))...)
+		ops = append(ops, op.itemOperation(s.products))
+	}
+	return ops, validation.Validate(errs)
+}
+
+// itemOperation converts op to service operation. Added lines are priced from catalog.
+func (op *batchOperation) itemOperation(catalog products.Catalog) service.ItemOperation {
+	item := op.cartItem(catalog)
+	if op.Op != service.OpAdd {
+		// updates keep the price of the line
+		item.Price = 0
+	}
+	return service.ItemOperation{Op: op.Op, ItemID: op.ItemID, Item: item}
+}
+
+// validate checks op and sets default units of added products and updated lines. Add operations are validated
+// as newItem, update operations may set only quantity and unit, checked against the product of the updated line
+// in lines, remove operations only item ID.
+func (op *batchOperation) validate(catalog units.Catalog, lines map[string]service.CartItem) []validation.FieldError {
+	errs := validation.Field("op", op.Op, validation.OneOf(service.OpAdd, service.OpUpdate, service.OpRemove))
+	switch op.Op {
+	case service.OpAdd:
+		spec := catalog.Spec(op.ProductName)
+		if op.Unit == "" {
+			op.Unit = spec.DefaultUnit()
+		}
+		errs = append(errs, validation.Field("item_id", op.ItemID, validation.Absent[string]())...)
+		if err := op.newItem.validate(spec); err != nil {
+			errs = append(errs, err.(validation.Errors)...)
+		}
+	case service.OpUpdate:
+		errs = append(errs, validation.Field("item_id", op.ItemID, validation.Required[string]())...)
+		line, ok := lines[op.ItemID]
+		switch {
+		case op.ItemID == "":
+		case !ok:
+			errs = append(errs, validation.FieldError{Field: "item_id", Rule: "exists", Message: "must be ID of an item of the cart"})
+		default:
+			if op.Unit == "" {
+				op.Unit = line.Unit
+			}
+			errs = append(errs, validateQuantity(op.Quantity, op.Unit, catalog.Spec(line.ProductName))...)
+		}
+		errs = append(errs, op.unusedItemFields()...)
+	case service.OpRemove:
+		errs = append(errs, validation.Field("item_id", op.ItemID, validation.Required[string]())...)
+		errs = append(errs, validation.Field("quantity", op.Quantity, validation.Absent[float64]())...)
+		errs = append(errs, validation.Field("unit", op.Unit, validation.Absent[units.Unit]())...)
+		errs = append(errs, op.unusedItemFields()...)
+	}
+	return errs
+}
+
+// unusedItemFields reports fields identifying added product, which update and remove operations must not set.
+func (op *batchOperation) unusedItemFields() []validation.FieldError {
+	errs := validation.Field("product", op.ProductName, validation.Absent[string]())
+	errs = append(errs, validation.Field("variant_id", op.VariantID, validation.Absent[string]())...)
+	return append(errs, validation.Field("attributes", len(op.Attributes), validation.Absent[int]())...)
+}
+
+// prefixed joins fields and prepends prefix to their names.
+func prefixed(prefix string, fields ...[]validation.FieldError) []validation.FieldError {
+	var errs []validation.FieldError
+	for _, f := range fields {
+		for _, fe := range f {
+			fe.Field = prefix + fe.Field
+			errs = append(errs, fe)
+		}
+	}
+	return errs
+}
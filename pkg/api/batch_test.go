@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/products"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_batchItems(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	itemObjIDSet := generatePrimObjIDSet(2)
+	type applyOut struct {
+		results []service.ItemOperationResult
+		err     error
+	}
+	cart := &service.Cart{ID: cartObjIDSet[0], Items: []service.CartItem{
+		{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "apples", Quantity: 3, Unit: units.Kilogram},
+	}}
+	tt := []struct {
+		name             string
+		request          string
+		expectedResponse string
+		expectedStatus   int
+		cart             *service.Cart
+		applyIn          []service.ItemOperation
+		applyOut         *applyOut
+	}{
+		{
+			name: "correct test",
+			request: fmt.Sprintf(`{"operations":[{"op":"add","product":"product_1","quantity":2},`+
+				`{"op":"update","item_id":"%s","quantity":1.5,"unit":"kg"},{"op":"remove","item_id":"%s"}]}`,
+				itemObjIDSet[0].Hex(), itemObjIDSet[1].Hex()),
+			expectedResponse: fmt.Sprintf(`{"results":[{"op":"add","item":{"id":"%[2]s","cart_id":"%[1]s","product":"product_1","quantity":2,"unit":"piece","price":4.5}},`+
+				`{"op":"update","item":{"id":"%[3]s","cart_id":"%[1]s","product":"apples","quantity":1.5,"unit":"kg"}},{"op":"remove"}]}`,
+				cartObjIDSet[0].Hex(), itemObjIDSet[1].Hex(), itemObjIDSet[0].Hex()),
+			expectedStatus: http.StatusOK,
+			cart:           cart,
+			applyIn: []service.ItemOperation{
+				{Op: service.OpAdd, Item: service.CartItem{ProductName: "product_1", Quantity: 2, Unit: units.Piece, Price: 4.5}},
+				{Op: service.OpUpdate, ItemID: itemObjIDSet[0].Hex(), Item: service.CartItem{Quantity: 1.5, Unit: units.Kilogram}},
+				{Op: service.OpRemove, ItemID: itemObjIDSet[1].Hex()},
+			},
+			applyOut: &applyOut{
+				results: []service.ItemOperationResult{
+					{Op: service.OpAdd, Item: &service.CartItem{ID: itemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "product_1", Quantity: 2, Unit: units.Piece, Price: 4.5}},
+					{Op: service.OpUpdate, Item: &service.CartItem{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "apples", Quantity: 1.5, Unit: units.Kilogram}},
+					{Op: service.OpRemove},
+				},
+			},
+		},
+		{
+			name:           "operations are not valid",
+			request:        `{"operations":[{"op":"add","item_id":"x","product":"product_1","quantity":1.5},{"op":"remove","product":"product_1"},{"op":"clear"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"operations[0].item_id","rule":"absent","message":"must not be set"},` +
+				`{"field":"operations[0].quantity","rule":"integer","message":"must be a whole number"},` +
+				`{"field":"operations[1].item_id","rule":"required","message":"must be set"},` +
+				`{"field":"operations[1].product","rule":"absent","message":"must not be set"},` +
+				`{"field":"operations[2].op","rule":"one_of","message":"must be one of [add update remove]"}]}`,
+		},
+		{
+			name: "updates are not valid for products",
+			request: fmt.Sprintf(`{"operations":[{"op":"update","item_id":"%s","quantity":1.25},`+
+				`{"op":"update","item_id":"%s","quantity":1,"unit":"l"},{"op":"update","item_id":"%s","quantity":1}]}`,
+				itemObjIDSet[0].Hex(), itemObjIDSet[0].Hex(), cartObjIDSet[0].Hex()),
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"operations[0].quantity","rule":"precision","message":"must not have more than 1 decimal places"},` +
+				`{"field":"operations[1].unit","rule":"one_of","message":"must be one of [kg g]"},` +
+				`{"field":"operations[2].item_id","rule":"exists","message":"must be ID of an item of the cart"}]}`,
+			cart: cart,
+		},
+		{
+			name:           "no operations",
+			request:        `{"operations":[]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"operations","rule":"positive","message":"must be greater than 0"}]}`,
+		},
+		{
+			name:           "operation failed",
+			request:        fmt.Sprintf(`{"operations":[{"op":"remove","item_id":"%s"}]}`, itemObjIDSet[0].Hex()),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedResponse: fmt.Sprintf(`{"error":"no operations are applied: operations failed","request_id":"test-request",`+
+				`"results":[{"op":"remove","error":"item %s is not found"}]}`, itemObjIDSet[0].Hex()),
+			applyIn: []service.ItemOperation{{Op: service.OpRemove, ItemID: itemObjIDSet[0].Hex()}},
+			applyOut: &applyOut{
+				results: []service.ItemOperationResult{{Op: service.OpRemove, Error: "item " + itemObjIDSet[0].Hex() + " is not found"}},
+				err:     errors.Wrap(service.ErrOperationsFailed, "no operations are applied"),
+			},
+		},
+		{
+			name:             "db error",
+			request:          fmt.Sprintf(`{"operations":[{"op":"remove","item_id":"%s"}]}`, itemObjIDSet[0].Hex()),
+			expectedStatus:   http.StatusInternalServerError,
+			expectedResponse: "could not apply item operations: connection refused",
+			applyIn:          []service.ItemOperation{{Op: service.OpRemove, ItemID: itemObjIDSet[0].Hex()}},
+			applyOut:         &applyOut{err: errors.New("connection refused")},
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock := mocks.NewMockService(ctrl)
+	s := New(mock, WithUnitCatalog(units.Catalog{
+		"apples": {Units: []units.Unit{units.Kilogram, units.Gram}, Precision: 1},
+	}), WithProductCatalog(products.Catalog{
+		"product_1": {Price: 4.5},
+		"apples":    {Price: 2},
+	}))
+
+	server := httptest.NewServer(s)
+	defer server.Close()
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.cart != nil {
+				mock.EXPECT().Cart(gomock.Any(), cartObjIDSet[0].Hex()).Times(1).Return(tc.cart, nil)
+			}
+			if tc.applyOut != nil {
+				mock.EXPECT().ApplyItemOperations(gomock.Any(), cartObjIDSet[0].Hex(), service.AnyVersion, tc.applyIn).
+					Times(1).Return(tc.applyOut.results, tc.applyOut.err)
+			}
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/carts/%s/items:batch", server.URL, cartObjIDSet[0].Hex()),
+				strings.NewReader(tc.request))
+			require.NoError(t, err, "could not create request")
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(RequestIDHeader, "test-request")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "could not get response")
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err, "could not read response")
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "Two status codes should be the same")
+			assert.Equal(t, tc.expectedResponse, string(bytes.TrimSpace(b)), "Two response bodies should be the same")
+		})
+	}
+}
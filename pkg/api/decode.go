@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMaxBodyBytes limits size of request bodies if WithMaxBodyBytes is not used.
+const defaultMaxBodyBytes = 1 << 20
+
+const jsonContentType = "application/json"
+
+// WithMaxBodyBytes limits size of request bodies decoded by handlers.
+// Larger bodies are rejected with 413 Request Entity Too Large.
+func WithMaxBodyBytes(n int64) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxBodyBytes = n
+		}
+	}
+}
+
+// decodeError is an error of reading request body with http status code to respond with.
+type decodeError struct {
+	status int
+	msg    string
+}
+
+func (e *decodeError) Error() string {
+	return e.msg
+}
+
+// decodeJSON strictly decodes request body into v. Body must be a single JSON value of application/json
+// content type not larger than the server limit, without fields unknown to v.
+// Func returns *decodeError describing the first problem found.
+func (s *Server) decodeJSON(w http.ResponseWriter, req *http.Request, v interface{}) error {
+	if err := checkContentType(req, jsonContentType); err != nil {
+		return err
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, s.maxBodyBytes)
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return bodyError(err)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected data after JSON value")
+		}
+		return bodyError(err)
+	}
+
+	return nil
+}
+
+// checkContentType returns *decodeError with 415 Unsupported Media Type status
+// if request body is not of one of allowed media types.
+func checkContentType(req *http.Request, allowed ...string) error {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err == nil {
+		for _, a := range allowed {
+			if mediaType == a {
+				return nil
+			}
+		}
+	}
+	return &decodeError{
+		status: http.StatusUnsupportedMediaType,
+		msg:    fmt.Sprintf("content type must be %s", allowed[0]),
+	}
+}
+
+func bodyError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &decodeError{
+			status: http.StatusRequestEntityTooLarge,
+			msg:    fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit),
+		}
+	}
+	return &decodeError{
+		status: http.StatusBadRequest,
+		msg:    fmt.Sprintf("could not decode request body: %s", err),
+	}
+}
+
+// writeDecodeError responds with status and message of err.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if decErr, ok := err.(*decodeError); ok {
+		status = decErr.status
+	}
+	w.WriteHeader(status)
+	fmt.Fprint(w, err.Error())
+}
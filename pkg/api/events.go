@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// sseKeepAliveInterval is how often a comment is sent to idle event streams, so proxies do not close them.
+const sseKeepAliveInterval = 15 * time.Second
+
+// resyncEvent tells a client resuming with Last-Event-ID that some events are lost and the cart must be read again.
+const resyncEvent = "resync"
+
+// WithEvents enables GET /carts/{cart_id}/events streaming events of h.
+// h must be notified about changes made by the service, e.g. with mongo.WithEvents.
+func WithEvents(h *events.Hub) Option {
+	return func(s *Server) {
+		s.events = h
+	}
+}
+
+// cartEvents streams changes of a cart as Server-Sent Events. A client reconnecting with Last-Event-ID header
+// gets events it missed, or a resync event if they are not kept anymore.
+// Streams end when the cart is deleted and when the server is drained.
+func (s *Server) cartEvents(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	cartID, ok := vars["cart_id"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "cart_id is not provided")
+		return
+	}
+
+	_, err := s.service.Cart(req.Context(), cartID)
+	switch {
+	case errors.Cause(err) == service.ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "could not get cart: %s", err)
+		return
+	case err != nil:
+		s.log(req).Error("could not get cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "could not get cart: %s", err)
+		return
+	}
+
+	var (
+		sub     *events.Subscription
+		missed  []events.Event
+		resumed = true
+	)
+	if lastEventID := req.Header.Get("Last-Event-ID"); lastEventID != "" {
+		lastID, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err == nil {
+			sub, missed, resumed = s.events.SubscribeAfter(cartID, lastID)
+		} else {
+			sub, resumed = s.events.Subscribe(cartID), false
+		}
+	} else {
+		sub = s.events.Subscribe(cartID)
+	}
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	// streams outlive write timeout of the server
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.log(req).Warn("could not disable write deadline", logger.Err(err))
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !resumed {
+		err = writeSSE(w, sub.After, resyncEvent, struct{}{})
+	}
+	for _, e := range missed {
+		if err != nil {
+			break
+		}
+		err = writeSSE(w, e.ID, e.Type, e)
+	}
+	if err == nil {
+		err = rc.Flush()
+	}
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for err == nil {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// subscriber fell behind, the client resumes after reconnecting
+				return
+			}
+			err = writeSSE(w, e.ID, e.Type, e)
+			if err == nil {
+				err = rc.Flush()
+			}
+			if e.Type == events.CartDeleted {
+				return
+			}
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+			if err == nil {
+				err = rc.Flush()
+			}
+		case <-req.Context().Done():
+			return
+		case <-s.drained:
+			return
+		}
+	}
+	s.log(req).Debug("event stream closed", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+}
+
+// writeSSE writes a single event with JSON data.
+func writeSSE(w io.Writer, id uint64, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "could not encode event")
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, b)
+	return err
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/mongo"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readSSE returns fields of the next event of r, skipping comments.
+func readSSE(t *testing.T, r *bufio.Reader) map[string]string {
+	fields := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err, "could not read event")
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && len(fields) > 0:
+			return fields
+		case line == "" || strings.HasPrefix(line, ":"):
+		default:
+			kv := strings.SplitN(line, ": ", 2)
+			require.Len(t, kv, 2, "malformed line %q", line)
+			fields[kv[0]] = kv[1]
+		}
+	}
+}
+
+func Test_cartEvents(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(2)
+	cartItemObjIDSet := generatePrimObjIDSet(1)
+	cartID := cartObjIDSet[0].Hex()
+	itemID := cartItemObjIDSet[0].Hex()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock := mocks.NewMockService(ctrl)
+	mock.EXPECT().Cart(gomock.Any(), cartID).AnyTimes().Return(&service.Cart{ID: cartObjIDSet[0]}, nil)
+	mock.EXPECT().Cart(gomock.Any(), cartObjIDSet[1].Hex()).AnyTimes().Return(nil, errors.Wrap(mongo.ErrNotFound, "no carts"))
+
+	hub := events.NewHub()
+	s := New(mock, WithEvents(hub))
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	// stream returns once the handler is subscribed, since headers are sent after subscription
+	stream := func(lastEventID string) (*http.Response, *bufio.Reader) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/carts/%s/events", server.URL, cartID), nil)
+		require.NoError(t, err, "could not create request")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err, "could not get response")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	t.Run("events of the cart are streamed", func(t *testing.T) {
+		resp, r := stream("")
+		defer resp.Body.Close()
+
+		hub.Publish(events.Event{Type: events.ItemAdded, CartID: cartObjIDSet[1].Hex(), ItemID: "other"})
+		hub.Publish(events.Event{Type: events.ItemRemoved, CartID: cartID, ItemID: itemID, Time: time.Unix(0, 0).UTC()})
+
+		e := readSSE(t, r)
+		assert.Equal(t, events.ItemRemoved, e["event"])
+		assert.NotEmpty(t, e["id"])
+		assert.JSONEq(t, fmt.Sprintf(`{"type":"item_removed","cart_id":%q,"item_id":%q,"time":"1970-01-01T00:00:00Z"}`, cartID, itemID), e["data"])
+	})
+
+	t.Run("missed events are replayed", func(t *testing.T) {
+		sub := hub.Subscribe(cartID)
+		hub.Publish(events.Event{Type: events.ItemAdded, CartID: cartID, ItemID: "item_1"})
+		hub.Publish(events.Event{Type: events.ItemAdded, CartID: cartID, ItemID: "item_2"})
+		first := <-sub.C
+		sub.Close()
+
+		resp, r := stream(fmt.Sprint(first.ID))
+		defer resp.Body.Close()
+		e := readSSE(t, r)
+		assert.Equal(t, fmt.Sprint(first.ID+1), e["id"])
+		assert.Contains(t, e["data"], `"item_id":"item_2"`)
+	})
+
+	t.Run("unknown event ID requires resync", func(t *testing.T) {
+		resp, r := stream("42")
+		defer resp.Body.Close()
+		e := readSSE(t, r)
+		assert.Equal(t, resyncEvent, e["event"])
+		assert.NotEqual(t, "42", e["id"], "Resync should reset last event ID of the client")
+	})
+
+	t.Run("stream ends with deletion of the cart", func(t *testing.T) {
+		resp, r := stream("")
+		defer resp.Body.Close()
+
+		hub.Publish(events.Event{Type: events.CartDeleted, CartID: cartID})
+		assert.Equal(t, events.CartDeleted, readSSE(t, r)["event"])
+		_, err := r.ReadString('\n')
+		assert.Error(t, err, "Stream should be closed")
+	})
+
+	t.Run("missing cart", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/carts/%s/events", server.URL, cartObjIDSet[1].Hex()))
+		require.NoError(t, err, "could not get response")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("drain ends streams", func(t *testing.T) {
+		resp, r := stream("")
+		defer resp.Body.Close()
+
+		s.Drain()
+		_, err := r.ReadString('\n')
+		assert.Error(t, err, "Stream should be closed")
+	})
+}
@@ -0,0 +1,394 @@
+package api
+
+import (
+	"context"
+	_ "embed" // embeds GraphQL schema
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// graphQLSchema describes queries and mutations served at /graphql.
+//
+//go:embed schema.graphql
+var graphQLSchema string
+
+// maxGraphQLDepth limits nesting of selections, so cart { items { cart { items ... } } } can not grow unbounded.
+const maxGraphQLDepth = 6
+
+// newGraphQLSchema parses graphQLSchema resolved by s.
+func newGraphQLSchema(s *Server) *graphql.Schema {
+	return graphql.MustParseSchema(graphQLSchema, &graphQLResolver{s: s},
+		graphql.MaxDepth(maxGraphQLDepth),
+		graphql.Logger(graphQLPanicLogger{}),
+	)
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQL executes a query or a mutation. Errors of execution are listed in the response with 200 OK status.
+func (s *Server) graphQL(w http.ResponseWriter, req *http.Request) {
+	var gqlReq graphQLRequest
+	if err := s.decodeJSON(w, req, &gqlReq); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	ctx := context.WithValue(req.Context(), cartCacheKey, newCartCache(s.service))
+	resp := s.graphQLSchema.Exec(ctx, gqlReq.Query, gqlReq.OperationName, gqlReq.Variables)
+	w.Header().Set("Content-Type", jsonContentType)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.log(req).Error("could not encode json", logger.Err(err))
+	}
+}
+
+// cartCache is a per-request cache of carts, so resolvers of the same cart share a single lookup.
+// It does not batch: every distinct cart is still read with its own Cart call.
+type cartCache struct {
+	service service.Service
+
+	mu    sync.Mutex
+	carts map[string]*cachedCart
+}
+
+type cachedCart struct {
+	done chan struct{}
+	cart *service.Cart
+	err  error
+}
+
+func newCartCache(svc service.Service) *cartCache {
+	return &cartCache{service: svc, carts: make(map[string]*cachedCart)}
+}
+
+// cartCacheFrom returns cache of the request. A new cache is returned if ctx carries none.
+func cartCacheFrom(ctx context.Context, svc service.Service) *cartCache {
+	if c, ok := ctx.Value(cartCacheKey).(*cartCache); ok {
+		return c
+	}
+	return newCartCache(svc)
+}
+
+// Load returns cart with a specified id. Concurrent loads of the same cart wait for the first one.
+func (c *cartCache) Load(ctx context.Context, id string) (*service.Cart, error) {
+	c.mu.Lock()
+	load, ok := c.carts[id]
+	if !ok {
+		load = &cachedCart{done: make(chan struct{})}
+		c.carts[id] = load
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		load.cart, load.err = c.service.Cart(ctx, id)
+		close(load.done)
+	}
+	select {
+	case <-load.done:
+		return load.cart, load.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Forget drops cart with a specified id, so it is read again after a mutation.
+func (c *cartCache) Forget(id string) {
+	c.mu.Lock()
+	delete(c.carts, id)
+	c.mu.Unlock()
+}
+
+// graphQLResolver resolves root queries and mutations.
+type graphQLResolver struct {
+	s *Server
+}
+
+func (r *graphQLResolver) Cart(ctx context.Context, args struct{ ID graphql.ID }) (*cartResolver, error) {
+	if err := validation.Validate(idField("id", args.ID)); err != nil {
+		return nil, r.error(ctx, err, "cart id is not valid")
+	}
+	cache := cartCacheFrom(ctx, r.s.service)
+	cart, err := cache.Load(ctx, string(args.ID))
+	if errors.Cause(err) == service.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, r.error(ctx, err, "could not get cart", slog.String(logger.CartIDKey, string(args.ID)))
+	}
+	return &cartResolver{cart: cart, cache: cache}, nil
+}
+
+func (r *graphQLResolver) CartItem(ctx context.Context, args struct{ CartID, ItemID graphql.ID }) (*cartItemResolver, error) {
+	if err := validation.Validate(idField("cartId", args.CartID), idField("itemId", args.ItemID)); err != nil {
+		return nil, r.error(ctx, err, "ids are not valid")
+	}
+	cache := cartCacheFrom(ctx, r.s.service)
+	cart, err := cache.Load(ctx, string(args.CartID))
+	if errors.Cause(err) == service.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, r.error(ctx, err, "could not get cart", slog.String(logger.CartIDKey, string(args.CartID)))
+	}
+	for _, item := range cart.Items {
+		if item.ID.Hex() == string(args.ItemID) {
+			return &cartItemResolver{item: item, cache: cache}, nil
+		}
+	}
+	return nil, nil
+}
+
+type newItemInput struct {
+	Product    string
+	Quantity   float64
+	Unit       *string
+	VariantID  *string
+	Attributes *attributesScalar
+}
+
+func (r *graphQLResolver) AddItem(ctx context.Context, args struct {
+	CartID graphql.ID
+	Item   newItemInput
+}) (*cartItemResolver, error) {
+	item := newItem{
+		ProductName: args.Item.Product,
+		Quantity:    args.Item.Quantity,
+	}
+	if args.Item.Unit != nil {
+		item.Unit = units.Unit(*args.Item.Unit)
+	}
+	if args.Item.VariantID != nil {
+		item.VariantID = *args.Item.VariantID
+	}
+	if args.Item.Attributes != nil {
+		item.Attributes = args.Item.Attributes.value
+	}
+	spec := r.s.units.Spec(item.ProductName)
+	if item.Unit == "" {
+		item.Unit = spec.DefaultUnit()
+	}
+	if err := validation.Validate(idField("cartId", args.CartID)); err != nil {
+		return nil, r.error(ctx, err, "cart id is not valid")
+	}
+	if err := item.validate(spec); err != nil {
+		return nil, r.error(ctx, err, "item is not valid")
+	}
+
+	cartID := string(args.CartID)
+	cartItem, err := r.s.service.AddItemToCart(ctx, cartID, item.cartItem(r.s.products))
+	if err != nil {
+		return nil, r.error(ctx, err, "could not add item to cart", slog.String(logger.CartIDKey, cartID))
+	}
+	cache := cartCacheFrom(ctx, r.s.service)
+	cache.Forget(cartID)
+	return &cartItemResolver{item: *cartItem, cache: cache}, nil
+}
+
+func (r *graphQLResolver) UpdateItem(ctx context.Context, args struct {
+	CartID, ItemID graphql.ID
+	Quantity       float64
+	Unit           *string
+}) (*cartItemResolver, error) {
+	if err := validation.Validate(idField("cartId", args.CartID), idField("itemId", args.ItemID)); err != nil {
+		return nil, r.error(ctx, err, "ids are not valid")
+	}
+	var unit units.Unit
+	if args.Unit != nil {
+		unit = units.Unit(*args.Unit)
+	}
+	cartID, itemID := string(args.CartID), string(args.ItemID)
+	item, err := r.s.updateItem(ctx, cartID, itemID, args.Quantity, unit)
+	if err != nil {
+		return nil, r.error(ctx, err, "could not update item",
+			slog.String(logger.CartIDKey, cartID), slog.String(logger.ItemIDKey, itemID))
+	}
+	cache := cartCacheFrom(ctx, r.s.service)
+	cache.Forget(cartID)
+	return &cartItemResolver{item: *item, cache: cache}, nil
+}
+
+func (r *graphQLResolver) RemoveItem(ctx context.Context, args struct{ CartID, ItemID graphql.ID }) (bool, error) {
+	if err := validation.Validate(idField("cartId", args.CartID), idField("itemId", args.ItemID)); err != nil {
+		return false, r.error(ctx, err, "ids are not valid")
+	}
+	cartID, itemID := string(args.CartID), string(args.ItemID)
+	if err := r.s.service.RemoveItemFromCart(ctx, cartID, itemID); err != nil {
+		return false, r.error(ctx, err, "could not remove item from cart",
+			slog.String(logger.CartIDKey, cartID), slog.String(logger.ItemIDKey, itemID))
+	}
+	cartCacheFrom(ctx, r.s.service).Forget(cartID)
+	return true, nil
+}
+
+// idField checks that id argument named name is an ObjectID, so malformed ids are answered as bad input
+// instead of failing the lookup.
+func idField(name string, id graphql.ID) []validation.FieldError {
+	return validation.Field(name, string(id), validation.Required[string](), validation.Matches(objectIDRe, "24 hexadecimal digits"))
+}
+
+// error converts err into graphQLError prefixed with msg. Unexpected errors are logged.
+func (r *graphQLResolver) error(ctx context.Context, err error, msg string, attrs ...slog.Attr) error {
+	if fields, ok := err.(validation.Errors); ok {
+		return &graphQLError{code: "BAD_USER_INPUT", msg: msg, fields: fields}
+	}
+	switch cause := errors.Cause(err); cause {
+	case service.ErrNotFound:
+		return &graphQLError{code: "NOT_FOUND", msg: msg + ": " + err.Error()}
+	case service.ErrVersionConflict:
+		return &graphQLError{code: "CONFLICT", msg: msg + ": cart is changed concurrently: " + err.Error()}
+	case service.ErrOperationsFailed, inventory.ErrIncompatibleUnit:
+		return &graphQLError{code: "FAILED_PRECONDITION", msg: msg + ": " + err.Error()}
+	default:
+		if stock, ok := cause.(*inventory.InsufficientStockError); ok {
+			return &graphQLError{code: "INSUFFICIENT_STOCK", msg: msg + ": " + err.Error(), stock: stock}
+		}
+	}
+	logger.FromContext(ctx, r.s.logger).LogAttrs(ctx, slog.LevelError, msg, append(attrs, logger.Err(err))...)
+	return &graphQLError{code: "INTERNAL", msg: msg + ": " + err.Error()}
+}
+
+// graphQLError is a resolver error reported with code, violated fields and short stock in extensions
+// of the response error.
+type graphQLError struct {
+	code   string
+	msg    string
+	fields []validation.FieldError
+	stock  *inventory.InsufficientStockError
+}
+
+func (e *graphQLError) Error() string {
+	return e.msg
+}
+
+func (e *graphQLError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.code}
+	if len(e.fields) > 0 {
+		ext["fields"] = e.fields
+	}
+	if e.stock != nil {
+		ext["stock"] = e.stock
+	}
+	return ext
+}
+
+type cartResolver struct {
+	cart  *service.Cart
+	cache *cartCache
+}
+
+func (c *cartResolver) ID() graphql.ID {
+	return graphql.ID(c.cart.ID.Hex())
+}
+
+func (c *cartResolver) Version() int32 {
+	return int32(c.cart.Version)
+}
+
+func (c *cartResolver) Items() []*cartItemResolver {
+	items := make([]*cartItemResolver, 0, len(c.cart.Items))
+	for _, item := range c.cart.Items {
+		items = append(items, &cartItemResolver{item: item, cache: c.cache})
+	}
+	return items
+}
+
+type cartItemResolver struct {
+	item  service.CartItem
+	cache *cartCache
+}
+
+func (i *cartItemResolver) ID() graphql.ID {
+	return graphql.ID(i.item.ID.Hex())
+}
+
+func (i *cartItemResolver) Cart(ctx context.Context) (*cartResolver, error) {
+	cart, err := i.cache.Load(ctx, i.item.CartID.Hex())
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get cart")
+	}
+	return &cartResolver{cart: cart, cache: i.cache}, nil
+}
+
+func (i *cartItemResolver) Product() string {
+	return i.item.ProductName
+}
+
+func (i *cartItemResolver) Quantity() float64 {
+	return i.item.Quantity
+}
+
+func (i *cartItemResolver) Unit() string {
+	return string(withDefaultUnit(i.item).Unit)
+}
+
+func (i *cartItemResolver) VariantID() *string {
+	if i.item.VariantID == "" {
+		return nil
+	}
+	return &i.item.VariantID
+}
+
+func (i *cartItemResolver) Attributes() *attributesScalar {
+	if len(i.item.Attributes) == 0 {
+		return nil
+	}
+	return &attributesScalar{value: i.item.Attributes}
+}
+
+// attributesScalar is the Attributes scalar of the schema.
+type attributesScalar struct {
+	value service.Attributes
+}
+
+func (attributesScalar) ImplementsGraphQLType(name string) bool {
+	return name == "Attributes"
+}
+
+// UnmarshalGraphQL accepts an object from a literal or variables. Integer literals are turned into float64
+// as numbers decoded from JSON are, so both pass validation of attribute types.
+func (a *attributesScalar) UnmarshalGraphQL(input interface{}) error {
+	m, ok := input.(map[string]interface{})
+	if !ok {
+		return errors.Errorf("attributes must be an object, got %T", input)
+	}
+	a.value = make(service.Attributes, len(m))
+	for k, v := range m {
+		switch n := v.(type) {
+		case int32:
+			v = float64(n)
+		case int64:
+			v = float64(n)
+		case int:
+			v = float64(n)
+		}
+		a.value[k] = v
+	}
+	return nil
+}
+
+func (a attributesScalar) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.value)
+}
+
+// graphQLPanicLogger logs panics of resolvers with request scoped logger.
+type graphQLPanicLogger struct{}
+
+func (graphQLPanicLogger) LogPanic(ctx context.Context, value interface{}) {
+	logger.FromContext(ctx, nil).Error("resolver panicked",
+		slog.Any("panic", value), slog.String("stack", string(debug.Stack())))
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/mongo"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_graphQL(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(2)
+	cartItemObjIDSet := generatePrimObjIDSet(2)
+	cartID, itemID := cartObjIDSet[0].Hex(), cartItemObjIDSet[0].Hex()
+	cart := &service.Cart{
+		ID:      cartObjIDSet[0],
+		Version: 3,
+		Items: []service.CartItem{{
+			ID:          cartItemObjIDSet[0],
+			CartID:      cartObjIDSet[0],
+			ProductName: "apples",
+			Quantity:    1.5,
+			Unit:        units.Kilogram,
+			Attributes:  service.Attributes{"organic": true},
+		}},
+	}
+	added := service.CartItem{
+		ID:          cartItemObjIDSet[1],
+		CartID:      cartObjIDSet[0],
+		ProductName: "shirt",
+		Quantity:    1,
+		Unit:        units.Piece,
+		VariantID:   "shirt-red-m",
+		Attributes:  service.Attributes{"size": float64(42)},
+	}
+	updated := cart.Items[0]
+	updated.Quantity = 2
+
+	tt := []struct {
+		name             string
+		query            string
+		variables        map[string]interface{}
+		expect           func(mock *mocks.MockService)
+		expectedResponse string
+	}{
+		{
+			name:  "cart is read once per request",
+			query: fmt.Sprintf(`{a: cart(id: %[1]q) { id version items { product quantity unit attributes cart { version } } } b: cart(id: %[1]q) { id }}`, cartID),
+			expect: func(mock *mocks.MockService) {
+				mock.EXPECT().Cart(gomock.Any(), cartID).Times(1).Return(cart, nil)
+			},
+			expectedResponse: fmt.Sprintf(`{"data":{
+				"a":{"id":%[1]q,"version":3,"items":[{"product":"apples","quantity":1.5,"unit":"kg","attributes":{"organic":true},"cart":{"version":3}}]},
+				"b":{"id":%[1]q}}}`, cartID),
+		},
+		{
+			name:  "missing cart is null",
+			query: fmt.Sprintf(`{cart(id: %q) { id }}`, cartObjIDSet[1].Hex()),
+			expect: func(mock *mocks.MockService) {
+				mock.EXPECT().Cart(gomock.Any(), cartObjIDSet[1].Hex()).Times(1).Return(nil, errors.Wrap(mongo.ErrNotFound, "no carts"))
+			},
+			expectedResponse: `{"data":{"cart":null}}`,
+		},
+		{
+			name:  "malformed cart id",
+			query: `{cart(id: "cart-1") { id }}`,
+			expectedResponse: `{"errors":[{"message":"cart id is not valid","path":["cart"],"extensions":{"code":"BAD_USER_INPUT","fields":[
+				{"field":"id","rule":"pattern","message":"must contain only 24 hexadecimal digits"}]}}],"data":{"cart":null}}`,
+		},
+		{
+			name:      "cart item",
+			query:     `query($cart: ID!, $item: ID!) { cartItem(cartId: $cart, itemId: $item) { id product variantId } }`,
+			variables: map[string]interface{}{"cart": cartID, "item": itemID},
+			expect: func(mock *mocks.MockService) {
+				mock.EXPECT().Cart(gomock.Any(), cartID).Times(1).Return(cart, nil)
+			},
+			expectedResponse: fmt.Sprintf(`{"data":{"cartItem":{"id":%q,"product":"apples","variantId":null}}}`, itemID),
+		},
+		{
+			name: "add item",
+			query: fmt.Sprintf(`mutation { addItem(cartId: %q, item: {product: "shirt", quantity: 1, variantId: "shirt-red-m", attributes: {size: 42}}) {
+				id unit attributes cart { version } } }`, cartID),
+			expect: func(mock *mocks.MockService) {
+				mock.EXPECT().AddItemToCart(gomock.Any(), cartID, service.CartItem{
+					ProductName: "shirt",
+					Quantity:    1,
+					Unit:        units.Piece,
+					VariantID:   "shirt-red-m",
+					Attributes:  service.Attributes{"size": float64(42)},
+				}).Times(1).Return(&added, nil)
+				mock.EXPECT().Cart(gomock.Any(), cartID).Times(1).Return(cart, nil)
+			},
+			expectedResponse: fmt.Sprintf(`{"data":{"addItem":{"id":%q,"unit":"piece","attributes":{"size":42},"cart":{"version":3}}}}`,
+				added.ID.Hex()),
+		},
+		{
+			name:  "invalid item",
+			query: fmt.Sprintf(`mutation { addItem(cartId: %q, item: {product: "", quantity: 1.5}) { id } }`, cartID),
+			expectedResponse: `{"errors":[{"message":"item is not valid","path":["addItem"],"extensions":{"code":"BAD_USER_INPUT","fields":[
+				{"field":"product","rule":"required","message":"must be set"},
+				{"field":"quantity","rule":"integer","message":"must be a whole number"}]}}],"data":null}`,
+		},
+		{
+			name:  "update item",
+			query: fmt.Sprintf(`mutation { updateItem(cartId: %q, itemId: %q, quantity: 2) { quantity unit } }`, cartID, itemID),
+			expect: func(mock *mocks.MockService) {
+				mock.EXPECT().Cart(gomock.Any(), cartID).Times(1).Return(cart, nil)
+				mock.EXPECT().ApplyItemOperations(gomock.Any(), cartID, cart.Version, []service.ItemOperation{
+					{Op: service.OpUpdate, ItemID: itemID, Item: service.CartItem{Quantity: 2, Unit: units.Kilogram}},
+				}).Times(1).Return([]service.ItemOperationResult{{Op: service.OpUpdate, Item: &updated}}, nil)
+			},
+			expectedResponse: `{"data":{"updateItem":{"quantity":2,"unit":"kg"}}}`,
+		},
+		{
+			name:  "remove missing item",
+			query: fmt.Sprintf(`mutation { removeItem(cartId: %q, itemId: %q) }`, cartID, cartItemObjIDSet[1].Hex()),
+			expect: func(mock *mocks.MockService) {
+				mock.EXPECT().RemoveItemFromCart(gomock.Any(), cartID, cartItemObjIDSet[1].Hex()).Times(1).
+					Return(errors.Wrap(mongo.ErrNotFound, "no carts or items"))
+			},
+			expectedResponse: `{"errors":[{"message":"could not remove item from cart: no carts or items: not found","path":["removeItem"],
+				"extensions":{"code":"NOT_FOUND"}}],"data":null}`,
+		},
+		{
+			name:             "query is too deep",
+			query:            fmt.Sprintf(`{cart(id: %q) { items { cart { items { cart { items { id } } } } } }}`, cartID),
+			expectedResponse: `{"errors":[{"message":"Field \"id\" has depth 7 that exceeds max depth 6","locations":[{"line":1,"column":79}]}]}`,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock := mocks.NewMockService(ctrl)
+	server := httptest.NewServer(New(mock))
+	defer server.Close()
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.expect != nil {
+				tc.expect(mock)
+			}
+			body, err := json.Marshal(graphQLRequest{Query: tc.query, Variables: tc.variables})
+			require.NoError(t, err)
+			req, err := http.NewRequest(http.MethodPost, server.URL+"/graphql", strings.NewReader(string(body)))
+			require.NoError(t, err, "could not create request")
+			req.Header.Set("Content-Type", jsonContentType)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "could not get response")
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err, "could not read response")
+			assert.Equal(t, http.StatusOK, resp.StatusCode, "Two status codes should be the same")
+			assert.JSONEq(t, tc.expectedResponse, string(b), "Two response bodies should be the same")
+		})
+	}
+}
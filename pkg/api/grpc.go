@@ -0,0 +1,207 @@
+//go:generate protoc -I . --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative cartpb/cart.proto
+package api
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/api/cartpb"
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// GRPC returns gRPC server of cartpb.CartService sharing service, logger, metrics and unit catalog with s.
+// Requests get request IDs, traces, access logs and metrics as REST requests do.
+// opts configure the server, e.g. grpc.Creds with TLS config used for REST.
+func (s *Server) GRPC(opts ...grpc.ServerOption) *grpc.Server {
+	interceptors := grpc.ChainUnaryInterceptor(
+		grpcRequestID(s.logger),
+		grpcTracing,
+		grpcAccessLog,
+		grpcMetrics(s.metrics),
+		grpcRecovery,
+	)
+	g := grpc.NewServer(append([]grpc.ServerOption{interceptors}, opts...)...)
+	cartpb.RegisterCartServiceServer(g, &cartServer{s: s})
+	return g
+}
+
+// cartServer implements cartpb.CartServiceServer on top of service.Service.
+type cartServer struct {
+	cartpb.UnimplementedCartServiceServer
+	s *Server
+}
+
+func (c *cartServer) CreateCart(ctx context.Context, _ *cartpb.CreateCartRequest) (*cartpb.Cart, error) {
+	cart, err := c.s.service.AddCart(ctx)
+	if err != nil {
+		return nil, c.error(ctx, err, "could not add cart")
+	}
+	return cartToProto(cart)
+}
+
+func (c *cartServer) GetCart(ctx context.Context, req *cartpb.GetCartRequest) (*cartpb.Cart, error) {
+	if err := validation.Validate(validation.Field("cart_id", req.CartId, validation.Required[string]())); err != nil {
+		return nil, validationStatus(err)
+	}
+	cart, err := c.s.service.Cart(ctx, req.CartId)
+	if err != nil {
+		return nil, c.error(ctx, err, "could not get cart", slog.String(logger.CartIDKey, req.CartId))
+	}
+	return cartToProto(cart)
+}
+
+func (c *cartServer) DeleteCart(ctx context.Context, req *cartpb.DeleteCartRequest) (*emptypb.Empty, error) {
+	if err := validation.Validate(validation.Field("cart_id", req.CartId, validation.Required[string]())); err != nil {
+		return nil, validationStatus(err)
+	}
+	if err := c.s.service.DeleteCart(ctx, req.CartId); err != nil {
+		return nil, c.error(ctx, err, "could not delete cart", slog.String(logger.CartIDKey, req.CartId))
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (c *cartServer) AddItem(ctx context.Context, req *cartpb.AddItemRequest) (*cartpb.CartItem, error) {
+	if err := validation.Validate(validation.Field("cart_id", req.CartId, validation.Required[string]())); err != nil {
+		return nil, validationStatus(err)
+	}
+	item := newItem{
+		ProductName: req.Product,
+		Quantity:    req.Quantity,
+		Unit:        units.Unit(req.Unit),
+		VariantID:   req.VariantId,
+	}
+	if req.Attributes != nil {
+		item.Attributes = req.Attributes.AsMap()
+	}
+	spec := c.s.units.Spec(item.ProductName)
+	if item.Unit == "" {
+		item.Unit = spec.DefaultUnit()
+	}
+	if err := item.validate(spec); err != nil {
+		return nil, validationStatus(err)
+	}
+
+	cartItem, err := c.s.service.AddItemToCart(ctx, req.CartId, item.cartItem(c.s.products))
+	if err != nil {
+		return nil, c.error(ctx, err, "could not add item to cart", slog.String(logger.CartIDKey, req.CartId))
+	}
+	return itemToProto(cartItem)
+}
+
+// UpdateItem sets quantity and unit of an item if the cart is not changed since it was read,
+// Aborted status is returned otherwise, so the client may retry.
+func (c *cartServer) UpdateItem(ctx context.Context, req *cartpb.UpdateItemRequest) (*cartpb.CartItem, error) {
+	err := validation.Validate(
+		validation.Field("cart_id", req.CartId, validation.Required[string]()),
+		validation.Field("item_id", req.ItemId, validation.Required[string]()),
+	)
+	if err != nil {
+		return nil, validationStatus(err)
+	}
+	item, err := c.s.updateItem(ctx, req.CartId, req.ItemId, req.Quantity, units.Unit(req.Unit))
+	if _, ok := err.(validation.Errors); ok {
+		return nil, validationStatus(err)
+	}
+	if err != nil {
+		return nil, c.error(ctx, err, "could not update item",
+			slog.String(logger.CartIDKey, req.CartId), slog.String(logger.ItemIDKey, req.ItemId))
+	}
+	return itemToProto(item)
+}
+
+func (c *cartServer) RemoveItem(ctx context.Context, req *cartpb.RemoveItemRequest) (*emptypb.Empty, error) {
+	err := validation.Validate(
+		validation.Field("cart_id", req.CartId, validation.Required[string]()),
+		validation.Field("item_id", req.ItemId, validation.Required[string]()),
+	)
+	if err != nil {
+		return nil, validationStatus(err)
+	}
+	if err := c.s.service.RemoveItemFromCart(ctx, req.CartId, req.ItemId); err != nil {
+		return nil, c.error(ctx, err, "could not remove item from cart",
+			slog.String(logger.CartIDKey, req.CartId), slog.String(logger.ItemIDKey, req.ItemId))
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// error converts err returned by the service into gRPC status prefixed with msg.
+// Unexpected errors are logged and reported as Internal.
+func (c *cartServer) error(ctx context.Context, err error, msg string, attrs ...slog.Attr) error {
+	switch cause := errors.Cause(err); cause {
+	case service.ErrNotFound:
+		return status.Errorf(codes.NotFound, "%s: %s", msg, err)
+	case service.ErrVersionConflict:
+		return status.Errorf(codes.Aborted, "%s: cart is changed concurrently: %s", msg, err)
+	case service.ErrOperationsFailed, inventory.ErrIncompatibleUnit:
+		return status.Errorf(codes.FailedPrecondition, "%s: %s", msg, err)
+	default:
+		if _, ok := cause.(*inventory.InsufficientStockError); ok {
+			return status.Errorf(codes.FailedPrecondition, "%s: %s", msg, err)
+		}
+	}
+	logger.FromContext(ctx, c.s.logger).LogAttrs(ctx, slog.LevelError, msg, append(attrs, logger.Err(err))...)
+	return status.Errorf(codes.Internal, "%s: %s", msg, err)
+}
+
+// validationStatus returns InvalidArgument status with BadRequest details listing every violation of err.
+func validationStatus(err error) error {
+	fields, ok := err.(validation.Errors)
+	if !ok {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	br := &errdetails.BadRequest{}
+	for _, f := range fields {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       f.Field,
+			Description: f.Message,
+		})
+	}
+	st, detailsErr := status.New(codes.InvalidArgument, "request is not valid").WithDetails(br)
+	if detailsErr != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return st.Err()
+}
+
+func cartToProto(cart *service.Cart) (*cartpb.Cart, error) {
+	pb := &cartpb.Cart{Id: cart.ID.Hex(), Version: cart.Version, Items: make([]*cartpb.CartItem, 0, len(cart.Items))}
+	for i := range cart.Items {
+		item, err := itemToProto(&cart.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		pb.Items = append(pb.Items, item)
+	}
+	return pb, nil
+}
+
+func itemToProto(item *service.CartItem) (*cartpb.CartItem, error) {
+	pb := &cartpb.CartItem{
+		Id:        item.ID.Hex(),
+		CartId:    item.CartID.Hex(),
+		Product:   item.ProductName,
+		Quantity:  item.Quantity,
+		Unit:      string(withDefaultUnit(*item).Unit),
+		VariantId: item.VariantID,
+	}
+	if len(item.Attributes) > 0 {
+		attrs, err := structpb.NewStruct(item.Attributes)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not encode attributes of item %s: %s", pb.Id, err)
+		}
+		pb.Attributes = attrs
+	}
+	return pb, nil
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/audit"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/metrics"
+	"github.com/HarlamovBuldog/cart_api/pkg/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDMetadataKey is the metadata key used to receive and propagate request IDs of gRPC calls.
+var requestIDMetadataKey = strings.ToLower(RequestIDHeader)
+
+// grpcRequestID takes request ID from x-request-id metadata or generates a new one,
+// puts it, a request scoped logger and audit actor into call context and sends it back in response headers.
+func grpcRequestID(l *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var id string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get(requestIDMetadataKey); len(v) > 0 {
+				id = v[0]
+			}
+		}
+		if !isRequestIDValid(id) {
+			id = newRequestID()
+		}
+		if err := grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, id)); err != nil {
+			l.Warn("could not send request id", logger.Err(err))
+		}
+		ctx = context.WithValue(ctx, requestIDKey, id)
+		ctx = logger.NewContext(ctx, l.With(slog.String(logger.RequestIDKey, id)))
+		ctx = audit.NewContext(ctx, audit.Actor{Name: actorName(peerCommonName(ctx)), RequestID: id})
+		return handler(ctx, req)
+	}
+}
+
+// grpcTracing continues trace from W3C traceparent metadata or starts a new one
+// and wraps the call into a server span named by full method name.
+// Trace ID is added to the request scoped logger.
+func grpcTracing(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	svc, method := splitMethod(info.FullMethod)
+	ctx, span := tracing.Tracer().Start(ctx, strings.TrimPrefix(info.FullMethod, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.RPCSystemGRPC,
+			semconv.RPCService(svc),
+			semconv.RPCMethod(method),
+			attribute.String(logger.RequestIDKey, RequestID(ctx)),
+		))
+	defer span.End()
+
+	if sc := span.SpanContext(); sc.IsValid() {
+		ctx = logger.NewContext(ctx, logger.FromContext(ctx, nil).With(slog.String(logger.TraceIDKey, sc.TraceID().String())))
+	}
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+	if isServerError(code) {
+		span.SetStatus(otelcodes.Error, code.String())
+	}
+	return resp, err
+}
+
+// grpcAccessLog writes a line per call with method, status code and latency.
+func grpcAccessLog(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	attrs := []slog.Attr{
+		slog.String("method", info.FullMethod),
+		slog.String("code", status.Code(err).String()),
+		slog.Duration("latency", time.Since(start)),
+	}
+	if cn := peerCommonName(ctx); cn != "" {
+		attrs = append(attrs, slog.String("client_cn", cn))
+	}
+	logger.FromContext(ctx, nil).LogAttrs(ctx, slog.LevelInfo, "request served", attrs...)
+	return resp, err
+}
+
+// grpcMetrics counts calls and observes their latency by method.
+func grpcMetrics(m *metrics.Metrics) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.ObserveGRPCRequest(info.FullMethod, status.Code(err).String(), time.Since(start))
+		return resp, err
+	}
+}
+
+// grpcRecovery turns a panic in a handler into Internal status.
+func grpcRecovery(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.FromContext(ctx, nil).Error("handler panicked",
+				slog.Any("panic", p), slog.String("stack", string(debug.Stack())))
+			err = status.Error(codes.Internal, "internal server error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// metadataCarrier adapts incoming gRPC metadata to propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// splitMethod splits full method name /cart.v1.CartService/GetCart into service and method names.
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+// isServerError tells if code reports a failure of the server rather than of the request.
+func isServerError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// peerCommonName returns common name of the verified client certificate presented over mutual TLS.
+// Func returns empty string for plaintext connections and for clients without certificate.
+func peerCommonName(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return info.State.VerifiedChains[0][0].Subject.CommonName
+}
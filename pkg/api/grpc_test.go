@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/api/cartpb"
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/mongo"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// newGRPCClient serves gRPC API of s over in-memory connection and returns its client.
+func newGRPCClient(t *testing.T, s *Server) cartpb.CartServiceClient {
+	l := bufconn.Listen(1 << 20)
+	g := s.GRPC()
+	go g.Serve(l)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err, "could not create client")
+	t.Cleanup(func() { conn.Close() })
+	return cartpb.NewCartServiceClient(conn)
+}
+
+// fieldViolations returns fields listed in BadRequest details of err.
+func fieldViolations(err error) []string {
+	var fields []string
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				fields = append(fields, v.Field)
+			}
+		}
+	}
+	return fields
+}
+
+func Test_grpcCarts(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(2)
+	cartItemObjIDSet := generatePrimObjIDSet(1)
+	cart := &service.Cart{
+		ID:      cartObjIDSet[0],
+		Version: 2,
+		Items: []service.CartItem{{
+			ID:          cartItemObjIDSet[0],
+			CartID:      cartObjIDSet[0],
+			ProductName: "product_1",
+			Quantity:    2,
+			Attributes:  service.Attributes{"color": "red"},
+		}},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock := mocks.NewMockService(ctrl)
+	client := newGRPCClient(t, New(mock))
+	ctx := context.Background()
+
+	t.Run("create", func(t *testing.T) {
+		mock.EXPECT().AddCart(gomock.Any()).Return(&service.Cart{ID: cartObjIDSet[1], Items: []service.CartItem{}}, nil)
+		resp, err := client.CreateCart(ctx, &cartpb.CreateCartRequest{})
+		require.NoError(t, err)
+		assert.True(t, proto.Equal(&cartpb.Cart{Id: cartObjIDSet[1].Hex(), Items: []*cartpb.CartItem{}}, resp))
+	})
+
+	t.Run("get", func(t *testing.T) {
+		mock.EXPECT().Cart(gomock.Any(), cartObjIDSet[0].Hex()).Return(cart, nil)
+		resp, err := client.GetCart(ctx, &cartpb.GetCartRequest{CartId: cartObjIDSet[0].Hex()})
+		require.NoError(t, err)
+		attrs, err := structpb.NewStruct(map[string]interface{}{"color": "red"})
+		require.NoError(t, err)
+		expected := &cartpb.Cart{
+			Id:      cartObjIDSet[0].Hex(),
+			Version: 2,
+			Items: []*cartpb.CartItem{{
+				Id:         cartItemObjIDSet[0].Hex(),
+				CartId:     cartObjIDSet[0].Hex(),
+				Product:    "product_1",
+				Quantity:   2,
+				Unit:       "piece",
+				Attributes: attrs,
+			}},
+		}
+		assert.True(t, proto.Equal(expected, resp), "Unexpected cart %v", resp)
+	})
+
+	t.Run("get missing cart", func(t *testing.T) {
+		mock.EXPECT().Cart(gomock.Any(), cartObjIDSet[1].Hex()).Return(nil, errors.Wrap(mongo.ErrNotFound, "no carts"))
+		_, err := client.GetCart(ctx, &cartpb.GetCartRequest{CartId: cartObjIDSet[1].Hex()})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("get without id", func(t *testing.T) {
+		_, err := client.GetCart(ctx, &cartpb.GetCartRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, []string{"cart_id"}, fieldViolations(err))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		mock.EXPECT().DeleteCart(gomock.Any(), cartObjIDSet[0].Hex()).Return(nil)
+		_, err := client.DeleteCart(ctx, &cartpb.DeleteCartRequest{CartId: cartObjIDSet[0].Hex()})
+		assert.NoError(t, err)
+	})
+
+	t.Run("remove item", func(t *testing.T) {
+		mock.EXPECT().RemoveItemFromCart(gomock.Any(), cartObjIDSet[0].Hex(), cartItemObjIDSet[0].Hex()).
+			Return(errors.New("connection refused"))
+		_, err := client.RemoveItem(ctx, &cartpb.RemoveItemRequest{CartId: cartObjIDSet[0].Hex(), ItemId: cartItemObjIDSet[0].Hex()})
+		assert.Equal(t, codes.Internal, status.Code(err))
+		assert.Equal(t, "could not remove item from cart: connection refused", status.Convert(err).Message())
+	})
+}
+
+func Test_grpcAddItem(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	cartItemObjIDSet := generatePrimObjIDSet(1)
+	cartID := cartObjIDSet[0].Hex()
+	catalog := units.Catalog{"apples": {Units: []units.Unit{units.Kilogram, units.Gram}, Precision: 3}}
+	attrs, err := structpb.NewStruct(map[string]interface{}{"size": 42})
+	require.NoError(t, err)
+
+	tt := []struct {
+		name           string
+		request        *cartpb.AddItemRequest
+		expectedItem   *service.CartItem
+		addedItem      *service.CartItem
+		addErr         error
+		expectedCode   codes.Code
+		expectedFields []string
+	}{
+		{
+			name:    "correct test",
+			request: &cartpb.AddItemRequest{CartId: cartID, Product: "apples", Quantity: 1.5, Attributes: attrs},
+			expectedItem: &service.CartItem{
+				ProductName: "apples",
+				Quantity:    1.5,
+				Unit:        units.Kilogram,
+				Attributes:  service.Attributes{"size": float64(42)},
+			},
+			addedItem: &service.CartItem{
+				ID:          cartItemObjIDSet[0],
+				CartID:      cartObjIDSet[0],
+				ProductName: "apples",
+				Quantity:    1.5,
+				Unit:        units.Kilogram,
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name:           "invalid item",
+			request:        &cartpb.AddItemRequest{CartId: cartID, Product: "apples", Quantity: -1, Unit: "l"},
+			expectedCode:   codes.InvalidArgument,
+			expectedFields: []string{"quantity", "unit"},
+		},
+		{
+			name:         "missing cart",
+			request:      &cartpb.AddItemRequest{CartId: cartID, Product: "pears", Quantity: 1},
+			expectedItem: &service.CartItem{ProductName: "pears", Quantity: 1, Unit: units.Piece},
+			addErr:       errors.Wrap(mongo.ErrNotFound, "no carts"),
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "insufficient stock",
+			request:      &cartpb.AddItemRequest{CartId: cartID, Product: "pears", Quantity: 3},
+			expectedItem: &service.CartItem{ProductName: "pears", Quantity: 3, Unit: units.Piece},
+			addErr:       &inventory.InsufficientStockError{Product: "pears", Requested: 3, Available: 1, Unit: units.Piece},
+			expectedCode: codes.FailedPrecondition,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock := mocks.NewMockService(ctrl)
+	client := newGRPCClient(t, New(mock, WithUnitCatalog(catalog)))
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.expectedItem != nil {
+				mock.EXPECT().AddItemToCart(gomock.Any(), cartID, *tc.expectedItem).Times(1).Return(tc.addedItem, tc.addErr)
+			}
+			resp, err := client.AddItem(context.Background(), tc.request)
+			assert.Equal(t, tc.expectedCode, status.Code(err), "Two status codes should be the same")
+			assert.Equal(t, tc.expectedFields, fieldViolations(err))
+			if tc.expectedCode == codes.OK {
+				assert.Equal(t, cartItemObjIDSet[0].Hex(), resp.Id)
+				assert.Equal(t, "kg", resp.Unit)
+			}
+		})
+	}
+}
+
+func Test_grpcUpdateItem(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	cartItemObjIDSet := generatePrimObjIDSet(2)
+	cartID := cartObjIDSet[0].Hex()
+	itemID := cartItemObjIDSet[0].Hex()
+	cart := &service.Cart{
+		ID:      cartObjIDSet[0],
+		Version: 7,
+		Items: []service.CartItem{
+			{ID: cartItemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "apples", Quantity: 1, Unit: units.Kilogram},
+		},
+	}
+	updated := cart.Items[0]
+	updated.Quantity, updated.Unit = 500, units.Gram
+
+	tt := []struct {
+		name         string
+		request      *cartpb.UpdateItemRequest
+		expectedOps  []service.ItemOperation
+		results      []service.ItemOperationResult
+		applyErr     error
+		expectedCode codes.Code
+	}{
+		{
+			name:    "correct test",
+			request: &cartpb.UpdateItemRequest{CartId: cartID, ItemId: itemID, Quantity: 500, Unit: "g"},
+			expectedOps: []service.ItemOperation{
+				{Op: service.OpUpdate, ItemID: itemID, Item: service.CartItem{Quantity: 500, Unit: units.Gram}},
+			},
+			results:      []service.ItemOperationResult{{Op: service.OpUpdate, Item: &updated}},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "item is missing",
+			request:      &cartpb.UpdateItemRequest{CartId: cartID, ItemId: cartItemObjIDSet[1].Hex(), Quantity: 2},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "fractional pieces",
+			request:      &cartpb.UpdateItemRequest{CartId: cartID, ItemId: itemID, Quantity: 1.5, Unit: "piece"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:    "cart is changed concurrently",
+			request: &cartpb.UpdateItemRequest{CartId: cartID, ItemId: itemID, Quantity: 2},
+			expectedOps: []service.ItemOperation{
+				{Op: service.OpUpdate, ItemID: itemID, Item: service.CartItem{Quantity: 2, Unit: units.Kilogram}},
+			},
+			applyErr:     errors.Wrap(service.ErrVersionConflict, "cart version is 8"),
+			expectedCode: codes.Aborted,
+		},
+		{
+			name:    "operation failed",
+			request: &cartpb.UpdateItemRequest{CartId: cartID, ItemId: itemID, Quantity: 2},
+			expectedOps: []service.ItemOperation{
+				{Op: service.OpUpdate, ItemID: itemID, Item: service.CartItem{Quantity: 2, Unit: units.Kilogram}},
+			},
+			results:      []service.ItemOperationResult{{Op: service.OpUpdate, Error: "item is not found"}},
+			applyErr:     errors.Wrap(service.ErrOperationsFailed, "no operations are applied"),
+			expectedCode: codes.FailedPrecondition,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock := mocks.NewMockService(ctrl)
+	client := newGRPCClient(t, New(mock))
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			mock.EXPECT().Cart(gomock.Any(), cartID).Times(1).Return(cart, nil)
+			if tc.expectedOps != nil {
+				mock.EXPECT().ApplyItemOperations(gomock.Any(), cartID, cart.Version, tc.expectedOps).Times(1).
+					Return(tc.results, tc.applyErr)
+			}
+			resp, err := client.UpdateItem(context.Background(), tc.request)
+			assert.Equal(t, tc.expectedCode, status.Code(err), "Two status codes should be the same: %v", err)
+			if tc.expectedCode == codes.OK {
+				assert.Equal(t, float64(500), resp.Quantity)
+				assert.Equal(t, "g", resp.Unit)
+			}
+		})
+	}
+}
+
+func Test_grpcRequestID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock := mocks.NewMockService(ctrl)
+	mock.EXPECT().AddCart(gomock.Any()).Times(2).DoAndReturn(func(ctx context.Context) (*service.Cart, error) {
+		assert.NotEmpty(t, RequestID(ctx), "Request ID should be available to the service")
+		return &service.Cart{}, nil
+	})
+	client := newGRPCClient(t, New(mock))
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "checkout-42")
+	_, err := client.CreateCart(ctx, &cartpb.CreateCartRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"checkout-42"}, header.Get("x-request-id"), "Request ID should be echoed")
+
+	_, err = client.CreateCart(context.Background(), &cartpb.CreateCartRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Len(t, header.Get("x-request-id"), 1, "Request ID should be generated")
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+)
+
+// defaultReadinessTimeout limits a dependency check made by readiness probe.
+const defaultReadinessTimeout = 2 * time.Second
+
+// Pinger checks that a dependency of the service is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// WithReadinessCheck makes /readyz report not ready when p fails to respond within timeout.
+// Zero timeout means default of 2 seconds.
+func WithReadinessCheck(p Pinger, timeout time.Duration) Option {
+	return func(s *Server) {
+		s.pinger = p
+		if timeout > 0 {
+			s.readinessTimeout = timeout
+		}
+	}
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Drain makes readiness probe fail, so that load balancers stop routing new requests to the server,
+// and ends event streams, so that clients reconnect to other instances.
+// It must be called before http.Server.Shutdown.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+	s.drainOnce.Do(func() { close(s.drained) })
+}
+
+// liveness reports that the process is running and able to serve http requests.
+func (s *Server) liveness(w http.ResponseWriter, req *http.Request) {
+	s.writeHealth(w, req, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// readiness reports whether the server should receive traffic: it is not draining and database responds to ping.
+func (s *Server) readiness(w http.ResponseWriter, req *http.Request) {
+	if s.draining.Load() {
+		s.writeHealth(w, req, http.StatusServiceUnavailable, healthResponse{Status: "draining"})
+		return
+	}
+	if s.pinger != nil {
+		ctx, cancel := context.WithTimeout(req.Context(), s.readinessTimeout)
+		defer cancel()
+		if err := s.pinger.Ping(ctx); err != nil {
+			s.log(req).Warn("readiness check failed", logger.Err(err))
+			s.writeHealth(w, req, http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Error: err.Error()})
+			return
+		}
+	}
+	s.writeHealth(w, req, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+func (s *Server) writeHealth(w http.ResponseWriter, req *http.Request, status int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.log(req).Error("could not encode json", logger.Err(err))
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pingerFunc func(ctx context.Context) error
+
+func (f pingerFunc) Ping(ctx context.Context) error {
+	return f(ctx)
+}
+
+func Test_health(t *testing.T) {
+	tt := []struct {
+		name             string
+		path             string
+		pingErr          error
+		pingDelay        time.Duration
+		drain            bool
+		expectedResponse string
+		expectedStatus   int
+	}{
+		{
+			name:             "alive",
+			path:             "/healthz",
+			pingErr:          errors.New("connection refused"),
+			expectedResponse: `{"status":"ok"}`,
+			expectedStatus:   http.StatusOK,
+		},
+		{
+			name:             "alive while draining",
+			path:             "/healthz",
+			drain:            true,
+			expectedResponse: `{"status":"ok"}`,
+			expectedStatus:   http.StatusOK,
+		},
+		{
+			name:             "ready",
+			path:             "/readyz",
+			expectedResponse: `{"status":"ok"}`,
+			expectedStatus:   http.StatusOK,
+		},
+		{
+			name:             "database is unavailable",
+			path:             "/readyz",
+			pingErr:          errors.New("connection refused"),
+			expectedResponse: `{"status":"unavailable","error":"connection refused"}`,
+			expectedStatus:   http.StatusServiceUnavailable,
+		},
+		{
+			name:             "database ping timed out",
+			path:             "/readyz",
+			pingDelay:        time.Second,
+			expectedResponse: `{"status":"unavailable","error":"context deadline exceeded"}`,
+			expectedStatus:   http.StatusServiceUnavailable,
+		},
+		{
+			name:             "draining",
+			path:             "/readyz",
+			drain:            true,
+			expectedResponse: `{"status":"draining"}`,
+			expectedStatus:   http.StatusServiceUnavailable,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			pinger := pingerFunc(func(ctx context.Context) error {
+				select {
+				case <-time.After(tc.pingDelay):
+					return tc.pingErr
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			s := New(mocks.NewMockService(ctrl), WithReadinessCheck(pinger, 50*time.Millisecond))
+			if tc.drain {
+				s.Drain()
+			}
+
+			server := httptest.NewServer(s)
+			defer server.Close()
+
+			resp, err := http.Get(fmt.Sprintf("%s%s", server.URL, tc.path))
+			require.NoError(t, err, "could not get response")
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err, "could not read response")
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "Two status codes should be the same")
+			assert.Equal(t, tc.expectedResponse, string(bytes.TrimSpace(b)), "Two response bodies should be the same")
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/audit"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+var objectIDRe = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// WithAuditLog enables GET /carts/{cart_id}/history listing changes of a cart recorded in l,
+// GET /carts/{cart_id}?at=<time> and POST /carts/{cart_id}/undo.
+func WithAuditLog(l audit.Log) Option {
+	return func(s *Server) {
+		s.audit = l
+	}
+}
+
+type historyResponse struct {
+	Entries []audit.Entry `json:"entries"`
+}
+
+// cartHistory returns audit entries of a cart, latest first. Older entries are paged with ?before=<entry id>.
+func (s *Server) cartHistory(w http.ResponseWriter, req *http.Request) {
+	cartID := mux.Vars(req)["cart_id"]
+	before := req.URL.Query().Get("before")
+	limit, errs := queryLimit(req, defaultHistoryLimit, maxHistoryLimit)
+	errs = append(errs, validation.Field("before", before, validation.Matches(objectIDRe, "24 hexadecimal digits"))...)
+	if len(errs) > 0 {
+		writeErrorResponse(w, req, http.StatusBadRequest, errorResponse{Error: "query is not valid", Fields: errs})
+		return
+	}
+
+	entries, err := s.audit.CartHistory(req.Context(), cartID, before, limit)
+	if err != nil {
+		err = errors.Wrap(err, "could not get cart history")
+		s.log(req).Error("could not get cart history", logger.Err(err))
+		writeJSONError(w, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, req, http.StatusOK, historyResponse{Entries: entries})
+}
+
+// viewCartAt writes cart as it was at time at, given in RFC 3339 format.
+func (s *Server) viewCartAt(w http.ResponseWriter, req *http.Request, cartID, at string) {
+	if s.audit == nil {
+		writeJSONError(w, req, http.StatusBadRequest, "cart history is not recorded")
+		return
+	}
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		writeErrorResponse(w, req, http.StatusBadRequest, errorResponse{Error: "query is not valid", Fields: []validation.FieldError{
+			{Field: "at", Rule: "time", Message: "must be a time in RFC 3339 format"},
+		}})
+		return
+	}
+
+	cart, err := s.audit.CartAt(req.Context(), cartID, t)
+	if err != nil {
+		err = errors.Wrap(err, "could not get cart")
+		switch errors.Cause(err) {
+		case service.ErrNotFound:
+			writeJSONError(w, req, http.StatusNotFound, err.Error())
+			return
+		case audit.ErrIncompleteHistory:
+			writeJSONError(w, req, http.StatusConflict, err.Error())
+			return
+		}
+		s.log(req).Error("could not get cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+		writeJSONError(w, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeCart(w, req, cart)
+}
+
+// undo reverts the latest change of cart items and writes the changed cart.
+func (s *Server) undo(w http.ResponseWriter, req *http.Request) {
+	cartID := mux.Vars(req)["cart_id"]
+	cart, err := s.audit.Undo(req.Context(), cartID)
+	if err != nil {
+		err = errors.Wrap(err, "could not undo change")
+		switch errors.Cause(err) {
+		case service.ErrNotFound:
+			writeJSONError(w, req, http.StatusNotFound, err.Error())
+		case audit.ErrNothingToUndo, service.ErrVersionConflict:
+			writeJSONError(w, req, http.StatusConflict, err.Error())
+		default:
+			if writeStockError(w, req, err) {
+				return
+			}
+			s.log(req).Error("could not undo change", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+			writeJSONError(w, req, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	writeJSON(w, req, http.StatusOK, cart)
+}
+
+// queryLimit returns value of limit query parameter, def if it is absent, and violations if it is not
+// a whole number from 1 to max.
+func queryLimit(req *http.Request, def, max int) (int, []validation.FieldError) {
+	v := req.URL.Query().Get("limit")
+	if v == "" {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil {
+		return def, []validation.FieldError{{Field: "limit", Rule: "integer", Message: "must be a whole number"}}
+	}
+	return limit, validation.Field("limit", limit, validation.Positive[int](), validation.Max(max))
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/audit"
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_cartHistory(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	itemObjIDSet := generatePrimObjIDSet(1)
+	entryObjIDSet := generatePrimObjIDSet(1)
+	cartID := cartObjIDSet[0].Hex()
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	before := service.CartItem{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "milk", Quantity: 1, Unit: units.Piece}
+	after := before
+	after.Quantity = 3
+	entries := []audit.Entry{{
+		ID:        entryObjIDSet[0].Hex(),
+		CartID:    cartID,
+		Action:    events.ItemUpdated,
+		ItemID:    itemObjIDSet[0].Hex(),
+		Before:    &before,
+		After:     &after,
+		Actor:     "support-tool",
+		RequestID: "req-1",
+		Time:      at,
+	}}
+
+	tt := []struct {
+		name             string
+		query            string
+		historyBefore    string
+		historyLimit     int
+		historyOut       []audit.Entry
+		historyErr       error
+		expectedStatus   int
+		expectedResponse string
+	}{
+		{
+			name:           "latest entries",
+			historyLimit:   defaultHistoryLimit,
+			historyOut:     entries,
+			expectedStatus: http.StatusOK,
+			expectedResponse: fmt.Sprintf(`{"entries":[{"id":"%[1]s","cart_id":"%[2]s","action":"item_updated","item_id":"%[3]s",`+
+				`"before":{"id":"%[3]s","cart_id":"%[2]s","product":"milk","quantity":1,"unit":"piece"},`+
+				`"after":{"id":"%[3]s","cart_id":"%[2]s","product":"milk","quantity":3,"unit":"piece"},`+
+				`"actor":"support-tool","request_id":"req-1","time":"2024-01-02T03:04:05Z"}]}`,
+				entryObjIDSet[0].Hex(), cartID, itemObjIDSet[0].Hex()),
+		},
+		{
+			name:             "older page",
+			query:            "?limit=10&before=" + entryObjIDSet[0].Hex(),
+			historyBefore:    entryObjIDSet[0].Hex(),
+			historyLimit:     10,
+			historyOut:       []audit.Entry{},
+			expectedStatus:   http.StatusOK,
+			expectedResponse: `{"entries":[]}`,
+		},
+		{
+			name:           "invalid query",
+			query:          "?limit=0&before=latest",
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"query is not valid","request_id":"test-request","fields":[` +
+				`{"field":"limit","rule":"positive","message":"must be greater than 0"},` +
+				`{"field":"before","rule":"pattern","message":"must contain only 24 hexadecimal digits"}]}`,
+		},
+		{
+			name:             "database error",
+			historyLimit:     defaultHistoryLimit,
+			historyErr:       errors.New("connection refused"),
+			expectedStatus:   http.StatusInternalServerError,
+			expectedResponse: `{"error":"could not get cart history: connection refused","request_id":"test-request"}`,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mocks.NewMockLog(ctrl)
+	server := httptest.NewServer(New(mocks.NewMockService(ctrl), WithAuditLog(log)))
+	defer server.Close()
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.historyLimit > 0 {
+				log.EXPECT().CartHistory(gomock.Any(), cartID, tc.historyBefore, tc.historyLimit).
+					Times(1).Return(tc.historyOut, tc.historyErr)
+			}
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/carts/%s/history%s", server.URL, cartID, tc.query), nil)
+			require.NoError(t, err, "could not create request")
+			req.Header.Set(RequestIDHeader, "test-request")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "could not get response")
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err, "could not read response")
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "Two status codes should be the same")
+			assert.Equal(t, tc.expectedResponse, string(bytes.TrimSpace(b)), "Two response bodies should be the same")
+		})
+	}
+}
+
+func Test_viewCartAt(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	itemObjIDSet := generatePrimObjIDSet(2)
+	cartID := cartObjIDSet[0].Hex()
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	cart := service.Cart{ID: cartObjIDSet[0], Version: 2, Items: []service.CartItem{
+		{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "milk", Quantity: 1, Unit: units.Piece,
+			Attributes: service.Attributes{"fat": "3.2"}},
+		{ID: itemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "bread", Quantity: 1, Unit: units.Piece},
+	}}
+
+	tt := []struct {
+		name             string
+		query            string
+		cartAtOut        *service.Cart
+		cartAtErr        error
+		expectedStatus   int
+		expectedResponse string
+	}{
+		{
+			name:           "filtered by attribute",
+			query:          "?at=2024-01-02T03:04:05Z&attr.fat=3.2",
+			cartAtOut:      &cart,
+			expectedStatus: http.StatusOK,
+			expectedResponse: fmt.Sprintf(`{"id":"%[1]s","items":[{"id":"%[2]s","cart_id":"%[1]s","product":"milk",`+
+				`"quantity":1,"unit":"piece","attributes":{"fat":"3.2"}}],"version":2}`,
+				cartID, itemObjIDSet[0].Hex()),
+		},
+		{
+			name:           "created later",
+			query:          "?at=2024-01-02T03:04:05Z",
+			cartAtErr:      errors.Wrap(service.ErrNotFound, "cart is created at 2024-01-03T00:00:00Z"),
+			expectedStatus: http.StatusNotFound,
+			expectedResponse: `{"error":"could not get cart: cart is created at 2024-01-03T00:00:00Z: not found",` +
+				`"request_id":"test-request"}`,
+		},
+		{
+			name:           "changes not recorded",
+			query:          "?at=2024-01-02T03:04:05Z",
+			cartAtErr:      errors.Wrap(audit.ErrIncompleteHistory, "creation of cart is not recorded"),
+			expectedStatus: http.StatusConflict,
+			expectedResponse: `{"error":"could not get cart: creation of cart is not recorded: history of cart is incomplete",` +
+				`"request_id":"test-request"}`,
+		},
+		{
+			name:           "invalid time",
+			query:          "?at=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"query is not valid","request_id":"test-request","fields":[` +
+				`{"field":"at","rule":"time","message":"must be a time in RFC 3339 format"}]}`,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mocks.NewMockLog(ctrl)
+	server := httptest.NewServer(New(mocks.NewMockService(ctrl), WithAuditLog(log)))
+	defer server.Close()
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.cartAtOut != nil || tc.cartAtErr != nil {
+				log.EXPECT().CartAt(gomock.Any(), cartID, at).Times(1).Return(tc.cartAtOut, tc.cartAtErr)
+			}
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/carts/%s%s", server.URL, cartID, tc.query), nil)
+			require.NoError(t, err, "could not create request")
+			req.Header.Set(RequestIDHeader, "test-request")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "could not get response")
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err, "could not read response")
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "Two status codes should be the same")
+			assert.Equal(t, tc.expectedResponse, string(bytes.TrimSpace(b)), "Two response bodies should be the same")
+		})
+	}
+}
+
+func Test_undo(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	cartID := cartObjIDSet[0].Hex()
+
+	tt := []struct {
+		name             string
+		undoOut          *service.Cart
+		undoErr          error
+		expectedStatus   int
+		expectedResponse string
+	}{
+		{
+			name:             "undone",
+			undoOut:          &service.Cart{ID: cartObjIDSet[0], Items: []service.CartItem{}, Version: 4},
+			expectedStatus:   http.StatusOK,
+			expectedResponse: fmt.Sprintf(`{"id":"%s","items":[],"version":4}`, cartID),
+		},
+		{
+			name:             "nothing to undo",
+			undoErr:          errors.Wrap(audit.ErrNothingToUndo, "no changes of items"),
+			expectedStatus:   http.StatusConflict,
+			expectedResponse: `{"error":"could not undo change: no changes of items: nothing to undo","request_id":"test-request"}`,
+		},
+		{
+			name:           "changed afterwards",
+			undoErr:        errors.Wrap(service.ErrVersionConflict, "item 1 is changed after item_added"),
+			expectedStatus: http.StatusConflict,
+			expectedResponse: `{"error":"could not undo change: item 1 is changed after item_added: version conflict",` +
+				`"request_id":"test-request"}`,
+		},
+		{
+			name:             "missing cart",
+			undoErr:          errors.Wrap(service.ErrNotFound, "no carts"),
+			expectedStatus:   http.StatusNotFound,
+			expectedResponse: `{"error":"could not undo change: no carts: not found","request_id":"test-request"}`,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mocks.NewMockLog(ctrl)
+	server := httptest.NewServer(New(mocks.NewMockService(ctrl), WithAuditLog(log)))
+	defer server.Close()
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			log.EXPECT().Undo(gomock.Any(), cartID).Times(1).Return(tc.undoOut, tc.undoErr)
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/carts/%s/undo", server.URL, cartID), nil)
+			require.NoError(t, err, "could not create request")
+			req.Header.Set(RequestIDHeader, "test-request")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "could not get response")
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err, "could not read response")
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "Two status codes should be the same")
+			assert.Equal(t, tc.expectedResponse, string(bytes.TrimSpace(b)), "Two response bodies should be the same")
+		})
+	}
+}
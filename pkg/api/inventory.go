@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	"github.com/pkg/errors"
+)
+
+// WithInventory enables PUT /inventory and GET /inventory managing stock of products kept in l.
+func WithInventory(l inventory.Ledger) Option {
+	return func(s *Server) {
+		s.inventory = l
+	}
+}
+
+// stockRequest sets quantity of a product variant on hand.
+type stockRequest struct {
+	ProductName string     `json:"product"`
+	VariantID   string     `json:"variant_id"`
+	OnHand      float64    `json:"on_hand"`
+	Unit        units.Unit `json:"unit"`
+}
+
+func (r stockRequest) validate(spec units.Spec) error {
+	return validation.Validate(
+		productFields(r.ProductName, r.VariantID),
+		validation.Field("on_hand", r.OnHand,
+			validation.Min(0.0),
+			validation.When(r.Unit == units.Piece, validation.Integer()),
+			validation.MaxDecimals(spec.Precision),
+		),
+		validation.Field("unit", r.Unit, validation.OneOf(spec.Units...)),
+	)
+}
+
+// productFields checks name and variant of a product outside of an item.
+func productFields(product, variantID string) []validation.FieldError {
+	return append(
+		validation.Field("product", product,
+			validation.Required[string](),
+			validation.MaxLength(maxProductNameLength),
+			validation.Matches(productNameRe, "letters, digits, spaces and -_.,'&()/#+%"),
+		),
+		validation.Field("variant_id", variantID,
+			validation.MaxLength(maxVariantIDLength),
+			validation.Matches(variantIDRe, "letters, digits and _.-"),
+		)...,
+	)
+}
+
+// setStock sets stock of a product variant, so it can be reserved only while available.
+func (s *Server) setStock(w http.ResponseWriter, req *http.Request) {
+	var body stockRequest
+	if err := s.decodeJSON(w, req, &body); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	spec := s.units.Spec(body.ProductName)
+	if body.Unit == "" {
+		body.Unit = spec.DefaultUnit()
+	}
+	if err := body.validate(spec); err != nil {
+		writeValidationError(w, req, err)
+		return
+	}
+
+	stock, err := s.inventory.SetStock(req.Context(), body.ProductName, body.VariantID, body.OnHand, body.Unit)
+	if err != nil {
+		err = errors.Wrap(err, "could not set stock")
+		if errors.Cause(err) == inventory.ErrIncompatibleUnit {
+			writeJSONError(w, req, http.StatusConflict, err.Error())
+			return
+		}
+		s.log(req).Error("could not set stock", logger.Err(err))
+		writeJSONError(w, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, req, http.StatusOK, stock)
+}
+
+// viewStock writes stock of a product variant given with product and variant_id query parameters.
+func (s *Server) viewStock(w http.ResponseWriter, req *http.Request) {
+	product, variantID := req.URL.Query().Get("product"), req.URL.Query().Get("variant_id")
+	if errs := productFields(product, variantID); len(errs) > 0 {
+		writeErrorResponse(w, req, http.StatusBadRequest, errorResponse{Error: "query is not valid", Fields: errs})
+		return
+	}
+
+	stock, err := s.inventory.Stock(req.Context(), product, variantID)
+	if err != nil {
+		err = errors.Wrap(err, "could not get stock")
+		if errors.Cause(err) == service.ErrNotFound {
+			writeJSONError(w, req, http.StatusNotFound, err.Error())
+			return
+		}
+		s.log(req).Error("could not get stock", logger.Err(err))
+		writeJSONError(w, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, req, http.StatusOK, stock)
+}
+
+// writeStockError responds with 409 Conflict if err is caused by lack of stock, including available quantity,
+// or by a unit the stock cannot be reserved in. It reports whether the response is written.
+func writeStockError(w http.ResponseWriter, req *http.Request, err error) bool {
+	switch cause := errors.Cause(err).(type) {
+	case *inventory.InsufficientStockError:
+		writeErrorResponse(w, req, http.StatusConflict, errorResponse{Error: err.Error(), Stock: cause})
+		return true
+	default:
+		if cause == inventory.ErrIncompatibleUnit {
+			writeJSONError(w, req, http.StatusConflict, err.Error())
+			return true
+		}
+		return false
+	}
+}
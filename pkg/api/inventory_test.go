@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_inventory(t *testing.T) {
+	updated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	flour := &inventory.Stock{Product: "flour", OnHand: 3, Reserved: 1.25, Available: 1.75, Unit: units.Kilogram, UpdatedAt: updated}
+	flourResponse := `{"product":"flour","on_hand":3,"reserved":1.25,"available":1.75,"unit":"kg","updated_at":"2024-01-02T03:04:05Z"}`
+
+	tt := []struct {
+		name             string
+		method           string
+		path             string
+		request          string
+		expect           func(l *mocks.MockLedgerMockRecorder)
+		expectedStatus   int
+		expectedResponse string
+	}{
+		{
+			name:    "set stock",
+			method:  http.MethodPut,
+			path:    "/inventory",
+			request: `{"product":"flour","on_hand":3}`,
+			expect: func(l *mocks.MockLedgerMockRecorder) {
+				l.SetStock(gomock.Any(), "flour", "", float64(3), units.Kilogram).Times(1).Return(flour, nil)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedResponse: flourResponse,
+		},
+		{
+			name:           "invalid stock",
+			method:         http.MethodPut,
+			path:           "/inventory",
+			request:        `{"on_hand":-1.5,"unit":"piece"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"product","rule":"required","message":"must be set"},` +
+				`{"field":"on_hand","rule":"min","message":"must not be less than 0"},` +
+				`{"field":"on_hand","rule":"integer","message":"must be a whole number"}]}`,
+		},
+		{
+			name:    "unit of reserved stock",
+			method:  http.MethodPut,
+			path:    "/inventory",
+			request: `{"product":"flour","on_hand":3,"unit":"l"}`,
+			expect: func(l *mocks.MockLedgerMockRecorder) {
+				l.SetStock(gomock.Any(), "flour", "", float64(3), units.Litre).Times(1).
+					Return(nil, errors.Wrap(inventory.ErrIncompatibleUnit, "stock of flour is reserved in kg"))
+			},
+			expectedStatus: http.StatusConflict,
+			expectedResponse: `{"error":"could not set stock: stock of flour is reserved in kg: unit is incompatible with unit of stock",` +
+				`"request_id":"test-request"}`,
+		},
+		{
+			name:   "view stock",
+			method: http.MethodGet,
+			path:   "/inventory?product=flour",
+			expect: func(l *mocks.MockLedgerMockRecorder) {
+				l.Stock(gomock.Any(), "flour", "").Times(1).Return(flour, nil)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedResponse: flourResponse,
+		},
+		{
+			name:   "untracked product",
+			method: http.MethodGet,
+			path:   "/inventory?product=salt&variant_id=S-1",
+			expect: func(l *mocks.MockLedgerMockRecorder) {
+				l.Stock(gomock.Any(), "salt", "S-1").Times(1).
+					Return(nil, errors.Wrap(service.ErrNotFound, "stock of salt is not tracked"))
+			},
+			expectedStatus:   http.StatusNotFound,
+			expectedResponse: `{"error":"could not get stock: stock of salt is not tracked: not found","request_id":"test-request"}`,
+		},
+		{
+			name:           "missing product",
+			method:         http.MethodGet,
+			path:           "/inventory",
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"query is not valid","request_id":"test-request","fields":[` +
+				`{"field":"product","rule":"required","message":"must be set"}]}`,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	l := mocks.NewMockLedger(ctrl)
+	server := httptest.NewServer(New(mocks.NewMockService(ctrl), WithInventory(l), WithUnitCatalog(units.Catalog{
+		"flour": {Units: []units.Unit{units.Kilogram, units.Gram, units.Litre}, Precision: 3},
+	})))
+	defer server.Close()
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.expect != nil {
+				tc.expect(l.EXPECT())
+			}
+			req, err := http.NewRequest(tc.method, server.URL+tc.path, strings.NewReader(tc.request))
+			require.NoError(t, err, "could not create request")
+			req.Header.Set(RequestIDHeader, "test-request")
+			if tc.request != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "could not get response")
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err, "could not read response")
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "Two status codes should be the same")
+			assert.Equal(t, tc.expectedResponse, string(bytes.TrimSpace(b)), "Two response bodies should be the same")
+		})
+	}
+}
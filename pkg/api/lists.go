@@ -0,0 +1,199 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/lists"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxCustomerIDLength = 128
+	maxListNameLength   = 64
+)
+
+var (
+	customerIDRe = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+	listNameRe   = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// WithLists enables /customers/{customer_id}/lists routes managing lists of customers kept in l
+// and POST /carts/{cart_id}/items/{item_id}/move moving lines between carts and lists.
+func WithLists(l lists.Lists) Option {
+	return func(s *Server) {
+		s.lists = l
+	}
+}
+
+// moveRequest moves a line of a cart to a list of a customer or, with To set to lists.ToCart, a line of the list
+// to the cart.
+type moveRequest struct {
+	To         string `json:"to"`
+	CustomerID string `json:"customer_id"`
+	List       string `json:"list"`
+}
+
+type listsResponse struct {
+	Lists []lists.List `json:"lists"`
+}
+
+func (r moveRequest) validate() error {
+	return validation.Validate(
+		validation.Field("to", r.To, validation.When(r.To != "", validation.OneOf(lists.ToList, lists.ToCart))),
+		customerIDField("customer_id", r.CustomerID),
+		listNameField("list", r.List),
+	)
+}
+
+func customerIDField(name, value string) []validation.FieldError {
+	return validation.Field(name, value,
+		validation.Required[string](),
+		validation.MaxLength(maxCustomerIDLength),
+		validation.Matches(customerIDRe, "letters, digits and _.@-"),
+	)
+}
+
+func listNameField(name, value string) []validation.FieldError {
+	return validation.Field(name, value,
+		validation.Required[string](),
+		validation.MaxLength(maxListNameLength),
+		validation.Matches(listNameRe, "lowercase letters, digits and _ starting with a letter"),
+	)
+}
+
+// listPath returns customer ID and list name of a request path or responds with 400 Bad Request if they are not valid.
+func listPath(w http.ResponseWriter, req *http.Request) (customerID, name string, ok bool) {
+	vars := mux.Vars(req)
+	customerID, name = vars["customer_id"], vars["list"]
+	errs := customerIDField("customer_id", customerID)
+	if _, withList := vars["list"]; withList {
+		errs = append(errs, listNameField("list", name)...)
+	}
+	if len(errs) > 0 {
+		writeErrorResponse(w, req, http.StatusBadRequest, errorResponse{Error: "path is not valid", Fields: errs})
+		return "", "", false
+	}
+	return customerID, name, true
+}
+
+func (s *Server) customerLists(w http.ResponseWriter, req *http.Request) {
+	customerID, _, ok := listPath(w, req)
+	if !ok {
+		return
+	}
+	ls, err := s.lists.CustomerLists(req.Context(), customerID)
+	if err != nil {
+		s.writeListError(w, req, errors.Wrap(err, "could not get lists"))
+		return
+	}
+	writeJSON(w, req, http.StatusOK, listsResponse{Lists: ls})
+}
+
+func (s *Server) viewList(w http.ResponseWriter, req *http.Request) {
+	customerID, name, ok := listPath(w, req)
+	if !ok {
+		return
+	}
+	l, err := s.lists.List(req.Context(), customerID, name)
+	if err != nil {
+		s.writeListError(w, req, errors.Wrap(err, "could not get list"))
+		return
+	}
+	writeJSON(w, req, http.StatusOK, l)
+}
+
+func (s *Server) addToList(w http.ResponseWriter, req *http.Request) {
+	var item newItem
+	if err := s.decodeJSON(w, req, &item); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	customerID, name, ok := listPath(w, req)
+	if !ok {
+		return
+	}
+	spec := s.units.Spec(item.ProductName)
+	if item.Unit == "" {
+		item.Unit = spec.DefaultUnit()
+	}
+	if err := item.validate(spec); err != nil {
+		writeValidationError(w, req, err)
+		return
+	}
+
+	line, err := s.lists.AddItemToList(req.Context(), customerID, name, service.CartItem{
+		ProductName: item.ProductName,
+		Quantity:    item.Quantity,
+		Unit:        item.Unit,
+		VariantID:   item.VariantID,
+		Attributes:  item.Attributes,
+	})
+	if err != nil {
+		s.writeListError(w, req, errors.Wrap(err, "could not add item to list"))
+		return
+	}
+	writeJSON(w, req, http.StatusCreated, line)
+}
+
+func (s *Server) removeFromList(w http.ResponseWriter, req *http.Request) {
+	customerID, name, ok := listPath(w, req)
+	if !ok {
+		return
+	}
+	err := s.lists.RemoveItemFromList(req.Context(), customerID, name, mux.Vars(req)["item_id"])
+	if err != nil {
+		s.writeListError(w, req, errors.Wrap(err, "could not remove item from list"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// moveItem moves a line between a cart and a list of a customer atomically and responds with the line
+// at its new place, which is merged into a line of the same variant there.
+func (s *Server) moveItem(w http.ResponseWriter, req *http.Request) {
+	var body moveRequest
+	if err := s.decodeJSON(w, req, &body); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if err := body.validate(); err != nil {
+		writeValidationError(w, req, err)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var (
+		line *service.CartItem
+		err  error
+	)
+	if body.To == lists.ToCart {
+		line, err = s.lists.MoveToCart(req.Context(), body.CustomerID, body.List, vars["item_id"], vars["cart_id"])
+	} else {
+		line, err = s.lists.MoveToList(req.Context(), vars["cart_id"], vars["item_id"], body.CustomerID, body.List)
+	}
+	if err != nil {
+		s.writeListError(w, req, errors.Wrap(err, "could not move item"))
+		return
+	}
+	writeJSON(w, req, http.StatusOK, line)
+}
+
+// writeListError responds with 404 if err is caused by service.ErrNotFound, with 409 if stock of a line moved
+// to a cart is short and with 500 otherwise.
+func (s *Server) writeListError(w http.ResponseWriter, req *http.Request, err error) {
+	if errors.Cause(err) == service.ErrNotFound {
+		writeJSONError(w, req, http.StatusNotFound, err.Error())
+		return
+	}
+	if writeStockError(w, req, err) {
+		return
+	}
+	s.log(req).Error("list request failed", logger.Err(err))
+	writeJSONError(w, req, http.StatusInternalServerError, err.Error())
+}
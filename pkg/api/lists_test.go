@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/lists"
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func Test_lists(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	itemObjIDSet := generatePrimObjIDSet(1)
+	cartID, itemID := cartObjIDSet[0].Hex(), itemObjIDSet[0].Hex()
+	line := service.CartItem{ID: itemObjIDSet[0], ProductName: "milk", Quantity: 2, Unit: units.Piece}
+	cartLine := line
+	cartLine.CartID = cartObjIDSet[0]
+	lineResponse := fmt.Sprintf(`{"id":"%s","cart_id":"%s","product":"milk","quantity":2,"unit":"piece"}`,
+		itemID, primitive.NilObjectID.Hex())
+	updated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tt := []struct {
+		name             string
+		method           string
+		path             string
+		request          string
+		expect           func(l *mocks.MockListsMockRecorder)
+		expectedStatus   int
+		expectedResponse string
+	}{
+		{
+			name:    "move to list",
+			method:  http.MethodPost,
+			path:    fmt.Sprintf("/carts/%s/items/%s/move", cartID, itemID),
+			request: `{"customer_id":"c-1","list":"saved_for_later"}`,
+			expect: func(l *mocks.MockListsMockRecorder) {
+				l.MoveToList(gomock.Any(), cartID, itemID, "c-1", lists.SavedForLater).Times(1).Return(&line, nil)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedResponse: lineResponse,
+		},
+		{
+			name:    "move to cart",
+			method:  http.MethodPost,
+			path:    fmt.Sprintf("/carts/%s/items/%s/move", cartID, itemID),
+			request: `{"to":"cart","customer_id":"c-1","list":"wishlist"}`,
+			expect: func(l *mocks.MockListsMockRecorder) {
+				l.MoveToCart(gomock.Any(), "c-1", lists.Wishlist, itemID, cartID).Times(1).Return(&cartLine, nil)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedResponse: fmt.Sprintf(`{"id":"%s","cart_id":"%s","product":"milk","quantity":2,"unit":"piece"}`, itemID, cartID),
+		},
+		{
+			name:    "missing line",
+			method:  http.MethodPost,
+			path:    fmt.Sprintf("/carts/%s/items/%s/move", cartID, itemID),
+			request: `{"customer_id":"c-1","list":"wishlist"}`,
+			expect: func(l *mocks.MockListsMockRecorder) {
+				l.MoveToList(gomock.Any(), cartID, itemID, "c-1", lists.Wishlist).Times(1).
+					Return(nil, errors.Wrapf(service.ErrNotFound, "item %s is not found", itemID))
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedResponse: fmt.Sprintf(`{"error":"could not move item: item %s is not found: not found","request_id":"test-request"}`,
+				itemID),
+		},
+		{
+			name:           "invalid move",
+			method:         http.MethodPost,
+			path:           fmt.Sprintf("/carts/%s/items/%s/move", cartID, itemID),
+			request:        `{"to":"checkout","list":"Saved"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"to","rule":"one_of","message":"must be one of [list cart]"},` +
+				`{"field":"customer_id","rule":"required","message":"must be set"},` +
+				`{"field":"list","rule":"pattern","message":"must contain only lowercase letters, digits and _ starting with a letter"}]}`,
+		},
+		{
+			name:   "customer lists",
+			method: http.MethodGet,
+			path:   "/customers/c-1/lists",
+			expect: func(l *mocks.MockListsMockRecorder) {
+				l.CustomerLists(gomock.Any(), "c-1").Times(1).Return([]lists.List{
+					{CustomerID: "c-1", Name: lists.Wishlist, Items: []service.CartItem{line}, UpdatedAt: updated},
+				}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedResponse: `{"lists":[{"customer_id":"c-1","name":"wishlist","items":[` + lineResponse +
+				`],"updated_at":"2024-01-02T03:04:05Z"}]}`,
+		},
+		{
+			name:   "empty list",
+			method: http.MethodGet,
+			path:   "/customers/c-1/lists/saved_for_later",
+			expect: func(l *mocks.MockListsMockRecorder) {
+				l.List(gomock.Any(), "c-1", lists.SavedForLater).Times(1).Return(&lists.List{
+					CustomerID: "c-1", Name: lists.SavedForLater, Items: []service.CartItem{},
+				}, nil)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedResponse: `{"customer_id":"c-1","name":"saved_for_later","items":[],"updated_at":"0001-01-01T00:00:00Z"}`,
+		},
+		{
+			name:           "invalid list name",
+			method:         http.MethodGet,
+			path:           "/customers/c-1/lists/Wish%20list",
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"path is not valid","request_id":"test-request","fields":[` +
+				`{"field":"list","rule":"pattern","message":"must contain only lowercase letters, digits and _ starting with a letter"}]}`,
+		},
+		{
+			name:    "add to list",
+			method:  http.MethodPost,
+			path:    "/customers/c-1/lists/wishlist/items",
+			request: `{"product":"milk","quantity":2}`,
+			expect: func(l *mocks.MockListsMockRecorder) {
+				l.AddItemToList(gomock.Any(), "c-1", lists.Wishlist, service.CartItem{
+					ProductName: "milk", Quantity: 2, Unit: units.Piece,
+				}).Times(1).Return(&line, nil)
+			},
+			expectedStatus:   http.StatusCreated,
+			expectedResponse: lineResponse,
+		},
+		{
+			name:   "remove from list",
+			method: http.MethodDelete,
+			path:   "/customers/c-1/lists/wishlist/items/" + itemID,
+			expect: func(l *mocks.MockListsMockRecorder) {
+				l.RemoveItemFromList(gomock.Any(), "c-1", lists.Wishlist, itemID).Times(1).Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	l := mocks.NewMockLists(ctrl)
+	server := httptest.NewServer(New(mocks.NewMockService(ctrl), WithLists(l)))
+	defer server.Close()
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.expect != nil {
+				tc.expect(l.EXPECT())
+			}
+			req, err := http.NewRequest(tc.method, server.URL+tc.path, strings.NewReader(tc.request))
+			require.NoError(t, err, "could not create request")
+			req.Header.Set(RequestIDHeader, "test-request")
+			if tc.request != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "could not get response")
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err, "could not read response")
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "Two status codes should be the same")
+			assert.Equal(t, tc.expectedResponse, string(bytes.TrimSpace(b)), "Two response bodies should be the same")
+		})
+	}
+}
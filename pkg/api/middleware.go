@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/audit"
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/metrics"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/tracing"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader is the header used to receive and propagate request IDs.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the size of request IDs accepted from clients.
+const maxRequestIDLength = 128
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	cartCacheKey
+)
+
+// RequestID returns the request ID assigned to the request by the api middleware.
+// Func returns empty string if ctx does not carry a request ID.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// middleware wraps a handler with additional behavior.
+type middleware func(http.Handler) http.Handler
+
+// chain applies middlewares to h so that the first one is the outermost.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// responseRecorder remembers status code and amount of bytes written to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush event streams.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack lets WebSocket connections take over the underlying connection.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (r *responseRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func recorderFor(w http.ResponseWriter) *responseRecorder {
+	if rec, ok := w.(*responseRecorder); ok {
+		return rec
+	}
+	return &responseRecorder{ResponseWriter: w}
+}
+
+// withRequestID takes request ID from X-Request-ID header or generates a new one,
+// puts it, a request scoped logger and audit actor into request context and echoes it back in response headers.
+func withRequestID(l *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			id := req.Header.Get(RequestIDHeader)
+			if !isRequestIDValid(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			ctx := context.WithValue(req.Context(), requestIDKey, id)
+			ctx = logger.NewContext(ctx, l.With(slog.String(logger.RequestIDKey, id)))
+			ctx = audit.NewContext(ctx, audit.Actor{Name: actorName(clientCommonName(req)), RequestID: id})
+			next.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+}
+
+// withTracing continues trace from W3C traceparent header or starts a new one
+// and wraps the request into a server span named by route template.
+// Trace ID is added to the request scoped logger.
+func withTracing(router *mux.Router) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			route := routeTemplate(router, req)
+			ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			ctx, span := tracing.Tracer().Start(ctx, req.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(req.Method),
+					semconv.HTTPRoute(route),
+					attribute.String(logger.RequestIDKey, RequestID(ctx)),
+				))
+			defer span.End()
+
+			if sc := span.SpanContext(); sc.IsValid() {
+				ctx = logger.NewContext(ctx, logger.FromContext(ctx, nil).With(slog.String(logger.TraceIDKey, sc.TraceID().String())))
+			}
+			rec := recorderFor(w)
+			next.ServeHTTP(rec, req.WithContext(ctx))
+
+			span.SetAttributes(semconv.HTTPResponseStatusCode(rec.statusCode()))
+			if rec.statusCode() >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(rec.statusCode()))
+			}
+		})
+	}
+}
+
+// withAccessLog writes a line per request with method, route template, status, latency and response size.
+func withAccessLog(router *mux.Router) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			rec := recorderFor(w)
+			next.ServeHTTP(rec, req)
+			attrs := []slog.Attr{
+				slog.String("method", req.Method),
+				slog.String("route", routeTemplate(router, req)),
+				slog.Int("status", rec.statusCode()),
+				slog.Duration("latency", time.Since(start)),
+				slog.Int("bytes", rec.bytes),
+			}
+			if cn := clientCommonName(req); cn != "" {
+				attrs = append(attrs, slog.String("client_cn", cn))
+			}
+			logger.FromContext(req.Context(), nil).LogAttrs(req.Context(), slog.LevelInfo, "request served", attrs...)
+		})
+	}
+}
+
+// withMetrics counts requests and observes their latency by route template.
+func withMetrics(router *mux.Router, m *metrics.Metrics) middleware {
+	return func(next http.Handler) http.Handler {
+		if m == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			rec := recorderFor(w)
+			next.ServeHTTP(rec, req)
+			m.ObserveHTTPRequest(req.Method, routeTemplate(router, req), rec.statusCode(), time.Since(start))
+		})
+	}
+}
+
+// withRecovery turns a panic in a handler into 500 response with JSON error body.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rec := recorderFor(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			logger.FromContext(req.Context(), nil).Error("handler panicked",
+				slog.Any("panic", p), slog.String("stack", string(debug.Stack())))
+			if rec.status != 0 {
+				// headers are already sent, nothing can be reported to the client
+				return
+			}
+			writeJSONError(rec, req, http.StatusInternalServerError, "internal server error")
+		}()
+		next.ServeHTTP(rec, req)
+	})
+}
+
+// routeTemplate returns path template of the route matching req, e.g. /carts/{cart_id}.
+// Func returns "unmatched" if there is no such route.
+func routeTemplate(router *mux.Router, req *http.Request) string {
+	var match mux.RouteMatch
+	if !router.Match(req, &match) || match.Route == nil {
+		return "unmatched"
+	}
+	tpl, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return "unmatched"
+	}
+	return tpl
+}
+
+// clientCommonName returns common name of the verified client certificate presented over mutual TLS.
+// Func returns empty string for plain http and for clients without certificate.
+func clientCommonName(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return req.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// actorName returns name of the actor of changes made by a client with a common name cn of its verified certificate.
+func actorName(cn string) string {
+	if cn == "" {
+		return audit.Anonymous
+	}
+	return cn
+}
+
+type errorResponse struct {
+	Error     string                  `json:"error"`
+	RequestID string                  `json:"request_id,omitempty"`
+	Fields    []validation.FieldError `json:"fields,omitempty"`
+
+	Results []service.ItemOperationResult     `json:"results,omitempty"`
+	Stock   *inventory.InsufficientStockError `json:"stock,omitempty"`
+}
+
+func writeJSONError(w http.ResponseWriter, req *http.Request, status int, msg string) {
+	writeErrorResponse(w, req, status, errorResponse{Error: msg})
+}
+
+// writeValidationError responds with 400 Bad Request listing every violated rule if err is validation.Errors.
+func writeValidationError(w http.ResponseWriter, req *http.Request, err error) {
+	resp := errorResponse{Error: "request body is not valid"}
+	if fields, ok := err.(validation.Errors); ok {
+		resp.Fields = fields
+	} else {
+		resp.Error = err.Error()
+	}
+	writeErrorResponse(w, req, http.StatusBadRequest, resp)
+}
+
+func writeErrorResponse(w http.ResponseWriter, req *http.Request, status int, resp errorResponse) {
+	resp.RequestID = RequestID(req.Context())
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		logger.FromContext(req.Context(), nil).Error("could not encode json error", logger.Err(err))
+	}
+}
+
+func isRequestIDValid(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/audit"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/metrics"
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func Test_withRequestID(t *testing.T) {
+	tt := []struct {
+		name         string
+		reqRequestID string
+		generated    bool
+	}{
+		{
+			name:         "request id is propagated",
+			reqRequestID: "checkout-42",
+		},
+		{
+			name:      "request id is generated",
+			generated: true,
+		},
+		{
+			name:         "invalid request id is replaced",
+			reqRequestID: "bad id",
+			generated:    true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			var (
+				ctxRequestID string
+				actor        audit.Actor
+			)
+			lg := slog.New(slog.NewJSONHandler(&logBuf, nil))
+			h := withRequestID(lg)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				ctxRequestID = RequestID(req.Context())
+				actor = audit.FromContext(req.Context())
+				logger.FromContext(req.Context(), nil).Info("in handler")
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/carts", nil)
+			if tc.reqRequestID != "" {
+				req.Header.Set(RequestIDHeader, tc.reqRequestID)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			respRequestID := rec.Header().Get(RequestIDHeader)
+			assert.Equal(t, ctxRequestID, respRequestID, "Request id from context and header should be the same")
+			assert.Equal(t, audit.Actor{Name: audit.Anonymous, RequestID: respRequestID}, actor,
+				"Changes of clients without certificate should be made by anonymous actor")
+			if tc.generated {
+				assert.Len(t, respRequestID, 32, "Generated request id should be 16 hex encoded bytes")
+			} else {
+				assert.Equal(t, tc.reqRequestID, respRequestID, "Request id should be propagated")
+			}
+
+			var logLine map[string]interface{}
+			require.NoError(t, json.Unmarshal(logBuf.Bytes(), &logLine), "could not decode log line")
+			assert.Equal(t, respRequestID, logLine[logger.RequestIDKey], "Request scoped logger should carry request id")
+		})
+	}
+}
+
+func Test_withRecovery(t *testing.T) {
+	var logBuf bytes.Buffer
+	lg := slog.New(slog.NewTextHandler(&logBuf, nil))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock := mocks.NewMockService(ctrl)
+	mock.EXPECT().AddCart(gomock.Any()).Times(1).DoAndReturn(func(ctx context.Context) (*service.Cart, error) {
+		panic("boom")
+	})
+	s := New(mock, WithLogger(lg))
+
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/carts", server.URL), nil)
+	require.NoError(t, err, "could not create request")
+	req.Header.Set(RequestIDHeader, "panicking-request")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "could not get response")
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err, "could not read response")
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "Two status codes should be the same")
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `{"error":"internal server error","request_id":"panicking-request"}`, string(bytes.TrimSpace(b)))
+	assert.Contains(t, logBuf.String(), `msg="handler panicked" request_id=panicking-request panic=boom`)
+	assert.Contains(t, logBuf.String(), `msg="request served" request_id=panicking-request method=POST route=/carts status=500`)
+}
+
+func Test_withMetrics(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock := mocks.NewMockService(ctrl)
+	mock.EXPECT().Cart(gomock.Any(), cartObjIDSet[0].Hex()).Times(2).Return(&service.Cart{ID: cartObjIDSet[0]}, nil)
+	s := New(mock, WithMetrics(metrics.New()))
+
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(fmt.Sprintf("%s/carts/%s", server.URL, cartObjIDSet[0].Hex()))
+		require.NoError(t, err, "could not get response")
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/metrics", server.URL))
+	require.NoError(t, err, "could not get response")
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err, "could not read response")
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Two status codes should be the same")
+	assert.Contains(t, string(b), `cart_api_http_requests_total{method="GET",route="/carts/{cart_id}",status="200"} 2`)
+	assert.Contains(t, string(b), `cart_api_http_request_duration_seconds_count{method="GET",route="/carts/{cart_id}"} 2`)
+}
+
+func Test_withTracing(t *testing.T) {
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	cartObjIDSet := generatePrimObjIDSet(1)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var ctxTraceID string
+	mock := mocks.NewMockService(ctrl)
+	mock.EXPECT().Cart(gomock.Any(), cartObjIDSet[0].Hex()).Times(1).
+		DoAndReturn(func(ctx context.Context, id string) (*service.Cart, error) {
+			ctxTraceID = trace.SpanContextFromContext(ctx).TraceID().String()
+			return &service.Cart{ID: cartObjIDSet[0]}, nil
+		})
+	s := New(mock)
+
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/carts/%s", server.URL, cartObjIDSet[0].Hex()), nil)
+	require.NoError(t, err, "could not create request")
+	req.Header.Set("traceparent", fmt.Sprintf("00-%s-%s-01", traceID, parentSpanID))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "could not get response")
+	resp.Body.Close()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1, "One server span should be recorded")
+	assert.Equal(t, "GET /carts/{cart_id}", spans[0].Name())
+	assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind())
+	assert.Equal(t, traceID, spans[0].SpanContext().TraceID().String(), "Trace should be continued")
+	assert.Equal(t, parentSpanID, spans[0].Parent().SpanID().String(), "Span should be a child of the remote span")
+	assert.Equal(t, traceID, ctxTraceID, "Service should get context carrying the span")
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	_ "embed" // embeds OpenAPI document and docs page
+	"net/http"
+)
+
+// openAPISpec describes every route registered by New. Test_openAPISpec fails if a route is missing from it.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// docsPage renders openAPISpec with Swagger UI.
+//
+//go:embed docs.html
+var docsPage []byte
+
+func (s *Server) openAPI(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", jsonContentType)
+	if _, err := w.Write(openAPISpec); err != nil {
+		s.log(req).Debug("could not write openapi document")
+	}
+}
+
+func (s *Server) docs(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write(docsPage); err != nil {
+		s.log(req).Debug("could not write docs page")
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/metrics"
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_openAPISpec(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(openAPISpec, &spec), "OpenAPI document should be valid JSON")
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	s := New(mocks.NewMockService(ctrl), WithMetrics(metrics.New()), WithEvents(events.NewHub()),
+		WithWebhooks(mocks.NewMockStore(ctrl)), WithAuditLog(mocks.NewMockLog(ctrl)), WithLists(mocks.NewMockLists(ctrl)),
+		WithInventory(mocks.NewMockLedger(ctrl)))
+
+	registered := 0
+	err := s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			registered++
+			_, ok := spec.Paths[tpl][strings.ToLower(method)]
+			assert.True(t, ok, "%s %s is not described in openapi.json", method, tpl)
+		}
+		return nil
+	})
+	require.NoError(t, err, "could not walk routes")
+
+	described := 0
+	for _, ops := range spec.Paths {
+		described += len(ops)
+	}
+	assert.Equal(t, registered, described, "openapi.json should not describe routes that are not registered")
+}
+
+func Test_openAPI(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	server := httptest.NewServer(New(mocks.NewMockService(ctrl)))
+	defer server.Close()
+
+	for path, contentType := range map[string]string{
+		"/openapi.json": "application/json",
+		"/docs":         "text/html; charset=utf-8",
+	} {
+		resp, err := http.Get(fmt.Sprintf("%s%s", server.URL, path))
+		require.NoError(t, err, "could not get response")
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err, "could not read response")
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Two status codes should be the same")
+		assert.Equal(t, contentType, resp.Header.Get("Content-Type"))
+		assert.NotEmpty(t, b)
+	}
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// Media types of PATCH /carts/{cart_id} bodies.
+const (
+	jsonPatchContentType  = "application/json-patch+json"
+	mergePatchContentType = "application/merge-patch+json"
+)
+
+// patchCart applies JSON Patch or JSON Merge Patch to JSON representation of a cart.
+// Difference between the read and the patched cart is applied as item operations conditioned on version
+// of the read cart, so changes made after the cart was read are never overwritten.
+func (s *Server) patchCart(w http.ResponseWriter, req *http.Request) {
+	if err := checkContentType(req, jsonPatchContentType, mergePatchContentType); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, s.maxBodyBytes))
+	if err != nil {
+		writeDecodeError(w, bodyError(err))
+		return
+	}
+
+	vars := mux.Vars(req)
+	cartID, ok := vars["cart_id"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "cart_id is not provided")
+		return
+	}
+
+	cart, err := s.service.Cart(req.Context(), cartID)
+	if err != nil {
+		s.log(req).Error("could not get cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "could not get cart: %s", err)
+		return
+	}
+	patched, err := applyPatch(cart, mediaType, body)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	ops, err := s.cartChanges(cart, patched)
+	if _, ok := err.(validation.Errors); ok {
+		writeValidationError(w, req, err)
+		return
+	}
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if len(ops) > 0 {
+		results, err := s.service.ApplyItemOperations(req.Context(), cartID, cart.Version, ops)
+		switch errors.Cause(err) {
+		case nil:
+		case service.ErrVersionConflict:
+			writeJSONError(w, req, http.StatusConflict, "cart is changed concurrently: "+err.Error())
+			return
+		case service.ErrOperationsFailed:
+			writeErrorResponse(w, req, http.StatusUnprocessableEntity, errorResponse{
+				Error:   "patch is not applied: " + service.ErrOperationsFailed.Error(),
+				Results: results,
+			})
+			return
+		default:
+			if writeStockError(w, req, errors.Wrap(err, "patch is not applied")) {
+				return
+			}
+			s.log(req).Error("could not patch cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "could not patch cart: %s", err)
+			return
+		}
+		cart, err = s.service.Cart(req.Context(), cartID)
+		if err != nil {
+			s.log(req).Error("could not get cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "could not get cart: %s", err)
+			return
+		}
+	}
+
+	err = json.NewEncoder(w).Encode(cart)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "could not encode json: %s", err)
+		return
+	}
+}
+
+// applyPatch returns cart patched with body of mediaType. Failed test operations are reported as
+// *decodeError with 409 Conflict status, other patch errors with 400 Bad Request or 422 Unprocessable Entity.
+func applyPatch(cart *service.Cart, mediaType string, body []byte) (*service.Cart, error) {
+	doc, err := json.Marshal(cart)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode cart")
+	}
+
+	var patchedDoc []byte
+	switch mediaType {
+	case jsonPatchContentType:
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return nil, bodyError(err)
+		}
+		patchedDoc, err = patch.Apply(doc)
+		switch {
+		case errors.Cause(err) == jsonpatch.ErrTestFailed:
+			return nil, &decodeError{status: http.StatusConflict, msg: err.Error()}
+		case err != nil:
+			return nil, &decodeError{status: http.StatusUnprocessableEntity, msg: "could not apply patch: " + err.Error()}
+		}
+	default:
+		patchedDoc, err = jsonpatch.MergePatch(doc, body)
+		if err != nil {
+			return nil, bodyError(err)
+		}
+	}
+
+	var patched service.Cart
+	dec := json.NewDecoder(bytes.NewReader(patchedDoc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		return nil, &decodeError{status: http.StatusUnprocessableEntity, msg: "patched cart is not valid: " + err.Error()}
+	}
+	return &patched, nil
+}
+
+// cartChanges returns operations turning items of cart into items of patched: removals of missing items,
+// updates of quantity and unit, then additions of items without ID.
+// Other fields are read only, omitted ones are left as they are, so merge patches may list only IDs
+// and quantities of kept items. Version of patched must be equal to version of cart, *decodeError
+// with 409 Conflict status is returned otherwise.
+func (s *Server) cartChanges(cart, patched *service.Cart) ([]service.ItemOperation, error) {
+	if patched.Version != cart.Version {
+		return nil, &decodeError{
+			status: http.StatusConflict,
+			msg:    fmt.Sprintf("cart version is %d, patch expects %d", cart.Version, patched.Version),
+		}
+	}
+
+	errs := validation.Field("id", patched.ID, unchanged(cart.ID))
+	items := make(map[string]service.CartItem, len(cart.Items))
+	for _, item := range cart.Items {
+		items[item.ID.Hex()] = withDefaultUnit(item)
+	}
+	var removals, updates, additions []service.ItemOperation
+	for i, item := range patched.Items {
+		prefix := fmt.Sprintf("items[%d].", i)
+		if item.ID.IsZero() {
+			added := newItem{
+				ProductName: item.ProductName,
+				Quantity:    item.Quantity,
+				Unit:        item.Unit,
+				VariantID:   item.VariantID,
+				Attributes:  item.Attributes,
+			}
+			spec := s.units.Spec(added.ProductName)
+			if added.Unit == "" {
+				added.Unit = spec.DefaultUnit()
+			}
+			var addErrs validation.Errors
+			if err := added.validate(spec); err != nil {
+				addErrs = err.(validation.Errors)
+			}
+			errs = append(errs, prefixed(prefix,
+				addErrs,
+				validation.Field("cart_id", item.CartID, validation.When(!item.CartID.IsZero(), unchanged(cart.ID))),
+				// added lines are priced from the product catalog
+				validation.Field("price", item.Price, validation.Absent[float64]()),
+			)...)
+			additions = append(additions, service.ItemOperation{Op: service.OpAdd, Item: added.cartItem(s.products)})
+			continue
+		}
+
+		orig, ok := items[item.ID.Hex()]
+		if !ok {
+			errs = append(errs, validation.FieldError{Field: prefix + "id", Rule: "exists", Message: "must be ID of an item of the cart"})
+			continue
+		}
+		delete(items, item.ID.Hex())
+		if item.Unit == "" {
+			item.Unit = orig.Unit
+		}
+		errs = append(errs, prefixed(prefix,
+			validation.Field("cart_id", item.CartID, validation.When(!item.CartID.IsZero(), unchanged(orig.CartID))),
+			validation.Field("product", item.ProductName, validation.When(item.ProductName != "", unchanged(orig.ProductName))),
+			validation.Field("variant_id", item.VariantID, validation.When(item.VariantID != "", unchanged(orig.VariantID))),
+			validation.Field("attributes", item.Attributes, validation.When(item.Attributes != nil, sameAttributes(orig.Attributes))),
+			validation.Field("price", item.Price, validation.When(item.Price != 0, unchanged(orig.Price))),
+		)...)
+		if item.Quantity == orig.Quantity && item.Unit == orig.Unit {
+			continue
+		}
+		errs = append(errs, prefixed(prefix, validateQuantity(item.Quantity, item.Unit, s.units.Spec(orig.ProductName)))...)
+		updates = append(updates, service.ItemOperation{
+			Op:     service.OpUpdate,
+			ItemID: item.ID.Hex(),
+			Item:   service.CartItem{Quantity: item.Quantity, Unit: item.Unit},
+		})
+	}
+	for _, item := range cart.Items {
+		if _, ok := items[item.ID.Hex()]; ok {
+			removals = append(removals, service.ItemOperation{Op: service.OpRemove, ItemID: item.ID.Hex()})
+		}
+	}
+
+	if err := validation.Validate(errs); err != nil {
+		return nil, err
+	}
+	return append(append(removals, updates...), additions...), nil
+}
+
+// validateQuantity checks new quantity and unit of an existing item of a product with spec.
+func validateQuantity(quantity float64, unit units.Unit, spec units.Spec) []validation.FieldError {
+	return append(
+		validation.Field("quantity", quantity,
+			validation.Positive[float64](),
+			validation.Max[float64](maxItemQuantity),
+			validation.When(unit == units.Piece, validation.Integer()),
+			validation.MaxDecimals(spec.Precision),
+		),
+		validation.Field("unit", unit, validation.OneOf(spec.Units...))...,
+	)
+}
+
+// withDefaultUnit sets unit of items stored before units were introduced.
+func withDefaultUnit(item service.CartItem) service.CartItem {
+	if item.Unit == "" {
+		item.Unit = units.Piece
+	}
+	return item
+}
+
+// unchanged is violated by values different from orig. It rejects changes of read only fields.
+func unchanged[T comparable](orig T) validation.Rule[T] {
+	return func(value T) *validation.Violation {
+		if value != orig {
+			return &validation.Violation{Rule: "read_only", Message: "must not be changed"}
+		}
+		return nil
+	}
+}
+
+// sameAttributes is violated by attributes not equal to orig.
+func sameAttributes(orig service.Attributes) validation.Rule[service.Attributes] {
+	return func(value service.Attributes) *validation.Violation {
+		if !value.Equal(orig) {
+			return &validation.Violation{Rule: "read_only", Message: "must not be changed"}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_patchCart(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	itemObjIDSet := generatePrimObjIDSet(3)
+	cart := &service.Cart{
+		ID: cartObjIDSet[0],
+		Items: []service.CartItem{
+			{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "product_1", Quantity: 1},
+			{ID: itemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "product_2", Quantity: 2, Unit: units.Piece},
+		},
+		Version: 4,
+	}
+	patchedCart := &service.Cart{
+		ID: cartObjIDSet[0],
+		Items: []service.CartItem{
+			{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "product_1", Quantity: 3, Unit: units.Piece},
+			{ID: itemObjIDSet[2], CartID: cartObjIDSet[0], ProductName: "product_3", Quantity: 1, Unit: units.Piece},
+		},
+		Version: 5,
+	}
+	patchedResponse := fmt.Sprintf(`{"id":"%[1]s","items":[{"id":"%[2]s","cart_id":"%[1]s","product":"product_1","quantity":3,"unit":"piece"},`+
+		`{"id":"%[3]s","cart_id":"%[1]s","product":"product_3","quantity":1,"unit":"piece"}],"version":5}`,
+		cartObjIDSet[0].Hex(), itemObjIDSet[0].Hex(), itemObjIDSet[2].Hex())
+	patchOps := []service.ItemOperation{
+		{Op: service.OpRemove, ItemID: itemObjIDSet[1].Hex()},
+		{Op: service.OpUpdate, ItemID: itemObjIDSet[0].Hex(), Item: service.CartItem{Quantity: 3, Unit: units.Piece}},
+		{Op: service.OpAdd, Item: service.CartItem{ProductName: "product_3", Quantity: 1, Unit: units.Piece}},
+	}
+	tt := []struct {
+		name             string
+		contentType      string
+		request          string
+		expectedResponse string
+		expectedStatus   int
+		applyIn          []service.ItemOperation
+		applyErr         error
+	}{
+		{
+			name:        "json patch",
+			contentType: "application/json-patch+json",
+			request: `[{"op":"test","path":"/version","value":4},{"op":"replace","path":"/items/0/quantity","value":3},` +
+				`{"op":"remove","path":"/items/1"},{"op":"add","path":"/items/-","value":{"product":"product_3","quantity":1}}]`,
+			expectedResponse: patchedResponse,
+			expectedStatus:   http.StatusOK,
+			applyIn:          patchOps,
+		},
+		{
+			name:        "merge patch",
+			contentType: "application/merge-patch+json",
+			request: fmt.Sprintf(`{"items":[{"id":"%s","quantity":3},{"product":"product_3","quantity":1}]}`,
+				itemObjIDSet[0].Hex()),
+			expectedResponse: patchedResponse,
+			expectedStatus:   http.StatusOK,
+			applyIn:          patchOps,
+		},
+		{
+			name:        "no changes",
+			contentType: "application/merge-patch+json",
+			request:     `{}`,
+			expectedResponse: fmt.Sprintf(`{"id":"%[1]s","items":[{"id":"%[2]s","cart_id":"%[1]s","product":"product_1","quantity":1},`+
+				`{"id":"%[3]s","cart_id":"%[1]s","product":"product_2","quantity":2,"unit":"piece"}],"version":4}`,
+				cartObjIDSet[0].Hex(), itemObjIDSet[0].Hex(), itemObjIDSet[1].Hex()),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:             "failed test operation",
+			contentType:      "application/json-patch+json",
+			request:          `[{"op":"test","path":"/version","value":3},{"op":"remove","path":"/items/0"}]`,
+			expectedResponse: "testing value /version failed: test failed",
+			expectedStatus:   http.StatusConflict,
+		},
+		{
+			name:             "outdated version in merge patch",
+			contentType:      "application/merge-patch+json",
+			request:          `{"version":3,"items":[]}`,
+			expectedResponse: "cart version is 4, patch expects 3",
+			expectedStatus:   http.StatusConflict,
+		},
+		{
+			name:             "path is missing",
+			contentType:      "application/json-patch+json",
+			request:          `[{"op":"remove","path":"/items/5"}]`,
+			expectedResponse: "could not apply patch: error in remove for path: '/items/5': Unable to access invalid index: 5: invalid index referenced",
+			expectedStatus:   http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "read only fields are changed",
+			contentType: "application/json-patch+json",
+			request: `[{"op":"replace","path":"/items/0/product","value":"product_9"},{"op":"add","path":"/items/0/price","value":9},` +
+				`{"op":"replace","path":"/items/1/quantity","value":0.5}]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"items[0].product","rule":"read_only","message":"must not be changed"},` +
+				`{"field":"items[0].price","rule":"read_only","message":"must not be changed"},` +
+				`{"field":"items[1].quantity","rule":"integer","message":"must be a whole number"}]}`,
+		},
+		{
+			name:             "unsupported content type",
+			contentType:      "application/json",
+			request:          `{}`,
+			expectedResponse: "content type must be application/json-patch+json",
+			expectedStatus:   http.StatusUnsupportedMediaType,
+		},
+		{
+			name:             "cart is changed concurrently",
+			contentType:      "application/json-patch+json",
+			request:          `[{"op":"remove","path":"/items/1"}]`,
+			expectedResponse: `{"error":"cart is changed concurrently: cart version is 5: version conflict","request_id":"test-request"}`,
+			expectedStatus:   http.StatusConflict,
+			applyIn:          []service.ItemOperation{{Op: service.OpRemove, ItemID: itemObjIDSet[1].Hex()}},
+			applyErr:         errors.Wrap(service.ErrVersionConflict, "cart version is 5"),
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock := mocks.NewMockService(ctrl)
+	s := New(mock)
+
+	server := httptest.NewServer(s)
+	defer server.Close()
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.expectedStatus != http.StatusUnsupportedMediaType {
+				mock.EXPECT().Cart(gomock.Any(), cartObjIDSet[0].Hex()).Times(1).Return(cart, nil)
+			}
+			if tc.applyIn != nil {
+				mock.EXPECT().ApplyItemOperations(gomock.Any(), cartObjIDSet[0].Hex(), cart.Version, tc.applyIn).
+					Times(1).Return(nil, tc.applyErr)
+				if tc.applyErr == nil {
+					mock.EXPECT().Cart(gomock.Any(), cartObjIDSet[0].Hex()).Times(1).Return(patchedCart, nil)
+				}
+			}
+			req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/carts/%s", server.URL, cartObjIDSet[0].Hex()),
+				strings.NewReader(tc.request))
+			require.NoError(t, err, "could not create request")
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set(RequestIDHeader, "test-request")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "could not get response")
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err, "could not read response")
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "Two status codes should be the same")
+			assert.Equal(t, tc.expectedResponse, string(bytes.TrimSpace(b)), "Two response bodies should be the same")
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// Types of issues found by cart validation.
+const (
+	issueDiscontinued   = "discontinued"
+	issueOutOfStock     = "out_of_stock"
+	issueQuantityCapped = "quantity_capped"
+	issueUnitMismatch   = "unit_mismatch"
+	issuePriceChanged   = "price_changed"
+)
+
+// Fixes of issues applied with ?apply=true. Issues with fixNone are left to the client.
+const (
+	fixRemove      = "remove"
+	fixSetQuantity = "set_quantity"
+	fixUpdatePrice = "update_price"
+	fixNone        = "none"
+)
+
+// cartIssue is a line of a cart which cannot be checked out as it is. Available is quantity of stock
+// the line may have, it is set for stock issues. OldPrice and NewPrice are the price of the line
+// and the price of its product in the catalog, they are set for price issues.
+type cartIssue struct {
+	ItemID    string     `json:"item_id"`
+	Product   string     `json:"product"`
+	VariantID string     `json:"variant_id,omitempty"`
+	Type      string     `json:"type"`
+	Message   string     `json:"message"`
+	Quantity  float64    `json:"quantity"`
+	Unit      units.Unit `json:"unit"`
+	Available *float64   `json:"available,omitempty"`
+	OldPrice  *float64   `json:"old_price,omitempty"`
+	NewPrice  *float64   `json:"new_price,omitempty"`
+	Fix       string     `json:"fix"`
+}
+
+type validateResponse struct {
+	Valid   bool          `json:"valid"`
+	Issues  []cartIssue   `json:"issues"`
+	Applied bool          `json:"applied"`
+	Cart    *service.Cart `json:"cart"`
+}
+
+// validateCart re-checks every line of a cart against the product catalog and inventory and lists issues found.
+// With ?apply=true fixes of the issues are applied to the cart of the checked version.
+func (s *Server) validateCart(w http.ResponseWriter, req *http.Request) {
+	cartID := mux.Vars(req)["cart_id"]
+	apply := false
+	if v := req.URL.Query().Get("apply"); v != "" {
+		var err error
+		if apply, err = strconv.ParseBool(v); err != nil {
+			writeErrorResponse(w, req, http.StatusBadRequest, errorResponse{Error: "query is not valid", Fields: []validation.FieldError{
+				{Field: "apply", Rule: "type", Message: "must be true or false"},
+			}})
+			return
+		}
+	}
+
+	cart, err := s.service.Cart(req.Context(), cartID)
+	if err != nil {
+		s.writeValidateError(w, req, errors.Wrap(err, "could not get cart"))
+		return
+	}
+	issues, ops, err := s.cartIssues(req.Context(), cart)
+	if err != nil {
+		s.writeValidateError(w, req, errors.Wrap(err, "could not validate cart"))
+		return
+	}
+
+	resp := validateResponse{Valid: len(issues) == 0, Issues: issues, Cart: cart}
+	if apply && len(ops) > 0 {
+		_, err := s.service.ApplyItemOperations(req.Context(), cartID, cart.Version, ops)
+		if err != nil {
+			s.writeValidateError(w, req, errors.Wrap(err, "could not fix cart"))
+			return
+		}
+		if resp.Cart, err = s.service.Cart(req.Context(), cartID); err != nil {
+			s.writeValidateError(w, req, errors.Wrap(err, "could not get cart"))
+			return
+		}
+		resp.Applied = true
+	}
+	writeJSON(w, req, http.StatusOK, resp)
+}
+
+// cartIssues returns issues of lines of cart in order along with operations fixing them.
+// Lines of discontinued products and lines without stock are removed, lines exceeding available stock
+// are capped to it, rounded down to precision of the product. Lines in units stock cannot be converted to
+// are reported without a fix. Kept lines priced differently than their product are repriced;
+// lines or products without a price are not compared.
+func (s *Server) cartIssues(ctx context.Context, cart *service.Cart) ([]cartIssue, []service.ItemOperation, error) {
+	issues := []cartIssue{}
+	var ops []service.ItemOperation
+	for _, item := range cart.Items {
+		item = withDefaultUnit(item)
+		spec := s.units.Spec(item.ProductName)
+		product := s.products.Product(item.ProductName)
+		issue := cartIssue{
+			ItemID:    item.ID.Hex(),
+			Product:   item.ProductName,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+			Unit:      item.Unit,
+			Fix:       fixRemove,
+		}
+		switch {
+		case product.Discontinued:
+			issue.Type, issue.Message = issueDiscontinued, "product is discontinued"
+		case s.inventory != nil:
+			err := s.inventory.Check(ctx, inventory.Reservation{
+				CartID:    cart.ID.Hex(),
+				ItemID:    item.ID.Hex(),
+				Product:   item.ProductName,
+				VariantID: item.VariantID,
+				Quantity:  item.Quantity,
+				Unit:      item.Unit,
+			})
+			switch cause := errors.Cause(err).(type) {
+			case nil:
+				// stock is sufficient
+			case *inventory.InsufficientStockError:
+				available := floorQuantity(cause.Available, spec.Precision, item.Unit)
+				issue.Available = &available
+				if available > 0 {
+					issue.Type, issue.Fix = issueQuantityCapped, fixSetQuantity
+					issue.Message = fmt.Sprintf("only %v %s available", available, item.Unit)
+				} else {
+					issue.Type, issue.Message = issueOutOfStock, "product is out of stock"
+				}
+			default:
+				if cause != inventory.ErrIncompatibleUnit {
+					return nil, nil, err
+				}
+				// the line may be fine in another unit, so it is not removed
+				issue.Type, issue.Fix = issueUnitMismatch, fixNone
+				issue.Message = fmt.Sprintf("stock of the product is not measured in units compatible with %s", item.Unit)
+			}
+		}
+
+		update := service.CartItem{Quantity: item.Quantity, Unit: item.Unit}
+		if issue.Type != "" {
+			issues = append(issues, issue)
+			switch issue.Fix {
+			case fixRemove:
+				ops = append(ops, service.ItemOperation{Op: service.OpRemove, ItemID: issue.ItemID})
+				continue
+			case fixSetQuantity:
+				update.Quantity = *issue.Available
+			}
+		}
+		if item.Price != 0 && product.Price != 0 && item.Price != product.Price {
+			oldPrice, newPrice := item.Price, product.Price
+			issues = append(issues, cartIssue{
+				ItemID:    issue.ItemID,
+				Product:   item.ProductName,
+				VariantID: item.VariantID,
+				Type:      issuePriceChanged,
+				Message:   fmt.Sprintf("price changed from %v to %v", oldPrice, newPrice),
+				Quantity:  item.Quantity,
+				Unit:      item.Unit,
+				OldPrice:  &oldPrice,
+				NewPrice:  &newPrice,
+				Fix:       fixUpdatePrice,
+			})
+			update.Price = newPrice
+		}
+		if update.Quantity != item.Quantity || update.Price != 0 {
+			// a single update of the line caps its quantity and reprices it
+			ops = append(ops, service.ItemOperation{Op: service.OpUpdate, ItemID: issue.ItemID, Item: update})
+		}
+	}
+	return issues, ops, nil
+}
+
+// floorQuantity rounds q down to precision decimal places, pieces to whole numbers.
+func floorQuantity(q float64, precision int, unit units.Unit) float64 {
+	if unit == units.Piece {
+		precision = 0
+	}
+	p := math.Pow10(precision)
+	return math.Floor(units.Round(q*p, units.MaxPrecision)) / p
+}
+
+// writeValidateError responds with 404 if the cart is not found, with 409 if it is changed concurrently
+// or stock is short while fixes are applied and with 500 otherwise.
+func (s *Server) writeValidateError(w http.ResponseWriter, req *http.Request, err error) {
+	switch errors.Cause(err) {
+	case service.ErrNotFound:
+		writeJSONError(w, req, http.StatusNotFound, err.Error())
+	case service.ErrVersionConflict:
+		writeJSONError(w, req, http.StatusConflict, "cart is changed concurrently: "+err.Error())
+	default:
+		if writeStockError(w, req, err) {
+			return
+		}
+		s.log(req).Error("could not validate cart", slog.String(logger.CartIDKey, mux.Vars(req)["cart_id"]), logger.Err(err))
+		writeJSONError(w, req, http.StatusInternalServerError, err.Error())
+	}
+}
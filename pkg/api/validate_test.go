@@ -0,0 +1,182 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/products"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validateCart(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	itemObjIDSet := generatePrimObjIDSet(5)
+	cartID := cartObjIDSet[0].Hex()
+	flour := service.CartItem{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "flour", Quantity: 2.5, Unit: units.Kilogram,
+		Price: 3}
+	eggs := service.CartItem{ID: itemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "eggs", Quantity: 12, Unit: units.Piece, Price: 0.3}
+	salt := service.CartItem{ID: itemObjIDSet[2], CartID: cartObjIDSet[0], ProductName: "salt", Quantity: 1, Price: 1}
+	milk := service.CartItem{ID: itemObjIDSet[3], CartID: cartObjIDSet[0], ProductName: "milk", Quantity: 1, Unit: units.Litre}
+	sugar := service.CartItem{ID: itemObjIDSet[4], CartID: cartObjIDSet[0], ProductName: "sugar", Quantity: 1, Unit: units.Litre}
+	cart := &service.Cart{ID: cartObjIDSet[0], Items: []service.CartItem{flour, eggs, salt, milk, sugar}, Version: 4}
+	fixed := &service.Cart{ID: cartObjIDSet[0], Items: []service.CartItem{flour, eggs, sugar}, Version: 5}
+	fixed.Items[0].Quantity, fixed.Items[0].Price = 1.25, 3.5
+
+	checkStock := func(l *mocks.MockLedgerMockRecorder) {
+		reservation := func(item service.CartItem, unit units.Unit) inventory.Reservation {
+			return inventory.Reservation{CartID: cartID, ItemID: item.ID.Hex(), Product: item.ProductName,
+				Quantity: item.Quantity, Unit: unit}
+		}
+		l.Check(gomock.Any(), reservation(flour, units.Kilogram)).Times(1).
+			Return(&inventory.InsufficientStockError{Product: "flour", Requested: 2.5, Available: 1.2555, Unit: units.Kilogram})
+		l.Check(gomock.Any(), reservation(eggs, units.Piece)).Times(1).Return(nil)
+		l.Check(gomock.Any(), reservation(salt, units.Piece)).Times(1).
+			Return(&inventory.InsufficientStockError{Product: "salt", Requested: 1, Available: 0.5, Unit: units.Piece})
+		l.Check(gomock.Any(), reservation(sugar, units.Litre)).Times(1).
+			Return(errors.Wrap(inventory.ErrIncompatibleUnit, "stock of sugar is kept in kg"))
+	}
+	issues := fmt.Sprintf(`"issues":[`+
+		`{"item_id":"%[1]s","product":"flour","type":"quantity_capped","message":"only 1.25 kg available","quantity":2.5,"unit":"kg","available":1.25,"fix":"set_quantity"},`+
+		`{"item_id":"%[1]s","product":"flour","type":"price_changed","message":"price changed from 3 to 3.5","quantity":2.5,"unit":"kg",`+
+		`"old_price":3,"new_price":3.5,"fix":"update_price"},`+
+		`{"item_id":"%[2]s","product":"salt","type":"out_of_stock","message":"product is out of stock","quantity":1,"unit":"piece","available":0,"fix":"remove"},`+
+		`{"item_id":"%[3]s","product":"milk","type":"discontinued","message":"product is discontinued","quantity":1,"unit":"l","fix":"remove"},`+
+		`{"item_id":"%[4]s","product":"sugar","type":"unit_mismatch","message":"stock of the product is not measured in units compatible with l",`+
+		`"quantity":1,"unit":"l","fix":"none"}]`,
+		itemObjIDSet[0].Hex(), itemObjIDSet[2].Hex(), itemObjIDSet[3].Hex(), itemObjIDSet[4].Hex())
+
+	tt := []struct {
+		name             string
+		query            string
+		expect           func(s *mocks.MockServiceMockRecorder, l *mocks.MockLedgerMockRecorder)
+		expectedStatus   int
+		expectedResponse string
+	}{
+		{
+			name: "issues are listed",
+			expect: func(s *mocks.MockServiceMockRecorder, l *mocks.MockLedgerMockRecorder) {
+				s.Cart(gomock.Any(), cartID).Times(1).Return(cart, nil)
+				checkStock(l)
+			},
+			expectedStatus: http.StatusOK,
+			expectedResponse: `{"valid":false,` + issues + `,"applied":false,"cart":{"id":"` + cartID + `","items":[` +
+				itemJSON(flour) + `,` + itemJSON(eggs) + `,` + fmt.Sprintf(`{"id":"%s","cart_id":"%s","product":"salt","quantity":1,"price":1}`,
+				itemObjIDSet[2].Hex(), cartID) + `,` + itemJSON(milk) + `,` + itemJSON(sugar) + `],"version":4}}`,
+		},
+		{
+			name:  "fixes are applied",
+			query: "?apply=true",
+			expect: func(s *mocks.MockServiceMockRecorder, l *mocks.MockLedgerMockRecorder) {
+				s.Cart(gomock.Any(), cartID).Times(1).Return(cart, nil)
+				checkStock(l)
+				s.ApplyItemOperations(gomock.Any(), cartID, int64(4), []service.ItemOperation{
+					{Op: service.OpUpdate, ItemID: flour.ID.Hex(), Item: service.CartItem{Quantity: 1.25, Unit: units.Kilogram, Price: 3.5}},
+					{Op: service.OpRemove, ItemID: salt.ID.Hex()},
+					{Op: service.OpRemove, ItemID: milk.ID.Hex()},
+				}).Times(1).Return(nil, nil)
+				s.Cart(gomock.Any(), cartID).Times(1).Return(fixed, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedResponse: `{"valid":false,` + issues + `,"applied":true,"cart":{"id":"` + cartID + `","items":[` +
+				itemJSON(fixed.Items[0]) + `,` + itemJSON(eggs) + `,` + itemJSON(sugar) + `],"version":5}}`,
+		},
+		{
+			name:  "valid cart",
+			query: "?apply=true",
+			expect: func(s *mocks.MockServiceMockRecorder, l *mocks.MockLedgerMockRecorder) {
+				s.Cart(gomock.Any(), cartID).Times(1).Return(&service.Cart{ID: cartObjIDSet[0], Items: []service.CartItem{eggs}}, nil)
+				l.Check(gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedResponse: `{"valid":true,"issues":[],"applied":false,"cart":{"id":"` + cartID + `","items":[` +
+				itemJSON(eggs) + `],"version":0}}`,
+		},
+		{
+			name:  "cart changed while fixes are applied",
+			query: "?apply=1",
+			expect: func(s *mocks.MockServiceMockRecorder, l *mocks.MockLedgerMockRecorder) {
+				s.Cart(gomock.Any(), cartID).Times(1).Return(cart, nil)
+				checkStock(l)
+				s.ApplyItemOperations(gomock.Any(), cartID, int64(4), gomock.Any()).Times(1).
+					Return(nil, errors.Wrap(service.ErrVersionConflict, "cart version is 5"))
+			},
+			expectedStatus: http.StatusConflict,
+			expectedResponse: `{"error":"cart is changed concurrently: could not fix cart: cart version is 5: version conflict",` +
+				`"request_id":"test-request"}`,
+		},
+		{
+			name: "missing cart",
+			expect: func(s *mocks.MockServiceMockRecorder, l *mocks.MockLedgerMockRecorder) {
+				s.Cart(gomock.Any(), cartID).Times(1).Return(nil, errors.Wrap(service.ErrNotFound, "no carts"))
+			},
+			expectedStatus:   http.StatusNotFound,
+			expectedResponse: `{"error":"could not get cart: no carts: not found","request_id":"test-request"}`,
+		},
+		{
+			name:           "invalid apply",
+			query:          "?apply=yes",
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"query is not valid","request_id":"test-request","fields":[` +
+				`{"field":"apply","rule":"type","message":"must be true or false"}]}`,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock := mocks.NewMockService(ctrl)
+	l := mocks.NewMockLedger(ctrl)
+	server := httptest.NewServer(New(mock, WithInventory(l),
+		WithUnitCatalog(units.Catalog{
+			"flour": {Units: []units.Unit{units.Kilogram, units.Gram}, Precision: 2},
+			"milk":  {Units: []units.Unit{units.Litre}, Precision: 1},
+		}),
+		WithProductCatalog(products.Catalog{
+			"milk":  {Discontinued: true},
+			"flour": {Price: 3.5},
+			"eggs":  {Price: 0.3},
+			"salt":  {Price: 2},
+		}),
+	))
+	defer server.Close()
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.expect != nil {
+				tc.expect(mock.EXPECT(), l.EXPECT())
+			}
+			req, err := http.NewRequest(http.MethodPost, server.URL+"/carts/"+cartID+"/validate"+tc.query, nil)
+			require.NoError(t, err, "could not create request")
+			req.Header.Set(RequestIDHeader, "test-request")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "could not get response")
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err, "could not read response")
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "Two status codes should be the same")
+			assert.Equal(t, tc.expectedResponse, string(bytes.TrimSpace(b)), "Two response bodies should be the same")
+		})
+	}
+}
+
+func itemJSON(item service.CartItem) string {
+	price := ""
+	if item.Price != 0 {
+		price = fmt.Sprintf(`,"price":%v`, item.Price)
+	}
+	return fmt.Sprintf(`{"id":"%s","cart_id":"%s","product":"%s","quantity":%v,"unit":"%s"%s}`,
+		item.ID.Hex(), item.CartID.Hex(), item.ProductName, item.Quantity, item.Unit, price)
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+	"github.com/HarlamovBuldog/cart_api/pkg/webhook"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxWebhookURLLength = 2048
+	minSecretLength     = 16
+	maxSecretLength     = 256
+	// generatedSecretBytes is number of random bytes of secrets generated for subscriptions created without one.
+	generatedSecretBytes = 32
+
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+// WithWebhooks enables /webhooks routes managing subscriptions of store.
+// The routes serve only clients presenting a verified certificate over mutual TLS.
+func WithWebhooks(store webhook.Store) Option {
+	return func(s *Server) {
+		s.webhooks = store
+	}
+}
+
+// webhookRequest creates or replaces a subscription. Empty secret is generated on creation and kept on update.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+type webhooksResponse struct {
+	Webhooks []webhook.Subscription `json:"webhooks"`
+}
+
+type deliveriesResponse struct {
+	Deliveries []webhook.Delivery `json:"deliveries"`
+}
+
+func (r webhookRequest) validate() error {
+	errs := [][]validation.FieldError{
+		validation.Field("url", r.URL,
+			validation.Required[string](),
+			validation.MaxLength(maxWebhookURLLength),
+			httpURL(),
+		),
+		validation.Field("secret", r.Secret, validation.MinLength(minSecretLength), validation.MaxLength(maxSecretLength)),
+	}
+	for i, e := range r.Events {
+		errs = append(errs, validation.Field(fmt.Sprintf("events[%d]", i), e, validation.OneOf(webhook.EventTypes...)))
+	}
+	return validation.Validate(errs...)
+}
+
+// requireClientCert answers 403 Forbidden to clients without a verified certificate presented over mutual TLS,
+// since subscriptions make the server send cart events to any URL.
+func requireClientCert(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if clientCommonName(req) == "" {
+			writeJSONError(w, req, http.StatusForbidden, "verified client certificate is required")
+			return
+		}
+		h(w, req)
+	}
+}
+
+// httpURL is violated by non-empty strings which are not absolute http or https URLs.
+func httpURL() validation.Rule[string] {
+	return func(value string) *validation.Violation {
+		if value == "" {
+			return nil
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &validation.Violation{Rule: "url", Message: "must be an absolute http or https URL"}
+		}
+		return nil
+	}
+}
+
+// checkWebhookURL resolves host of a valid subscription URL. Hosts which can not be resolved or resolve
+// to addresses which are not public are reported as violations of url.
+func (s *Server) checkWebhookURL(ctx context.Context, rawURL string) error {
+	err := webhook.CheckURL(ctx, s.resolver, rawURL)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Cause(err) == webhook.ErrForbiddenDestination:
+		return validation.Errors{{Field: "url", Rule: "public_address",
+			Message: "must not resolve to a loopback, private, link-local or unspecified address"}}
+	default:
+		return validation.Errors{{Field: "url", Rule: "resolvable", Message: "must have a host which can be resolved"}}
+	}
+}
+
+func (s *Server) createWebhook(w http.ResponseWriter, req *http.Request) {
+	var body webhookRequest
+	if err := s.decodeJSON(w, req, &body); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if err := body.validate(); err != nil {
+		writeValidationError(w, req, err)
+		return
+	}
+	if err := s.checkWebhookURL(req.Context(), body.URL); err != nil {
+		writeValidationError(w, req, err)
+		return
+	}
+	if body.Secret == "" {
+		b := make([]byte, generatedSecretBytes)
+		if _, err := rand.Read(b); err != nil {
+			s.writeWebhookError(w, req, errors.Wrap(err, "could not generate secret"))
+			return
+		}
+		body.Secret = hex.EncodeToString(b)
+	}
+
+	sub, err := s.webhooks.CreateSubscription(req.Context(), webhook.Subscription{
+		URL:    body.URL,
+		Events: body.Events,
+		Secret: body.Secret,
+	})
+	if err != nil {
+		s.writeWebhookError(w, req, errors.Wrap(err, "could not create webhook"))
+		return
+	}
+	// secret is returned only once
+	writeJSON(w, req, http.StatusCreated, sub)
+}
+
+func (s *Server) listWebhooks(w http.ResponseWriter, req *http.Request) {
+	subs, err := s.webhooks.Subscriptions(req.Context())
+	if err != nil {
+		s.writeWebhookError(w, req, errors.Wrap(err, "could not get webhooks"))
+		return
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	writeJSON(w, req, http.StatusOK, webhooksResponse{Webhooks: subs})
+}
+
+func (s *Server) getWebhook(w http.ResponseWriter, req *http.Request) {
+	sub, err := s.webhooks.Subscription(req.Context(), mux.Vars(req)["webhook_id"])
+	if err != nil {
+		s.writeWebhookError(w, req, errors.Wrap(err, "could not get webhook"))
+		return
+	}
+	sub.Secret = ""
+	writeJSON(w, req, http.StatusOK, sub)
+}
+
+func (s *Server) updateWebhook(w http.ResponseWriter, req *http.Request) {
+	var body webhookRequest
+	if err := s.decodeJSON(w, req, &body); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if err := body.validate(); err != nil {
+		writeValidationError(w, req, err)
+		return
+	}
+	if err := s.checkWebhookURL(req.Context(), body.URL); err != nil {
+		writeValidationError(w, req, err)
+		return
+	}
+
+	sub, err := s.webhooks.UpdateSubscription(req.Context(), webhook.Subscription{
+		ID:     mux.Vars(req)["webhook_id"],
+		URL:    body.URL,
+		Events: body.Events,
+		Secret: body.Secret,
+	})
+	if err != nil {
+		s.writeWebhookError(w, req, errors.Wrap(err, "could not update webhook"))
+		return
+	}
+	sub.Secret = ""
+	writeJSON(w, req, http.StatusOK, sub)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, req *http.Request) {
+	err := s.webhooks.DeleteSubscription(req.Context(), mux.Vars(req)["webhook_id"])
+	if err != nil {
+		s.writeWebhookError(w, req, errors.Wrap(err, "could not delete webhook"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// webhookDeliveries returns the delivery log of a subscription, latest deliveries first.
+// Dead deliveries are listed with status=dead.
+func (s *Server) webhookDeliveries(w http.ResponseWriter, req *http.Request) {
+	status := req.URL.Query().Get("status")
+	errs := validation.Field("status", status,
+		validation.When(status != "", validation.OneOf(webhook.StatusPending, webhook.StatusDelivered, webhook.StatusDead)))
+	limit, limitErrs := queryLimit(req, defaultDeliveriesLimit, maxDeliveriesLimit)
+	errs = append(errs, limitErrs...)
+	if len(errs) > 0 {
+		writeErrorResponse(w, req, http.StatusBadRequest, errorResponse{Error: "query is not valid", Fields: errs})
+		return
+	}
+
+	id := mux.Vars(req)["webhook_id"]
+	if _, err := s.webhooks.Subscription(req.Context(), id); err != nil {
+		s.writeWebhookError(w, req, errors.Wrap(err, "could not get webhook"))
+		return
+	}
+	deliveries, err := s.webhooks.Deliveries(req.Context(), id, status, limit)
+	if err != nil {
+		s.writeWebhookError(w, req, errors.Wrap(err, "could not get deliveries"))
+		return
+	}
+	writeJSON(w, req, http.StatusOK, deliveriesResponse{Deliveries: deliveries})
+}
+
+// retryWebhookDelivery makes a dead delivery pending, so it is attempted once more.
+func (s *Server) retryWebhookDelivery(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	d, err := s.webhooks.RetryDelivery(req.Context(), vars["webhook_id"], vars["delivery_id"])
+	if err != nil {
+		s.writeWebhookError(w, req, errors.Wrap(err, "could not retry delivery"))
+		return
+	}
+	writeJSON(w, req, http.StatusAccepted, d)
+}
+
+// writeWebhookError responds with 404 if err is caused by service.ErrNotFound and with 500 otherwise.
+func (s *Server) writeWebhookError(w http.ResponseWriter, req *http.Request, err error) {
+	if errors.Cause(err) == service.ErrNotFound {
+		writeJSONError(w, req, http.StatusNotFound, err.Error())
+		return
+	}
+	s.log(req).Error("webhook request failed", logger.Err(err))
+	writeJSONError(w, req, http.StatusInternalServerError, err.Error())
+}
+
+// writeJSON responds with status and v encoded as JSON.
+func writeJSON(w http.ResponseWriter, req *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.FromContext(req.Context(), nil).Error("could not encode json", slog.String("path", req.URL.Path), logger.Err(err))
+	}
+}
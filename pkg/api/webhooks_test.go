@@ -0,0 +1,260 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/webhook"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_webhooks(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sub := webhook.Subscription{
+		ID:        "sub1",
+		URL:       "https://example.com/hook",
+		Events:    []string{"item_added"},
+		Secret:    "0123456789abcdef",
+		CreatedAt: created,
+	}
+	subResponse := `{"id":"sub1","url":"https://example.com/hook","events":["item_added"],"created_at":"2024-01-02T03:04:05Z"}`
+
+	tt := []struct {
+		name             string
+		method           string
+		path             string
+		request          string
+		expect           func(store *mocks.MockStoreMockRecorder)
+		anonymous        bool
+		expectedStatus   int
+		expectedResponse string
+	}{
+		{
+			name:             "client without certificate",
+			method:           http.MethodGet,
+			path:             "/webhooks",
+			anonymous:        true,
+			expectedStatus:   http.StatusForbidden,
+			expectedResponse: `{"error":"verified client certificate is required","request_id":"test-request"}`,
+		},
+		{
+			name:           "create with private destination",
+			method:         http.MethodPost,
+			path:           "/webhooks",
+			request:        `{"url":"http://169.254.169.254/latest/meta-data"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"url","rule":"public_address",` +
+				`"message":"must not resolve to a loopback, private, link-local or unspecified address"}]}`,
+		},
+		{
+			name:           "update with unresolvable destination",
+			method:         http.MethodPut,
+			path:           "/webhooks/sub1",
+			request:        `{"url":"https://missing.example/hook"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"url","rule":"resolvable","message":"must have a host which can be resolved"}]}`,
+		},
+		{
+			name:    "create with secret",
+			method:  http.MethodPost,
+			path:    "/webhooks",
+			request: `{"url":"https://example.com/hook","events":["item_added"],"secret":"0123456789abcdef"}`,
+			expect: func(store *mocks.MockStoreMockRecorder) {
+				store.CreateSubscription(gomock.Any(), webhook.Subscription{
+					URL:    sub.URL,
+					Events: sub.Events,
+					Secret: sub.Secret,
+				}).Times(1).Return(&sub, nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedResponse: `{"id":"sub1","url":"https://example.com/hook","events":["item_added"],` +
+				`"secret":"0123456789abcdef","created_at":"2024-01-02T03:04:05Z"}`,
+		},
+		{
+			name:           "invalid subscription",
+			method:         http.MethodPost,
+			path:           "/webhooks",
+			request:        `{"url":"ftp://example.com","events":["item_added","checked_out"],"secret":"short"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"request body is not valid","request_id":"test-request","fields":[` +
+				`{"field":"url","rule":"url","message":"must be an absolute http or https URL"},` +
+				`{"field":"secret","rule":"min_length","message":"must not be shorter than 16 characters"},` +
+				`{"field":"events[1]","rule":"one_of","message":"must be one of [cart_created item_added item_updated item_removed cart_deleted cart_abandoned]"}]}`,
+		},
+		{
+			name:   "list hides secrets",
+			method: http.MethodGet,
+			path:   "/webhooks",
+			expect: func(store *mocks.MockStoreMockRecorder) {
+				store.Subscriptions(gomock.Any()).Times(1).Return([]webhook.Subscription{sub}, nil)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedResponse: `{"webhooks":[` + subResponse + `]}`,
+		},
+		{
+			name:    "update",
+			method:  http.MethodPut,
+			path:    "/webhooks/sub1",
+			request: `{"url":"https://example.com/hook","events":["item_added"]}`,
+			expect: func(store *mocks.MockStoreMockRecorder) {
+				store.UpdateSubscription(gomock.Any(), webhook.Subscription{
+					ID:     "sub1",
+					URL:    sub.URL,
+					Events: sub.Events,
+				}).Times(1).Return(&sub, nil)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedResponse: subResponse,
+		},
+		{
+			name:   "missing subscription",
+			method: http.MethodGet,
+			path:   "/webhooks/sub2",
+			expect: func(store *mocks.MockStoreMockRecorder) {
+				store.Subscription(gomock.Any(), "sub2").Times(1).Return(nil, errors.Wrap(service.ErrNotFound, "no webhooks"))
+			},
+			expectedStatus:   http.StatusNotFound,
+			expectedResponse: `{"error":"could not get webhook: no webhooks: not found","request_id":"test-request"}`,
+		},
+		{
+			name:   "delete",
+			method: http.MethodDelete,
+			path:   "/webhooks/sub1",
+			expect: func(store *mocks.MockStoreMockRecorder) {
+				store.DeleteSubscription(gomock.Any(), "sub1").Times(1).Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:   "dead deliveries",
+			method: http.MethodGet,
+			path:   "/webhooks/sub1/deliveries?status=dead&limit=1",
+			expect: func(store *mocks.MockStoreMockRecorder) {
+				store.Subscription(gomock.Any(), "sub1").Times(1).Return(&sub, nil)
+				store.Deliveries(gomock.Any(), "sub1", webhook.StatusDead, 1).Times(1).Return([]webhook.Delivery{
+					{ID: "d1", SubscriptionID: "sub1", Status: webhook.StatusDead, Attempts: []webhook.Attempt{
+						{Time: created, StatusCode: http.StatusGone, Error: "event is rejected with status 410"},
+					}, CreatedAt: created},
+				}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedResponse: `{"deliveries":[{"id":"d1","subscription_id":"sub1","event":{"id":"","type":"","cart_id":"",` +
+				`"time":"0001-01-01T00:00:00Z"},"status":"dead","attempts":[{"time":"2024-01-02T03:04:05Z","status_code":410,` +
+				`"error":"event is rejected with status 410"}],"created_at":"2024-01-02T03:04:05Z"}]}`,
+		},
+		{
+			name:           "invalid deliveries query",
+			method:         http.MethodGet,
+			path:           "/webhooks/sub1/deliveries?status=lost&limit=1000",
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: `{"error":"query is not valid","request_id":"test-request","fields":[` +
+				`{"field":"status","rule":"one_of","message":"must be one of [pending delivered dead]"},` +
+				`{"field":"limit","rule":"max","message":"must not be greater than 500"}]}`,
+		},
+		{
+			name:   "retry",
+			method: http.MethodPost,
+			path:   "/webhooks/sub1/deliveries/d1/retry",
+			expect: func(store *mocks.MockStoreMockRecorder) {
+				store.RetryDelivery(gomock.Any(), "sub1", "d1").Times(1).Return(&webhook.Delivery{
+					ID: "d1", SubscriptionID: "sub1", Status: webhook.StatusPending, CreatedAt: created,
+				}, nil)
+			},
+			expectedStatus: http.StatusAccepted,
+			expectedResponse: `{"id":"d1","subscription_id":"sub1","event":{"id":"","type":"","cart_id":"",` +
+				`"time":"0001-01-01T00:00:00Z"},"status":"pending","attempts":null,"created_at":"2024-01-02T03:04:05Z"}`,
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockStore(ctrl)
+	s := New(mocks.NewMockService(ctrl), WithWebhooks(store))
+	s.resolver = testResolver
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.expect != nil {
+				tc.expect(store.EXPECT())
+			}
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.request))
+			req.Header.Set(RequestIDHeader, "test-request")
+			if tc.request != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if !tc.anonymous {
+				withClientCert(req, "ops")
+			}
+
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expectedStatus, rec.Code, "Two status codes should be the same")
+			assert.Equal(t, tc.expectedResponse, string(bytes.TrimSpace(rec.Body.Bytes())), "Two response bodies should be the same")
+		})
+	}
+}
+
+// testResolver resolves example.com to its public address and fails to resolve other hosts.
+var testResolver = resolverFunc(func(_ context.Context, host string) ([]net.IPAddr, error) {
+	if host != "example.com" {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+})
+
+type resolverFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+func (f resolverFunc) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return f(ctx, host)
+}
+
+// withClientCert makes req look as if sent over mutual TLS by a client with a verified certificate named cn.
+func withClientCert(req *http.Request, cn string) {
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}},
+	}
+}
+
+func Test_createWebhook_generatesSecret(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockStore(ctrl)
+	store.EXPECT().CreateSubscription(gomock.Any(), gomock.Any()).Times(1).
+		DoAndReturn(func(_ interface{}, sub webhook.Subscription) (*webhook.Subscription, error) {
+			sub.ID = "sub1"
+			return &sub, nil
+		})
+	s := New(mocks.NewMockService(ctrl), WithWebhooks(store))
+	s.resolver = testResolver
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"https://example.com/hook"}`))
+	req.Header.Set("Content-Type", "application/json")
+	withClientCert(req, "ops")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	var sub webhook.Subscription
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&sub), "could not decode response")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Regexp(t, "^[0-9a-f]{64}$", sub.Secret)
+}
@@ -0,0 +1,344 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+const (
+	// wsPingInterval is how often connected clients are pinged, wsPongTimeout is how long their answer is awaited.
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 2 * wsPingInterval
+	// wsWriteTimeout limits writing a single message.
+	wsWriteTimeout = 10 * time.Second
+	// maxParticipantName limits length of names shown in presence info.
+	maxParticipantName = 64
+)
+
+// Types of messages sent to WebSocket clients.
+const (
+	wsCart     = "cart"
+	wsEvent    = "event"
+	wsPresence = "presence"
+	wsAck      = "ack"
+	wsError    = "error"
+)
+
+// Codes of errors sent to WebSocket clients.
+const (
+	wsBadMessage = "bad_message"
+	wsInvalid    = "invalid"
+	wsConflict   = "conflict"
+	wsFailed     = "failed"
+	wsNotFound   = "not_found"
+	wsInternal   = "internal"
+)
+
+// wsCommand is a change of items sent by a WebSocket client. It has fields of a batch operation.
+// Version is the cart version the change was made against, commands without it are applied to any version.
+type wsCommand struct {
+	ID      string `json:"id"`
+	Version *int64 `json:"version"`
+	batchOperation
+
+	decodeErr error
+}
+
+// wsMessage is sent to WebSocket clients. Type tells which fields are set.
+type wsMessage struct {
+	Type     string                       `json:"type"`
+	ID       string                       `json:"id,omitempty"`
+	Cart     *service.Cart                `json:"cart,omitempty"`
+	Event    *events.Event                `json:"event,omitempty"`
+	Presence []participant                `json:"presence,omitempty"`
+	Result   *service.ItemOperationResult `json:"result,omitempty"`
+	Version  int64                        `json:"version,omitempty"`
+	Code     string                       `json:"code,omitempty"`
+	Error    string                       `json:"error,omitempty"`
+	Fields   []validation.FieldError      `json:"fields,omitempty"`
+}
+
+// participant is a client connected to a cart.
+type participant struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name"`
+	Since time.Time `json:"since"`
+}
+
+// wsClient is a participant receiving presence info.
+type wsClient struct {
+	participant
+	// presence holds the latest participant list not yet sent to the client
+	presence chan []participant
+}
+
+// rooms tracks clients connected to carts through this server.
+type rooms struct {
+	mu    sync.Mutex
+	carts map[string]map[*wsClient]struct{}
+}
+
+func (r *rooms) join(cartID string, c *wsClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.carts == nil {
+		r.carts = make(map[string]map[*wsClient]struct{})
+	}
+	if r.carts[cartID] == nil {
+		r.carts[cartID] = make(map[*wsClient]struct{})
+	}
+	r.carts[cartID][c] = struct{}{}
+	r.broadcast(cartID)
+}
+
+func (r *rooms) leave(cartID string, c *wsClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.carts[cartID], c)
+	if len(r.carts[cartID]) == 0 {
+		delete(r.carts, cartID)
+		return
+	}
+	r.broadcast(cartID)
+}
+
+// broadcast replaces presence info pending for clients of a cart with the current participant list.
+func (r *rooms) broadcast(cartID string) {
+	list := make([]participant, 0, len(r.carts[cartID]))
+	for c := range r.carts[cartID] {
+		list = append(list, c.participant)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Since.Equal(list[j].Since) {
+			return list[i].Since.Before(list[j].Since)
+		}
+		return list[i].ID < list[j].ID
+	})
+	for c := range r.carts[cartID] {
+		select {
+		case <-c.presence:
+		default:
+		}
+		c.presence <- list
+	}
+}
+
+// cartWebSocket lets clients edit a cart together. Clients get the cart, participant list and events of the cart
+// as they happen, followed by the cart with its new version. Commands are answered with ack or error messages;
+// a conflicting version is answered with conflict error followed by the current cart.
+func (s *Server) cartWebSocket(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	cartID, ok := vars["cart_id"]
+	if !ok {
+		writeJSONError(w, req, http.StatusBadRequest, "cart_id is not provided")
+		return
+	}
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		name = clientCommonName(req)
+	}
+	if name == "" {
+		name = "anonymous"
+	}
+	if err := validation.Validate(validation.Field("name", name, validation.MaxLength(maxParticipantName))); err != nil {
+		writeValidationError(w, req, err)
+		return
+	}
+
+	cart, err := s.service.Cart(req.Context(), cartID)
+	switch {
+	case errors.Cause(err) == service.ErrNotFound:
+		writeJSONError(w, req, http.StatusNotFound, "could not get cart: "+err.Error())
+		return
+	case err != nil:
+		s.log(req).Error("could not get cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+		writeJSONError(w, req, http.StatusInternalServerError, "could not get cart: "+err.Error())
+		return
+	}
+
+	sub := s.events.Subscribe(cartID)
+	defer sub.Close()
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// upgrader has already answered the client
+		s.log(req).Debug("could not upgrade connection", logger.Err(err))
+		return
+	}
+	defer conn.Close()
+
+	c := &wsClient{
+		participant: participant{ID: newRequestID(), Name: name, Since: time.Now().UTC()},
+		presence:    make(chan []participant, 1),
+	}
+	err = s.writeWS(conn, wsMessage{Type: wsCart, Cart: cart})
+	if err != nil {
+		s.log(req).Debug("could not send cart", logger.Err(err))
+		return
+	}
+	s.rooms.join(cartID, c)
+	defer s.rooms.leave(cartID, c)
+
+	commands := make(chan wsCommand)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go readCommands(conn, s.maxBodyBytes, commands, readErr, done)
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for err == nil {
+		select {
+		case cmd := <-commands:
+			err = s.applyCommand(req, conn, cartID, cmd)
+		case e, ok := <-sub.C:
+			if !ok {
+				err = s.closeWS(conn, websocket.CloseTryAgainLater, "too many events, reconnect")
+				break
+			}
+			err = s.writeWS(conn, wsMessage{Type: wsEvent, Event: &e})
+			if err != nil {
+				break
+			}
+			if e.Type == events.CartDeleted {
+				err = s.closeWS(conn, websocket.CloseNormalClosure, "cart is deleted")
+				break
+			}
+			if len(sub.C) == 0 {
+				// events are followed by the cart once they stop coming, so clients learn its version
+				err = s.writeCart(req, conn, cartID, "")
+			}
+		case list := <-c.presence:
+			err = s.writeWS(conn, wsMessage{Type: wsPresence, Presence: list})
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		case err = <-readErr:
+		case <-s.drained:
+			err = s.closeWS(conn, websocket.CloseGoingAway, "server is shutting down")
+		}
+	}
+	if err != errWSClosed && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		s.log(req).Debug("websocket closed", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+	}
+}
+
+// errWSClosed ends a connection closed by the server.
+var errWSClosed = errors.New("connection is closed by server")
+
+// readCommands sends commands read from conn to commands until reading fails or done is closed.
+// Error of reading is sent to errc.
+func readCommands(conn *websocket.Conn, limit int64, commands chan<- wsCommand, errc chan<- error, done <-chan struct{}) {
+	conn.SetReadLimit(limit)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	for {
+		_, r, err := conn.NextReader()
+		if err != nil {
+			errc <- err
+			return
+		}
+		var cmd wsCommand
+		// commands are decoded as strictly as request bodies
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(&cmd); err == nil && dec.Decode(&struct{}{}) != io.EOF {
+			err = errors.New("unexpected data after JSON value")
+		}
+		if err != nil {
+			cmd = wsCommand{decodeErr: err}
+		}
+		select {
+		case commands <- cmd:
+		case <-done:
+			return
+		}
+	}
+}
+
+// applyCommand validates cmd as a batch operation, applies it to the cart and answers the client.
+func (s *Server) applyCommand(req *http.Request, conn *websocket.Conn, cartID string, cmd wsCommand) error {
+	if cmd.decodeErr != nil {
+		return s.writeWS(conn, wsMessage{Type: wsError, Code: wsBadMessage, Error: "could not decode command: " + cmd.decodeErr.Error()})
+	}
+	lines, err := s.updatedLines(req.Context(), cartID, []batchOperation{cmd.batchOperation})
+	if errors.Cause(err) == service.ErrNotFound {
+		return s.writeWS(conn, wsMessage{Type: wsError, ID: cmd.ID, Code: wsNotFound, Error: err.Error()})
+	}
+	if err != nil {
+		s.log(req).Error("could not get cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+		return s.writeWS(conn, wsMessage{Type: wsError, ID: cmd.ID, Code: wsInternal, Error: "could not apply command"})
+	}
+	errs := cmd.validate(s.units, lines)
+	if len(errs) > 0 {
+		return s.writeWS(conn, wsMessage{Type: wsError, ID: cmd.ID, Code: wsInvalid, Error: "command is not valid", Fields: errs})
+	}
+	version := service.AnyVersion
+	if cmd.Version != nil {
+		version = *cmd.Version
+	}
+	ops := []service.ItemOperation{cmd.itemOperation(s.products)}
+	results, err := s.service.ApplyItemOperations(req.Context(), cartID, version, ops)
+	switch errors.Cause(err) {
+	case nil:
+		ack := wsMessage{Type: wsAck, ID: cmd.ID, Result: &results[0]}
+		if version != service.AnyVersion {
+			ack.Version = version + 1
+		}
+		return s.writeWS(conn, ack)
+	case service.ErrVersionConflict:
+		err = s.writeWS(conn, wsMessage{Type: wsError, ID: cmd.ID, Code: wsConflict, Error: err.Error()})
+		if err != nil {
+			return err
+		}
+		return s.writeCart(req, conn, cartID, cmd.ID)
+	case service.ErrOperationsFailed:
+		return s.writeWS(conn, wsMessage{Type: wsError, ID: cmd.ID, Code: wsFailed, Error: results[0].Error})
+	case service.ErrNotFound:
+		return s.writeWS(conn, wsMessage{Type: wsError, ID: cmd.ID, Code: wsNotFound, Error: err.Error()})
+	default:
+		s.log(req).Error("could not apply item operations", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+		return s.writeWS(conn, wsMessage{Type: wsError, ID: cmd.ID, Code: wsInternal, Error: "could not apply command"})
+	}
+}
+
+// writeCart sends the current cart. id is ID of the command the cart answers, if any.
+func (s *Server) writeCart(req *http.Request, conn *websocket.Conn, cartID, id string) error {
+	cart, err := s.service.Cart(req.Context(), cartID)
+	if err != nil {
+		s.log(req).Error("could not get cart", slog.String(logger.CartIDKey, cartID), logger.Err(err))
+		return s.closeWS(conn, websocket.CloseInternalServerErr, "could not get cart")
+	}
+	return s.writeWS(conn, wsMessage{Type: wsCart, ID: id, Cart: cart})
+}
+
+func (s *Server) writeWS(conn *websocket.Conn, msg wsMessage) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+		return err
+	}
+	return errors.Wrapf(conn.WriteJSON(msg), "could not write %s message", msg.Type)
+}
+
+// closeWS sends close message and returns errWSClosed, so the handler stops.
+func (s *Server) closeWS(conn *websocket.Conn, code int, reason string) error {
+	err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+	if err != nil {
+		return errors.Wrap(err, "could not close connection")
+	}
+	return errWSClosed
+}
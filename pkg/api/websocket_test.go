@@ -0,0 +1,160 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/mocks"
+	"github.com/HarlamovBuldog/cart_api/pkg/mongo"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+	"github.com/HarlamovBuldog/cart_api/pkg/validation"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextWS returns the next message of type typ sent to conn, skipping messages of other types.
+func nextWS(t *testing.T, conn *websocket.Conn, typ string) wsMessage {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for {
+		var msg wsMessage
+		require.NoError(t, conn.ReadJSON(&msg), "could not read message")
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+// names returns names of participants.
+func names(list []participant) []string {
+	var res []string
+	for _, p := range list {
+		res = append(res, p.Name)
+	}
+	return res
+}
+
+func Test_cartWebSocket(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(2)
+	cartItemObjIDSet := generatePrimObjIDSet(2)
+	cartID := cartObjIDSet[0].Hex()
+	flour := service.CartItem{ID: cartItemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "flour", Quantity: 1, Unit: units.Kilogram}
+	cart := &service.Cart{ID: cartObjIDSet[0], Items: []service.CartItem{flour}, Version: 3}
+	added := service.CartItem{ID: cartItemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "milk", Quantity: 1, Unit: units.Piece}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock := mocks.NewMockService(ctrl)
+	mock.EXPECT().Cart(gomock.Any(), cartID).AnyTimes().Return(cart, nil)
+	mock.EXPECT().Cart(gomock.Any(), cartObjIDSet[1].Hex()).AnyTimes().Return(nil, errors.Wrap(mongo.ErrNotFound, "no carts"))
+
+	hub := events.NewHub()
+	s := New(mock, WithEvents(hub), WithUnitCatalog(units.Catalog{
+		"flour": {Units: []units.Unit{units.Kilogram, units.Gram}, Precision: 1},
+	}))
+	server := httptest.NewServer(s)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	dial := func(name string) *websocket.Conn {
+		conn, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s/carts/%s/ws?name=%s", wsURL, cartID, name), nil)
+		require.NoError(t, err, "could not connect")
+		resp.Body.Close()
+		assert.Equal(t, int64(3), nextWS(t, conn, wsCart).Cart.Version)
+		return conn
+	}
+	alice := dial("alice")
+	defer alice.Close()
+	assert.Equal(t, []string{"alice"}, names(nextWS(t, alice, wsPresence).Presence))
+	bob := dial("bob")
+	assert.Equal(t, []string{"alice", "bob"}, names(nextWS(t, bob, wsPresence).Presence))
+	assert.Equal(t, []string{"alice", "bob"}, names(nextWS(t, alice, wsPresence).Presence))
+
+	t.Run("command is applied at the version", func(t *testing.T) {
+		mock.EXPECT().ApplyItemOperations(gomock.Any(), cartID, int64(3), []service.ItemOperation{
+			{Op: service.OpAdd, Item: service.CartItem{ProductName: "milk", Quantity: 1, Unit: units.Piece}},
+		}).Times(1).Return([]service.ItemOperationResult{{Op: service.OpAdd, Item: &added}}, nil)
+
+		require.NoError(t, alice.WriteJSON(map[string]interface{}{"id": "1", "version": 3, "op": "add", "product": "milk", "quantity": 1}))
+		ack := nextWS(t, alice, wsAck)
+		assert.Equal(t, "1", ack.ID)
+		assert.Equal(t, int64(4), ack.Version)
+		assert.Equal(t, &added, ack.Result.Item)
+	})
+
+	t.Run("events are followed by the cart", func(t *testing.T) {
+		hub.Publish(events.Event{Type: events.ItemAdded, CartID: cartID, ItemID: added.ID.Hex(), Item: &added})
+		for _, conn := range []*websocket.Conn{alice, bob} {
+			msg := nextWS(t, conn, wsEvent)
+			assert.Equal(t, events.ItemAdded, msg.Event.Type)
+			assert.Equal(t, added.ID.Hex(), msg.Event.ItemID)
+			assert.Equal(t, int64(3), nextWS(t, conn, wsCart).Cart.Version)
+		}
+	})
+
+	t.Run("conflict is followed by the cart", func(t *testing.T) {
+		mock.EXPECT().ApplyItemOperations(gomock.Any(), cartID, int64(2), gomock.Any()).Times(1).
+			Return(nil, errors.Wrap(service.ErrVersionConflict, "cart version is 3"))
+
+		require.NoError(t, bob.WriteJSON(map[string]interface{}{"id": "2", "version": 2, "op": "remove", "item_id": added.ID.Hex()}))
+		msg := nextWS(t, bob, wsError)
+		assert.Equal(t, wsConflict, msg.Code)
+		assert.Equal(t, "2", msg.ID)
+		msg = nextWS(t, bob, wsCart)
+		assert.Equal(t, "2", msg.ID)
+		assert.Equal(t, int64(3), msg.Cart.Version)
+	})
+
+	t.Run("invalid command", func(t *testing.T) {
+		require.NoError(t, bob.WriteJSON(map[string]interface{}{"id": "3", "op": "update", "quantity": -1}))
+		msg := nextWS(t, bob, wsError)
+		assert.Equal(t, wsInvalid, msg.Code)
+		assert.Equal(t, "3", msg.ID)
+		assert.NotEmpty(t, msg.Fields)
+
+		require.NoError(t, bob.WriteJSON(map[string]interface{}{"id": "4", "op": "update", "item_id": flour.ID.Hex(), "quantity": 1.25}))
+		msg = nextWS(t, bob, wsError)
+		assert.Equal(t, wsInvalid, msg.Code)
+		assert.Equal(t, []validation.FieldError{
+			{Field: "quantity", Rule: "precision", Message: "must not have more than 1 decimal places"},
+		}, msg.Fields)
+
+		require.NoError(t, bob.WriteMessage(websocket.TextMessage, []byte("{")))
+		assert.Equal(t, wsBadMessage, nextWS(t, bob, wsError).Code)
+
+		require.NoError(t, bob.WriteJSON(map[string]interface{}{"id": "5", "op": "remove", "itemid": flour.ID.Hex()}))
+		msg = nextWS(t, bob, wsError)
+		assert.Equal(t, wsBadMessage, msg.Code)
+		assert.Equal(t, `could not decode command: json: unknown field "itemid"`, msg.Error)
+	})
+
+	t.Run("leaving client is removed from presence", func(t *testing.T) {
+		require.NoError(t, bob.Close())
+		assert.Equal(t, []string{"alice"}, names(nextWS(t, alice, wsPresence).Presence))
+	})
+
+	t.Run("connection is closed with deletion of the cart", func(t *testing.T) {
+		hub.Publish(events.Event{Type: events.CartDeleted, CartID: cartID})
+		assert.Equal(t, events.CartDeleted, nextWS(t, alice, wsEvent).Event.Type)
+		var msg wsMessage
+		err := alice.ReadJSON(&msg)
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "Connection should be closed, got %v", err)
+	})
+
+	t.Run("missing cart", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s/carts/%s/ws", wsURL, cartObjIDSet[1].Hex()), nil)
+		require.Error(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
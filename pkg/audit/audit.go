@@ -0,0 +1,80 @@
+//go:generate mockgen -source=audit.go -destination=../mocks/audit_mock.go -package=mocks
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+
+	"github.com/pkg/errors"
+)
+
+// Names of actors not identified by a client certificate.
+const (
+	// Anonymous makes requests of clients without a verified certificate.
+	Anonymous = "anonymous"
+	// System makes changes outside of requests, e.g. marks carts abandoned.
+	System = "system"
+)
+
+// Actor is who makes a change and the request it is made with.
+type Actor struct {
+	Name      string
+	RequestID string
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying a.
+func NewContext(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, ctxKey{}, a)
+}
+
+// FromContext returns actor stored in ctx by NewContext.
+// Func returns System actor if ctx does not carry one.
+func FromContext(ctx context.Context) Actor {
+	if a, ok := ctx.Value(ctxKey{}).(Actor); ok {
+		return a
+	}
+	return Actor{Name: System}
+}
+
+// Entry is a recorded change of a cart. Action is a type of cart event.
+// Before and After hold the changed line with its quantity before and after the change,
+// Before is nil for added lines and After for removed ones.
+// Entries of a single change, e.g. a batch of operations, share ChangeID. Entries of an undo refer to
+// the reverted change with Undoes.
+type Entry struct {
+	ID        string            `json:"id"`
+	ChangeID  string            `json:"change_id,omitempty"`
+	Undoes    string            `json:"undoes,omitempty"`
+	CartID    string            `json:"cart_id"`
+	Action    string            `json:"action"`
+	ItemID    string            `json:"item_id,omitempty"`
+	Before    *service.CartItem `json:"before,omitempty"`
+	After     *service.CartItem `json:"after,omitempty"`
+	Actor     string            `json:"actor"`
+	RequestID string            `json:"request_id,omitempty"`
+	Time      time.Time         `json:"time"`
+}
+
+// ErrNothingToUndo is returned by Log.Undo when every recorded change of items is undone already.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// ErrIncompleteHistory is returned by Log.CartAt when the log misses changes of the cart, e.g. made before
+// changes were recorded or lost, so the cart cannot be rewound.
+var ErrIncompleteHistory = errors.New("history of cart is incomplete")
+
+// Log is an append-only log of cart changes, which carts are reconstructed and reverted from.
+type Log interface {
+	// CartHistory returns up to limit entries of a cart, latest first.
+	// Unless before is empty, only entries recorded before the entry with that ID are returned.
+	CartHistory(ctx context.Context, cartID, before string, limit int) ([]Entry, error)
+	// CartAt returns a cart as it was at a specified time.
+	// It returns ErrIncompleteHistory if the log does not record every change of the cart since it was created.
+	CartAt(ctx context.Context, cartID string, at time.Time) (*service.Cart, error)
+	// Undo reverts the latest change of cart items which is not undone yet and returns the changed cart.
+	// It returns service.ErrVersionConflict if lines of that change were changed afterwards.
+	Undo(ctx context.Context, cartID string) (*service.Cart, error)
+}
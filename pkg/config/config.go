@@ -1,19 +1,415 @@
 package config
 
 import (
+	"flag"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/outbox"
+
 	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
 )
 
 // SERVICENAME is an environment variables prefix
 const SERVICENAME = "CARTAPI"
 
+// AppConfig contains all settings of the service.
+// Settings are taken from defaults, then optional YAML file, then environment variables, then command line flags,
+// every next source overriding the previous one.
+type AppConfig struct {
+	ServerConfig    `yaml:",inline"`
+	TLSConfig       `yaml:",inline"`
+	LogConfig       `yaml:",inline"`
+	TracingConfig   `yaml:",inline"`
+	DatabaseConfig  `yaml:",inline"`
+	CatalogConfig   `yaml:",inline"`
+	FeaturesConfig  `yaml:",inline"`
+	OutboxConfig    `yaml:",inline"`
+	WebhooksConfig  `yaml:",inline"`
+	InventoryConfig `yaml:",inline"`
+}
+
+// ServerConfig contains variables, that configure http server
+type ServerConfig struct {
+	ListenAddress     string        `split_words:"true" yaml:"listen_address"`
+	GRPCListenAddress string        `split_words:"true" yaml:"grpc_listen_address"`
+	ShutdownTimeout   time.Duration `split_words:"true" yaml:"shutdown_timeout"`
+	DrainDelay        time.Duration `split_words:"true" yaml:"drain_delay"`
+	ReadinessTimeout  time.Duration `split_words:"true" yaml:"readiness_timeout"`
+	ReadHeaderTimeout time.Duration `split_words:"true" yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `split_words:"true" yaml:"read_timeout"`
+	WriteTimeout      time.Duration `split_words:"true" yaml:"write_timeout"`
+	IdleTimeout       time.Duration `split_words:"true" yaml:"idle_timeout"`
+	MaxBodyBytes      int64         `split_words:"true" yaml:"max_body_bytes"`
+}
+
+// TLSConfig contains variables, that enable serving https
+type TLSConfig struct {
+	TLSCertFile       string        `split_words:"true" yaml:"tls_cert_file"`
+	TLSKeyFile        string        `split_words:"true" yaml:"tls_key_file"`
+	TLSClientCAFile   string        `split_words:"true" yaml:"tls_client_ca_file"`
+	TLSClientAuth     string        `split_words:"true" yaml:"tls_client_auth"`
+	TLSReloadInterval time.Duration `split_words:"true" yaml:"tls_reload_interval"`
+}
+
+// LogConfig contains variables, that configure service logs
+type LogConfig struct {
+	LogLevel  string `split_words:"true" yaml:"log_level"`
+	LogFormat string `split_words:"true" yaml:"log_format"`
+}
+
+// TracingConfig contains variables, that configure export of trace spans
+type TracingConfig struct {
+	TraceExporter string `split_words:"true" yaml:"trace_exporter"`
+}
+
 // DatabaseConfig contains variables, that are required for a database connection
 type DatabaseConfig struct {
-	DBName           string `split_words:"true" required:"true"`
-	ConnectionString string `split_words:"true" required:"true"`
+	DBName           string        `split_words:"true" yaml:"db_name"`
+	ConnectionString string        `split_words:"true" yaml:"connection_string"`
+	DBConnectTimeout time.Duration `split_words:"true" yaml:"db_connect_timeout"`
+	DBMinPoolSize    uint64        `split_words:"true" yaml:"db_min_pool_size"`
+	DBMaxPoolSize    uint64        `split_words:"true" yaml:"db_max_pool_size"`
+}
+
+// CatalogConfig contains variables, that describe products
+type CatalogConfig struct {
+	UnitsFile    string `split_words:"true" yaml:"units_file"`
+	ProductsFile string `split_words:"true" yaml:"products_file"`
+}
+
+// FeaturesConfig contains toggles of optional service features
+type FeaturesConfig struct {
+	MetricsEnabled bool `split_words:"true" yaml:"metrics_enabled"`
+	ListsEnabled   bool `split_words:"true" yaml:"lists_enabled"`
+}
+
+// OutboxConfig contains variables, that configure delivery of cart events to downstream systems
+type OutboxConfig struct {
+	OutboxPublisher    string        `split_words:"true" yaml:"outbox_publisher"`
+	OutboxPollInterval time.Duration `split_words:"true" yaml:"outbox_poll_interval"`
+}
+
+// WebhooksConfig contains variables, that configure outgoing webhooks and events they are sent for
+type WebhooksConfig struct {
+	WebhooksEnabled    bool          `split_words:"true" yaml:"webhooks_enabled"`
+	AbandonedCartAfter time.Duration `split_words:"true" yaml:"abandoned_cart_after"`
+}
+
+// InventoryConfig contains variables, that configure reservation of stock for lines of carts
+type InventoryConfig struct {
+	InventoryEnabled bool          `split_words:"true" yaml:"inventory_enabled"`
+	ReservationTTL   time.Duration `split_words:"true" yaml:"reservation_ttl"`
+}
+
+// ValidationError lists every invalid setting found in configuration.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e, "; ")
+}
+
+// Default returns configuration used when no other source sets a value.
+func Default() *AppConfig {
+	return &AppConfig{
+		ServerConfig: ServerConfig{
+			ListenAddress:     ":27000",
+			GRPCListenAddress: ":27001",
+			ShutdownTimeout:   5 * time.Second,
+			DrainDelay:        5 * time.Second,
+			ReadinessTimeout:  2 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxBodyBytes:      1 << 20,
+		},
+		TLSConfig: TLSConfig{
+			TLSClientAuth:     "none",
+			TLSReloadInterval: 10 * time.Second,
+		},
+		LogConfig: LogConfig{
+			LogLevel:  "info",
+			LogFormat: "json",
+		},
+		TracingConfig: TracingConfig{
+			TraceExporter: "none",
+		},
+		DatabaseConfig: DatabaseConfig{
+			DBName:           "cart_api",
+			ConnectionString: "mongodb://localhost:27018",
+			DBConnectTimeout: 5 * time.Second,
+		},
+		FeaturesConfig: FeaturesConfig{
+			MetricsEnabled: true,
+		},
+		OutboxConfig: OutboxConfig{
+			OutboxPollInterval: time.Second,
+		},
+		WebhooksConfig: WebhooksConfig{
+			AbandonedCartAfter: 24 * time.Hour,
+		},
+		InventoryConfig: InventoryConfig{
+			ReservationTTL: 2 * time.Hour,
+		},
+	}
+}
+
+// Load builds configuration from defaults, YAML file, environment variables prefixed with serviceName
+// and command line args, then validates it.
+// YAML file is taken from -config flag or <serviceName>_CONFIG_FILE variable.
+// Every setting has a flag named after its YAML key with dashes, e.g. -listen-address.
+func Load(serviceName string, args []string) (*AppConfig, error) {
+	c := Default()
+
+	fs := flag.NewFlagSet(serviceName, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(serviceName+"_CONFIG_FILE"), "path to YAML configuration file")
+	flagValues := make(map[string]string)
+	for _, f := range settings(c) {
+		name := f.flagName()
+		fs.Func(name, "overrides "+f.yamlKey, func(v string) error {
+			flagValues[name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, errors.Wrap(err, "could not parse flags")
+	}
+
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := envconfig.Process(serviceName, c); err != nil {
+		return nil, errors.Wrap(err, "could not process environment variables")
+	}
+
+	for _, f := range settings(c) {
+		v, ok := flagValues[f.flagName()]
+		if !ok {
+			continue
+		}
+		if err := f.set(v); err != nil {
+			return nil, errors.Wrapf(err, "invalid value %q of flag -%s", v, f.flagName())
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *AppConfig) loadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "could not read config file")
+	}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return errors.Wrapf(err, "could not parse config file %s", path)
+	}
+	return nil
+}
+
+// Validate checks every setting and returns ValidationError listing all problems found.
+func (c *AppConfig) Validate() error {
+	var errs ValidationError
+
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		errs = append(errs, "listen_address: "+err.Error())
+	}
+	if c.GRPCListenAddress != "" {
+		if _, _, err := net.SplitHostPort(c.GRPCListenAddress); err != nil {
+			errs = append(errs, "grpc_listen_address: "+err.Error())
+		}
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"readiness_timeout", c.ReadinessTimeout},
+		{"read_header_timeout", c.ReadHeaderTimeout},
+		{"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"db_connect_timeout", c.DBConnectTimeout},
+		{"outbox_poll_interval", c.OutboxPollInterval},
+		{"reservation_ttl", c.ReservationTTL},
+	} {
+		if d.value <= 0 {
+			errs = append(errs, d.key+": must be positive")
+		}
+	}
+	if c.DrainDelay < 0 {
+		errs = append(errs, "drain_delay: must not be negative")
+	}
+	if c.AbandonedCartAfter < 0 {
+		errs = append(errs, "abandoned_cart_after: must not be negative")
+	}
+	if c.MaxBodyBytes <= 0 {
+		errs = append(errs, "max_body_bytes: must be positive")
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, "tls_cert_file, tls_key_file: must be set together")
+	}
+	errs = append(errs, checkFile("tls_cert_file", c.TLSCertFile)...)
+	errs = append(errs, checkFile("tls_key_file", c.TLSKeyFile)...)
+	errs = append(errs, checkFile("tls_client_ca_file", c.TLSClientCAFile)...)
+	switch {
+	case !oneOf(c.TLSClientAuth, "none", "optional", "require"):
+		errs = append(errs, "tls_client_auth: must be one of none, optional, require")
+	case c.TLSClientAuth != "none" && (c.TLSCertFile == "" || c.TLSClientCAFile == ""):
+		errs = append(errs, "tls_client_auth: requires tls_cert_file and tls_client_ca_file")
+	}
+	if c.TLSReloadInterval < 0 {
+		errs = append(errs, "tls_reload_interval: must not be negative")
+	}
+
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, "log_level: must be one of debug, info, warn, error")
+	}
+	if !oneOf(c.LogFormat, "json", "logfmt") {
+		errs = append(errs, "log_format: must be one of json, logfmt")
+	}
+	if !oneOf(c.TraceExporter, "none", "stdout", "otlp") {
+		errs = append(errs, "trace_exporter: must be one of none, stdout, otlp")
+	}
+
+	if c.DBName == "" {
+		errs = append(errs, "db_name: must be set")
+	}
+	if c.ConnectionString == "" {
+		errs = append(errs, "connection_string: must be set")
+	}
+	if c.DBMaxPoolSize > 0 && c.DBMinPoolSize > c.DBMaxPoolSize {
+		errs = append(errs, "db_min_pool_size: must not exceed db_max_pool_size")
+	}
+
+	errs = append(errs, checkFile("units_file", c.UnitsFile)...)
+	errs = append(errs, checkFile("products_file", c.ProductsFile)...)
+
+	if c.OutboxPublisher != "" && !isPublisher(c.OutboxPublisher) {
+		errs = append(errs, "outbox_publisher: must be stdout, file:<path> or http(s) URL")
+	}
+	if c.OutboxPublisher == outbox.PublisherStdout && c.TraceExporter == "stdout" {
+		errs = append(errs, "outbox_publisher: must not be stdout while trace_exporter is stdout")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func checkFile(key, path string) []string {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return []string{key + ": " + err.Error()}
+	}
+	return nil
+}
+
+// isPublisher reports whether spec describes an outbox publisher.
+func isPublisher(spec string) bool {
+	if spec == outbox.PublisherStdout {
+		return true
+	}
+	if path, ok := strings.CutPrefix(spec, "file:"); ok {
+		return path != ""
+	}
+	u, err := url.Parse(spec)
+	return err == nil && oneOf(u.Scheme, "http", "https") && u.Host != ""
+}
+
+func oneOf(v string, allowed ...string) bool {
+	for _, a := range allowed {
+		if v == a {
+			return true
+		}
+	}
+	return false
 }
 
-// Load settles environment variables into AppConfig structure
-func (c *DatabaseConfig) Load(serviceName string) error {
-	return envconfig.Process(serviceName, c)
+// setting is a single configuration value addressed by its YAML key.
+type setting struct {
+	yamlKey string
+	value   reflect.Value
+}
+
+// settings returns all leaf settings of c.
+func settings(c *AppConfig) []setting {
+	var res []setting
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous {
+				walk(v.Field(i))
+				continue
+			}
+			res = append(res, setting{yamlKey: f.Tag.Get("yaml"), value: v.Field(i)})
+		}
+	}
+	walk(reflect.ValueOf(c).Elem())
+
+	return res
+}
+
+func (s setting) flagName() string {
+	return strings.ReplaceAll(s.yamlKey, "_", "-")
+}
+
+func (s setting) set(v string) error {
+	if s.value.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+		return nil
+	}
+
+	switch s.value.Kind() {
+	case reflect.String:
+		s.value.SetString(v)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(b)
+	case reflect.Int64:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(i)
+	case reflect.Uint64:
+		u, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetUint(u)
+	default:
+		return errors.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
 }
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testServiceName = "CARTAPITEST"
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600), "could not write config file")
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		c, err := Load(testServiceName, nil)
+		require.NoError(t, err)
+		assert.Equal(t, Default(), c)
+	})
+
+	t.Run("file, env and flags override each other", func(t *testing.T) {
+		path := writeConfigFile(t, `
+listen_address: ":8080"
+shutdown_timeout: 10s
+log_level: debug
+db_name: from_file
+db_max_pool_size: 50
+metrics_enabled: false
+lists_enabled: true
+webhooks_enabled: true
+inventory_enabled: true
+`)
+		t.Setenv(testServiceName+"_CONFIG_FILE", path)
+		t.Setenv(testServiceName+"_LOG_LEVEL", "warn")
+		t.Setenv(testServiceName+"_DB_NAME", "from_env")
+
+		c, err := Load(testServiceName, []string{"-db-name", "from_flag", "-drain-delay=1s"})
+		require.NoError(t, err)
+
+		expected := Default()
+		expected.ListenAddress = ":8080"
+		expected.ShutdownTimeout = 10 * time.Second
+		expected.DrainDelay = time.Second
+		expected.LogLevel = "warn"
+		expected.DBName = "from_flag"
+		expected.DBMaxPoolSize = 50
+		expected.MetricsEnabled = false
+		expected.ListsEnabled = true
+		expected.WebhooksEnabled = true
+		expected.InventoryEnabled = true
+		assert.Equal(t, expected, c)
+	})
+
+	t.Run("unknown key in file", func(t *testing.T) {
+		path := writeConfigFile(t, `listen_adress: ":8080"`)
+		_, err := Load(testServiceName, []string{"-config", path})
+		assert.Error(t, err)
+	})
+
+	t.Run("bad flag value", func(t *testing.T) {
+		_, err := Load(testServiceName, []string{"-shutdown-timeout", "soon"})
+		assert.Error(t, err)
+	})
+
+	t.Run("validation errors are listed", func(t *testing.T) {
+		_, err := Load(testServiceName, []string{"-listen-address", "27000", "-grpc-listen-address", "27001", "-log-format", "xml", "-tls-cert-file", "cert.pem",
+			"-outbox-publisher", "ftp://example.com", "-abandoned-cart-after", "-1h",
+			"-reservation-ttl", "0s"})
+		require.Error(t, err)
+		verr, ok := err.(ValidationError)
+		require.True(t, ok, "ValidationError is expected, got %T", err)
+		assert.Len(t, verr, 8)
+	})
+
+	t.Run("events and spans on stdout", func(t *testing.T) {
+		_, err := Load(testServiceName, []string{"-outbox-publisher", "stdout", "-trace-exporter", "stdout"})
+		require.Error(t, err)
+		verr, ok := err.(ValidationError)
+		require.True(t, ok, "ValidationError is expected, got %T", err)
+		assert.Equal(t, ValidationError{"outbox_publisher: must not be stdout while trace_exporter is stdout"}, verr)
+	})
+}
@@ -0,0 +1,190 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+)
+
+// Types of cart events.
+const (
+	CartCreated = "cart_created"
+	ItemAdded   = "item_added"
+	ItemUpdated = "item_updated"
+	ItemRemoved = "item_removed"
+	CartDeleted = "cart_deleted"
+	// CartAbandoned is written to outbox for carts with items left unchanged for a while.
+	CartAbandoned = "cart_abandoned"
+)
+
+// Default limits of Hub.
+const (
+	DefaultHistorySize = 1024
+	DefaultBufferSize  = 64
+)
+
+// Event is a change of a cart. ID is assigned by Hub and grows with every published event.
+// Previous is the changed line before an update or removal, it is recorded in audit log but not published.
+type Event struct {
+	ID       uint64            `json:"-"`
+	Type     string            `json:"type"`
+	CartID   string            `json:"cart_id"`
+	ItemID   string            `json:"item_id,omitempty"`
+	Item     *service.CartItem `json:"item,omitempty"`
+	Previous *service.CartItem `json:"-"`
+	Time     time.Time         `json:"time"`
+}
+
+// Publisher is notified about changes of carts.
+type Publisher interface {
+	Publish(e Event)
+}
+
+// Hub delivers published events to subscribers of the changed cart and keeps the latest events,
+// so subscribers can resume after reconnecting.
+// Event IDs start from the hub creation time in microseconds, so IDs issued by a previous process are never
+// mistaken for IDs of the current one.
+type Hub struct {
+	historySize int
+	bufferSize  int
+
+	mu      sync.Mutex
+	lastID  uint64
+	history []Event
+	subs    map[string]map[*Subscription]struct{}
+}
+
+// Option configures Hub.
+type Option func(*Hub)
+
+// WithHistorySize sets number of latest events of all carts kept for resuming subscribers.
+func WithHistorySize(n int) Option {
+	return func(h *Hub) {
+		if n > 0 {
+			h.historySize = n
+		}
+	}
+}
+
+// WithBufferSize sets number of events queued for a subscriber. Subscribers falling behind by more are closed.
+func WithBufferSize(n int) Option {
+	return func(h *Hub) {
+		if n > 0 {
+			h.bufferSize = n
+		}
+	}
+}
+
+// NewHub creates Hub without subscribers.
+func NewHub(opts ...Option) *Hub {
+	h := &Hub{
+		historySize: DefaultHistorySize,
+		bufferSize:  DefaultBufferSize,
+		lastID:      uint64(time.Now().UnixMicro()),
+		subs:        make(map[string]map[*Subscription]struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Publish assigns ID to e and delivers it to subscribers of the cart.
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	e.ID = h.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if len(h.history) == h.historySize {
+		copy(h.history, h.history[1:])
+		h.history = h.history[:len(h.history)-1]
+	}
+	h.history = append(h.history, e)
+
+	for sub := range h.subs[e.CartID] {
+		select {
+		case sub.ch <- e:
+		default:
+			// subscriber is too slow, it may resume from history after reconnecting
+			h.unsubscribe(sub)
+		}
+	}
+}
+
+// Subscription receives events of a cart on C until it is closed by Close or by Hub when C overflows.
+// After is ID of the last event published before the subscription.
+type Subscription struct {
+	C     <-chan Event
+	After uint64
+
+	ch     chan Event
+	hub    *Hub
+	cartID string
+}
+
+// Subscribe returns subscription to events of a cart published from now on.
+func (h *Hub) Subscribe(cartID string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.subscribe(cartID)
+}
+
+// SubscribeAfter returns subscription to events of a cart published after event with lastID
+// and events of the cart published after it in the past.
+// If events after lastID are not kept anymore or lastID was not issued by h, ok is false
+// and the subscription receives only new events.
+func (h *Hub) SubscribeAfter(cartID string, lastID uint64) (sub *Subscription, missed []Event, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	oldest := h.lastID + 1
+	if len(h.history) > 0 {
+		oldest = h.history[0].ID
+	}
+	ok = lastID+1 >= oldest && lastID <= h.lastID
+	if ok {
+		for _, e := range h.history {
+			if e.ID > lastID && e.CartID == cartID {
+				missed = append(missed, e)
+			}
+		}
+	}
+	return h.subscribe(cartID), missed, ok
+}
+
+func (h *Hub) subscribe(cartID string) *Subscription {
+	ch := make(chan Event, h.bufferSize)
+	sub := &Subscription{C: ch, After: h.lastID, ch: ch, hub: h, cartID: cartID}
+	if h.subs[cartID] == nil {
+		h.subs[cartID] = make(map[*Subscription]struct{})
+	}
+	h.subs[cartID][sub] = struct{}{}
+	return sub
+}
+
+// Close stops delivery of events and closes C. It may be called more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.unsubscribe(s)
+}
+
+func (h *Hub) unsubscribe(sub *Subscription) {
+	subs, ok := h.subs[sub.cartID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subs, sub.cartID)
+	}
+	close(sub.ch)
+}
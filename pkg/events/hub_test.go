@@ -0,0 +1,94 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// received returns events queued for sub without waiting.
+func received(sub *Subscription) []Event {
+	var res []Event
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return res
+			}
+			res = append(res, e)
+		default:
+			return res
+		}
+	}
+}
+
+func types(events []Event) []string {
+	var res []string
+	for _, e := range events {
+		res = append(res, e.Type+" "+e.ItemID)
+	}
+	return res
+}
+
+func TestHub(t *testing.T) {
+	h := NewHub()
+	sub := h.Subscribe("cart_1")
+	other := h.Subscribe("cart_2")
+	defer other.Close()
+
+	h.Publish(Event{Type: ItemAdded, CartID: "cart_1", ItemID: "item_1"})
+	h.Publish(Event{Type: ItemAdded, CartID: "cart_2", ItemID: "item_2"})
+	h.Publish(Event{Type: ItemRemoved, CartID: "cart_1", ItemID: "item_1"})
+
+	events := received(sub)
+	assert.Equal(t, []string{"item_added item_1", "item_removed item_1"}, types(events), "Only events of the cart should be received")
+	require.Len(t, events, 2)
+	assert.Less(t, events[0].ID, events[1].ID, "IDs should grow")
+	assert.False(t, events[0].Time.IsZero(), "Time should be set")
+
+	sub.Close()
+	sub.Close()
+	h.Publish(Event{Type: ItemAdded, CartID: "cart_1", ItemID: "item_3"})
+	_, ok := <-sub.C
+	assert.False(t, ok, "Closed subscription should receive nothing")
+}
+
+func TestHub_SubscribeAfter(t *testing.T) {
+	h := NewHub(WithHistorySize(2))
+	sub := h.Subscribe("cart_1")
+	for _, item := range []string{"item_1", "item_2", "item_3"} {
+		h.Publish(Event{Type: ItemAdded, CartID: "cart_1", ItemID: item})
+	}
+	h.Publish(Event{Type: ItemAdded, CartID: "cart_2", ItemID: "item_4"})
+	events := received(sub)
+	sub.Close()
+
+	resumed, missed, ok := h.SubscribeAfter("cart_1", events[1].ID)
+	defer resumed.Close()
+	assert.True(t, ok)
+	assert.Equal(t, events[2].ID+1, resumed.After, "Subscription should start after the latest event of all carts")
+	assert.Equal(t, []string{"item_added item_3"}, types(missed), "Events after the last seen one should be replayed")
+
+	_, missed, ok = h.SubscribeAfter("cart_1", events[2].ID)
+	assert.True(t, ok)
+	assert.Empty(t, missed, "Nothing should be replayed to an up to date subscriber")
+
+	_, missed, ok = h.SubscribeAfter("cart_1", events[0].ID)
+	assert.False(t, ok, "Resume should fail when events are dropped from history")
+	assert.Empty(t, missed)
+
+	_, _, ok = h.SubscribeAfter("cart_1", 42)
+	assert.False(t, ok, "Resume should fail for IDs of another process")
+}
+
+func TestHub_slowSubscriber(t *testing.T) {
+	h := NewHub(WithBufferSize(1))
+	sub := h.Subscribe("cart_1")
+	h.Publish(Event{Type: ItemAdded, CartID: "cart_1", ItemID: "item_1"})
+	h.Publish(Event{Type: ItemAdded, CartID: "cart_1", ItemID: "item_2"})
+
+	assert.Equal(t, []string{"item_added item_1"}, types(received(sub)), "Overflowing subscription should be closed")
+	_, ok := <-sub.C
+	assert.False(t, ok)
+}
@@ -0,0 +1,80 @@
+//go:generate mockgen -source=inventory.go -destination=../mocks/inventory_mock.go -package=mocks
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/pkg/errors"
+)
+
+// ErrIncompatibleUnit is returned when a quantity cannot be converted to unit of the stock of its product.
+var ErrIncompatibleUnit = errors.New("unit is incompatible with unit of stock")
+
+// Reservation holds quantity of a product variant for a line of a cart. Empty unit counts pieces.
+type Reservation struct {
+	CartID    string
+	ItemID    string
+	Product   string
+	VariantID string
+	Quantity  float64
+	Unit      units.Unit
+}
+
+// InsufficientStockError is returned when there is not enough stock to reserve a requested quantity.
+// Quantities are measured in unit of the request. Available includes quantity already reserved for the line.
+type InsufficientStockError struct {
+	Product   string     `json:"product"`
+	VariantID string     `json:"variant_id,omitempty"`
+	Requested float64    `json:"requested"`
+	Available float64    `json:"available"`
+	Unit      units.Unit `json:"unit"`
+}
+
+func (e *InsufficientStockError) Error() string {
+	product := e.Product
+	if e.VariantID != "" {
+		product += " (" + e.VariantID + ")"
+	}
+	return fmt.Sprintf("insufficient stock of %s: %v %s requested, %v %s available",
+		product, e.Requested, e.Unit, e.Available, e.Unit)
+}
+
+// Stock is quantity of a product variant on hand. Reserved part of it is held for lines of carts,
+// the rest is available.
+type Stock struct {
+	Product   string     `json:"product"`
+	VariantID string     `json:"variant_id,omitempty"`
+	OnHand    float64    `json:"on_hand"`
+	Reserved  float64    `json:"reserved"`
+	Available float64    `json:"available"`
+	Unit      units.Unit `json:"unit"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Reserver holds stock for lines of carts. Products without stock are not tracked and always reserved.
+type Reserver interface {
+	// Reserve sets quantity reserved for a line of a cart to r.Quantity.
+	// It returns *InsufficientStockError if available stock is short and ErrIncompatibleUnit
+	// if r.Unit cannot be converted to unit of the stock.
+	Reserve(ctx context.Context, r Reservation) error
+	// Check returns the error Reserve would return for r without reserving anything.
+	Check(ctx context.Context, r Reservation) error
+	// Release returns quantity reserved for a line of a cart to stock.
+	Release(ctx context.Context, cartID, itemID string) error
+	// ReleaseCart returns quantities reserved for all lines of a cart to stock.
+	ReleaseCart(ctx context.Context, cartID string) error
+}
+
+// Ledger keeps stock of products and reservations of it.
+type Ledger interface {
+	Reserver
+	// SetStock sets quantity of a product variant on hand, starting to track it, and returns the stock.
+	// It returns ErrIncompatibleUnit if unit cannot be converted to unit of reservations already made.
+	SetStock(ctx context.Context, product, variantID string, onHand float64, unit units.Unit) (*Stock, error)
+	// Stock returns stock of a product variant and service.ErrNotFound if it is not tracked.
+	Stock(ctx context.Context, product, variantID string) (*Stock, error)
+}
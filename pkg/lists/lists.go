@@ -0,0 +1,51 @@
+//go:generate mockgen -source=lists.go -destination=../mocks/lists_mock.go -package=mocks
+package lists
+
+import (
+	"context"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+)
+
+// Names of lists known to storefronts. Customers may keep lists of other names too.
+const (
+	SavedForLater = "saved_for_later"
+	Wishlist      = "wishlist"
+)
+
+// Directions of moving a line between a cart and a list.
+const (
+	ToList = "list"
+	ToCart = "cart"
+)
+
+// List is a named list of items a customer keeps out of carts, e.g. saved for later.
+// Items have the shape of cart lines without a cart, so lines are moved between carts and lists as they are.
+// A list of a customer exists once an item is put in it.
+type List struct {
+	CustomerID string             `json:"customer_id"`
+	Name       string             `json:"name"`
+	Items      []service.CartItem `json:"items"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+}
+
+// Lists stores lists of customers. Items put in a list are merged into its line of the same variant
+// in a compatible unit, like items added to a cart.
+type Lists interface {
+	// CustomerLists returns all lists of a customer ordered by name.
+	CustomerLists(ctx context.Context, customerID string) ([]List, error)
+	// List returns a list of a customer with a specified name, empty if nothing was put in it.
+	List(ctx context.Context, customerID, name string) (*List, error)
+	// AddItemToList puts item to a list of a customer and returns the added or merged line.
+	AddItemToList(ctx context.Context, customerID, name string, item service.CartItem) (*service.CartItem, error)
+	// RemoveItemFromList removes a line with a specified ID from a list of a customer.
+	// It returns service.ErrNotFound if there is no such line.
+	RemoveItemFromList(ctx context.Context, customerID, name, itemID string) error
+	// MoveToList removes a line of a cart and puts it to a list of a customer atomically.
+	// It returns the line of the list and service.ErrNotFound if there is no such cart or line.
+	MoveToList(ctx context.Context, cartID, itemID, customerID, name string) (*service.CartItem, error)
+	// MoveToCart removes a line of a list of a customer and adds it to a cart atomically.
+	// It returns the line of the cart and service.ErrNotFound if there is no such cart or line.
+	MoveToCart(ctx context.Context, customerID, name, itemID, cartID string) (*service.CartItem, error)
+}
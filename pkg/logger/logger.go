@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Supported output formats.
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Field names shared by all packages, so logs of a single request or cart can be searched for.
+const (
+	RequestIDKey = "request_id"
+	TraceIDKey   = "trace_id"
+	CartIDKey    = "cart_id"
+	ItemIDKey    = "item_id"
+	ErrorKey     = "error"
+)
+
+type ctxKey struct{}
+
+// New creates logger writing to w in a specified format with messages of a specified level and above.
+// Level is one of debug, info, warn, error.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, errors.Wrapf(err, "could not parse log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatLogfmt:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, errors.Errorf("unknown log format %q", format)
+	}
+}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns logger stored in ctx by NewContext.
+// Func returns fallback if ctx does not carry a logger, and slog.Default() if fallback is nil as well.
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	if fallback != nil {
+		return fallback
+	}
+	return slog.Default()
+}
+
+// Err returns attribute for an error message under the shared key.
+// Message is used instead of the error value, so that handlers do not print stack traces of wrapped errors.
+func Err(err error) slog.Attr {
+	return slog.String(ErrorKey, err.Error())
+}
@@ -0,0 +1,79 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	tt := []struct {
+		name           string
+		format         string
+		level          string
+		isErrExpected  bool
+		expectedOutput string
+	}{
+		{
+			name:           "json",
+			format:         FormatJSON,
+			level:          "info",
+			expectedOutput: `"level":"INFO","msg":"visible","cart_id":"c1"`,
+		},
+		{
+			name:           "logfmt",
+			format:         FormatLogfmt,
+			level:          "INFO",
+			expectedOutput: `level=INFO msg=visible cart_id=c1`,
+		},
+		{
+			name:          "unknown format",
+			format:        "xml",
+			level:         "info",
+			isErrExpected: true,
+		},
+		{
+			name:          "unknown level",
+			format:        FormatJSON,
+			level:         "verbose",
+			isErrExpected: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := New(&buf, tc.format, tc.level)
+			if tc.isErrExpected {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			l.Debug("hidden", CartIDKey, "c1")
+			l.Info("visible", CartIDKey, "c1")
+			assert.NotContains(t, buf.String(), "hidden", "Debug messages should be filtered out")
+			assert.Contains(t, buf.String(), tc.expectedOutput)
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	var fallbackBuf, ctxBuf bytes.Buffer
+	fallback, err := New(&fallbackBuf, FormatJSON, "info")
+	require.NoError(t, err)
+	ctxLogger, err := New(&ctxBuf, FormatJSON, "info")
+	require.NoError(t, err)
+
+	FromContext(context.Background(), fallback).Info("fallback")
+	FromContext(NewContext(context.Background(), ctxLogger.With(RequestIDKey, "r1")), fallback).Info("from context")
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(ctxBuf.Bytes(), &line))
+	assert.Equal(t, "r1", line[RequestIDKey])
+	assert.Contains(t, fallbackBuf.String(), `"msg":"fallback"`)
+	assert.NotContains(t, fallbackBuf.String(), "from context")
+}
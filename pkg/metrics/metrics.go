@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cart_api"
+
+// Metrics holds prometheus collectors of the service.
+// All observe methods are safe to call on nil *Metrics, so instrumented code works without metrics configured.
+type Metrics struct {
+	registry     *prometheus.Registry
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	grpcRequests *prometheus.CounterVec
+	grpcDuration *prometheus.HistogramVec
+	dbDuration   *prometheus.HistogramVec
+	dbErrors     *prometheus.CounterVec
+	cartSize     prometheus.Histogram
+	lostAudit    prometheus.Counter
+}
+
+// New creates Metrics with own registry, that also exposes go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests by method and route template.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		grpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "requests_total",
+			Help:      "Number of gRPC requests by method and status code.",
+		}, []string{"method", "code"}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of gRPC requests by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "db",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of database operations by method.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"method"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "db",
+			Name:      "operation_errors_total",
+			Help:      "Number of failed database operations by method.",
+		}, []string{"method"}),
+		cartSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "cart_items",
+			Help:      "Number of item lines in carts read from the database.",
+			Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100},
+		}),
+		lostAudit: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "audit",
+			Name:      "lost_changes_total",
+			Help:      "Number of changes of carts made without their audit entries.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.grpcRequests,
+		m.grpcDuration,
+		m.dbDuration,
+		m.dbErrors,
+		m.cartSize,
+		m.lostAudit,
+	)
+
+	return m
+}
+
+// Handler returns http handler exposing collected metrics in prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveHTTPRequest records a served HTTP request.
+func (m *Metrics) ObserveHTTPRequest(method, route string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.httpDuration.WithLabelValues(method, route).Observe(d.Seconds())
+}
+
+// ObserveGRPCRequest records a served gRPC request with full method name and status code name, e.g. NotFound.
+func (m *Metrics) ObserveGRPCRequest(method, code string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.grpcRequests.WithLabelValues(method, code).Inc()
+	m.grpcDuration.WithLabelValues(method).Observe(d.Seconds())
+}
+
+// ObserveDBOperation records a database operation. Operation is counted as failed if err is not nil.
+func (m *Metrics) ObserveDBOperation(method string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.dbDuration.WithLabelValues(method).Observe(d.Seconds())
+	if err != nil {
+		m.dbErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// ObserveCartSize records number of item lines in a cart.
+func (m *Metrics) ObserveCartSize(items int) {
+	if m == nil {
+		return
+	}
+	m.cartSize.Observe(float64(items))
+}
+
+// ObserveLostAudit records a change of a cart made without its audit entries.
+func (m *Metrics) ObserveLostAudit() {
+	if m == nil {
+		return
+	}
+	m.lostAudit.Inc()
+}
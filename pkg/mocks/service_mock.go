@@ -61,16 +61,28 @@ func (_mr *MockServiceMockRecorder) Cart(arg0, arg1 interface{}) *gomock.Call {
 }
 
 // AddItemToCart mocks base method
-func (_m *MockService) AddItemToCart(ctx context.Context, cartID string, productName string, quantity float64) (*service.CartItem, error) {
-	ret := _m.ctrl.Call(_m, "AddItemToCart", ctx, cartID, productName, quantity)
+func (_m *MockService) AddItemToCart(ctx context.Context, cartID string, item service.CartItem) (*service.CartItem, error) {
+	ret := _m.ctrl.Call(_m, "AddItemToCart", ctx, cartID, item)
 	ret0, _ := ret[0].(*service.CartItem)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // AddItemToCart indicates an expected call of AddItemToCart
-func (_mr *MockServiceMockRecorder) AddItemToCart(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "AddItemToCart", reflect.TypeOf((*MockService)(nil).AddItemToCart), arg0, arg1, arg2, arg3)
+func (_mr *MockServiceMockRecorder) AddItemToCart(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "AddItemToCart", reflect.TypeOf((*MockService)(nil).AddItemToCart), arg0, arg1, arg2)
+}
+
+// DeleteCart mocks base method
+func (_m *MockService) DeleteCart(ctx context.Context, id string) error {
+	ret := _m.ctrl.Call(_m, "DeleteCart", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCart indicates an expected call of DeleteCart
+func (_mr *MockServiceMockRecorder) DeleteCart(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteCart", reflect.TypeOf((*MockService)(nil).DeleteCart), arg0, arg1)
 }
 
 // RemoveItemFromCart mocks base method
@@ -84,3 +96,16 @@ func (_m *MockService) RemoveItemFromCart(ctx context.Context, cartID string, ca
 func (_mr *MockServiceMockRecorder) RemoveItemFromCart(arg0, arg1, arg2 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "RemoveItemFromCart", reflect.TypeOf((*MockService)(nil).RemoveItemFromCart), arg0, arg1, arg2)
 }
+
+// ApplyItemOperations mocks base method
+func (_m *MockService) ApplyItemOperations(ctx context.Context, cartID string, version int64, ops []service.ItemOperation) ([]service.ItemOperationResult, error) {
+	ret := _m.ctrl.Call(_m, "ApplyItemOperations", ctx, cartID, version, ops)
+	ret0, _ := ret[0].([]service.ItemOperationResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ApplyItemOperations indicates an expected call of ApplyItemOperations
+func (_mr *MockServiceMockRecorder) ApplyItemOperations(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ApplyItemOperations", reflect.TypeOf((*MockService)(nil).ApplyItemOperations), arg0, arg1, arg2, arg3)
+}
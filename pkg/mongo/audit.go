@@ -0,0 +1,116 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/audit"
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const auditCollectionName = "cart_audit"
+
+// auditDocument is an entry of cart_audit collection. Entries are only inserted, never changed or deleted.
+type auditDocument struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	ChangeID  string             `bson:"change_id,omitempty"`
+	Undoes    string             `bson:"undoes,omitempty"`
+	CartID    string             `bson:"cart_id"`
+	Action    string             `bson:"action"`
+	ItemID    string             `bson:"item_id,omitempty"`
+	Before    *service.CartItem  `bson:"before,omitempty"`
+	After     *service.CartItem  `bson:"after,omitempty"`
+	Actor     string             `bson:"actor"`
+	RequestID string             `bson:"request_id,omitempty"`
+	Time      time.Time          `bson:"time"`
+}
+
+func (doc auditDocument) entry() audit.Entry {
+	return audit.Entry{
+		ID:        doc.ID.Hex(),
+		ChangeID:  doc.ChangeID,
+		Undoes:    doc.Undoes,
+		CartID:    doc.CartID,
+		Action:    doc.Action,
+		ItemID:    doc.ItemID,
+		Before:    doc.Before,
+		After:     doc.After,
+		Actor:     doc.Actor,
+		RequestID: doc.RequestID,
+		Time:      doc.Time,
+	}
+}
+
+// createAuditIndex creates index listing entries of a cart in order.
+func (db *DB) createAuditIndex(ctx context.Context) error {
+	_, err := db.Audit.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "cart_id", Value: 1}, {Key: "_id", Value: -1}},
+	})
+	return errors.Wrap(err, "could not create audit index")
+}
+
+// insertAudit records evs as a single change made by actor of ctx, which undoes a change with ID undoes
+// unless it is empty.
+func (db *DB) insertAudit(ctx context.Context, evs []events.Event, undoes string) error {
+	if len(evs) == 0 {
+		return nil
+	}
+	actor := audit.FromContext(ctx)
+	now := time.Now().UTC()
+	changeID := primitive.NewObjectID().Hex()
+	docs := make([]interface{}, 0, len(evs))
+	for _, e := range evs {
+		docs = append(docs, auditDocument{
+			ID:        primitive.NewObjectID(),
+			ChangeID:  changeID,
+			Undoes:    undoes,
+			CartID:    e.CartID,
+			Action:    e.Type,
+			ItemID:    e.ItemID,
+			Before:    e.Previous,
+			After:     e.Item,
+			Actor:     actor.Name,
+			RequestID: actor.RequestID,
+			Time:      now,
+		})
+	}
+	_, err := db.Audit.InsertMany(ctx, docs)
+	return errors.Wrap(err, "could not write audit log")
+}
+
+// CartHistory returns up to limit audit entries of a cart with a specified ID, latest first.
+// Unless before is empty, only entries recorded before the entry with that ID are returned.
+// History of deleted carts is kept, so no error is returned for carts that do not exist.
+func (db *DB) CartHistory(ctx context.Context, cartID, before string, limit int) (_ []audit.Entry, err error) {
+	ctx, finish := db.startOp(ctx, "CartHistory", auditCollectionName, cartID)
+	defer finish(&err)
+	filter := bson.M{"cart_id": cartID}
+	if before != "" {
+		beforeObjID, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not convert %s to ObjectID", before)
+		}
+		filter["_id"] = bson.M{"$lt": beforeObjID}
+	}
+	cur, err := db.Audit.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find audit entries")
+	}
+	defer cur.Close(ctx)
+	entries := []audit.Entry{}
+	for cur.Next(ctx) {
+		var doc auditDocument
+		if err := cur.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "could not decode audit entry")
+		}
+		entries = append(entries, doc.entry())
+	}
+	return entries, errors.Wrap(cur.Err(), "could not read audit entries")
+}
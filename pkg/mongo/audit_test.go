@@ -0,0 +1,64 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/audit"
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCartHistory(t *testing.T) {
+	connTest, err := Connect(context.Background(), dbTestConnString, dbTestName)
+	require.NoError(t, err, "could not create db instance")
+	defer func() {
+		assert.NoError(t, cleanUpCollection(connTest, cartsCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, auditCollectionName))
+	}()
+	ctx := audit.NewContext(context.Background(), audit.Actor{Name: "support-tool", RequestID: "req-1"})
+
+	cart, err := connTest.AddCart(ctx)
+	require.NoError(t, err)
+	cartID := cart.ID.Hex()
+	added, err := connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "milk", Quantity: 1})
+	require.NoError(t, err)
+	merged, err := connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "milk", Quantity: 2})
+	require.NoError(t, err)
+	_, err = connTest.ApplyItemOperations(ctx, cartID, service.AnyVersion, []service.ItemOperation{
+		{Op: service.OpUpdate, ItemID: added.ID.Hex(), Item: service.CartItem{Quantity: 5, Unit: units.Piece}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, connTest.RemoveItemFromCart(context.Background(), cartID, added.ID.Hex()))
+
+	entries, err := connTest.CartHistory(context.Background(), cartID, "", 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{events.ItemRemoved, events.ItemUpdated, events.ItemUpdated, events.ItemAdded, events.CartCreated}, actions,
+		"Entries should be listed latest first")
+
+	removed := entries[0]
+	assert.Equal(t, audit.System, removed.Actor, "Changes without actor should be made by system")
+	require.NotNil(t, removed.Before)
+	assert.Equal(t, float64(5), removed.Before.Quantity)
+	assert.Nil(t, removed.After)
+
+	mergedEntry := entries[2]
+	assert.Equal(t, "support-tool", mergedEntry.Actor)
+	assert.Equal(t, "req-1", mergedEntry.RequestID)
+	require.NotNil(t, mergedEntry.Before)
+	assert.Equal(t, float64(1), mergedEntry.Before.Quantity)
+	assert.Equal(t, merged, mergedEntry.After)
+
+	older, err := connTest.CartHistory(context.Background(), cartID, entries[2].ID, 10)
+	require.NoError(t, err)
+	assert.Equal(t, entries[3:], older)
+}
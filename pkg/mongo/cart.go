@@ -2,38 +2,57 @@ package mongo
 
 import (
 	"context"
+	"log/slog"
+	"time"
 
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
 	"github.com/HarlamovBuldog/cart_api/pkg/service"
 
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // AddCart inserts cart to collection with primitiveObjectID generated by mongo.
-func (db *DB) AddCart(ctx context.Context) (*service.Cart, error) {
-	insertResult, err := db.Carts.InsertOne(ctx,
-		service.Cart{
-			Items: []service.CartItem{},
+func (db *DB) AddCart(ctx context.Context) (_ *service.Cart, err error) {
+	ctx, finish := db.startOp(ctx, "AddCart", cartsCollectionName, "")
+	defer finish(&err)
+	var cart *service.Cart
+	err = db.write(ctx, func(ctx context.Context) ([]events.Event, error) {
+		insertResult, err := db.Carts.InsertOne(ctx, bson.M{
+			"items":      bson.A{},
+			"version":    0,
+			"updated_at": time.Now().UTC(),
 		})
+		if err != nil {
+			return nil, errors.Wrap(err, "could not insert cart")
+		}
+		insertedID, ok := insertResult.InsertedID.(primitive.ObjectID)
+		if !ok {
+			return nil, errors.New("could not convert to primitive.ObjectID")
+		}
+		cart = &service.Cart{
+			ID:    insertedID,
+			Items: []service.CartItem{},
+		}
+		return []events.Event{{Type: events.CartCreated, CartID: insertedID.Hex()}}, nil
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "could not insert cart")
-	}
-	insertedID, ok := insertResult.InsertedID.(primitive.ObjectID)
-	if !ok {
-		return nil, errors.New("could not convert to primitive.ObjectID")
+		return nil, err
 	}
 
-	return &service.Cart{
-		ID:    insertedID,
-		Items: []service.CartItem{},
-	}, nil
+	db.log(ctx, cart.ID.Hex()).Info("cart created")
+
+	return cart, nil
 }
 
 // Cart returns cart with a specified id.
 // Func returns ErrNotFound if no carts were found.
-func (db *DB) Cart(ctx context.Context, id string) (*service.Cart, error) {
+func (db *DB) Cart(ctx context.Context, id string) (_ *service.Cart, err error) {
+	ctx, finish := db.startOp(ctx, "Cart", cartsCollectionName, id)
+	defer finish(&err)
 	cartID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not convert %s to ObjectID", id)
@@ -43,10 +62,100 @@ func (db *DB) Cart(ctx context.Context, id string) (*service.Cart, error) {
 	err = db.Carts.FindOne(ctx, bson.M{"_id": cartID}).Decode(&cart)
 	switch {
 	case err == mongo.ErrNoDocuments:
+		db.log(ctx, id).Debug("cart not found")
 		return nil, errors.Wrap(ErrNotFound, "no carts")
 	case err != nil:
 		return nil, errors.Wrap(err, "could not decode document")
 	default:
+		db.log(ctx, id).Debug("cart read", slog.Int("items", len(cart.Items)))
+		db.metrics.ObserveCartSize(len(cart.Items))
 		return &cart, nil
 	}
 }
+
+// DeleteCart deletes cart with a specified id.
+// Func returns ErrNotFound if no carts were found.
+func (db *DB) DeleteCart(ctx context.Context, id string) (err error) {
+	ctx, finish := db.startOp(ctx, "DeleteCart", cartsCollectionName, id)
+	defer finish(&err)
+	cartID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.Wrapf(err, "could not convert %s to ObjectID", id)
+	}
+
+	err = db.write(ctx, func(ctx context.Context) ([]events.Event, error) {
+		deleteResult, err := db.Carts.DeleteOne(ctx, bson.M{"_id": cartID})
+		switch {
+		case err != nil:
+			return nil, errors.Wrap(err, "could not delete cart")
+		case deleteResult.DeletedCount == 0:
+			return nil, errors.Wrap(ErrNotFound, "no carts")
+		default:
+			return []events.Event{{Type: events.CartDeleted, CartID: id}}, nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	db.log(ctx, id).Info("cart deleted")
+	return nil
+}
+
+// touch adds to update operators marking a cart changed now, so it is not abandoned.
+func touch(update bson.M) bson.M {
+	update["$currentDate"] = bson.M{"updated_at": true}
+	update["$unset"] = bson.M{"abandoned": ""}
+	return update
+}
+
+// MarkAbandonedCarts writes cart_abandoned event of every cart with items not changed since idleSince.
+// A cart is marked once, until it is changed again. Func returns number of marked carts.
+func (db *DB) MarkAbandonedCarts(ctx context.Context, idleSince time.Time) (_ int, err error) {
+	ctx, finish := db.startOp(ctx, "MarkAbandonedCarts", cartsCollectionName, "")
+	defer finish(&err)
+	cur, err := db.Carts.Find(ctx,
+		bson.M{
+			"updated_at": bson.M{"$lt": idleSince.UTC()},
+			"items.0":    bson.M{"$exists": true},
+			"abandoned":  bson.M{"$ne": true},
+		},
+		options.Find().SetProjection(bson.M{"_id": 1, "updated_at": 1}))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not find abandoned carts")
+	}
+	defer cur.Close(ctx)
+
+	marked := 0
+	for cur.Next(ctx) {
+		var doc struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			UpdatedAt time.Time          `bson:"updated_at"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return marked, errors.Wrap(err, "could not decode document")
+		}
+		var ok bool
+		err = db.write(ctx, func(ctx context.Context) ([]events.Event, error) {
+			// cart may be changed after it was found
+			updateResult, err := db.Carts.UpdateOne(ctx,
+				bson.M{"_id": doc.ID, "updated_at": doc.UpdatedAt, "abandoned": bson.M{"$ne": true}},
+				bson.M{"$set": bson.M{"abandoned": true}})
+			if err != nil {
+				return nil, errors.Wrap(err, "could not mark cart abandoned")
+			}
+			ok = updateResult.ModifiedCount > 0
+			if !ok {
+				return nil, nil
+			}
+			return []events.Event{{Type: events.CartAbandoned, CartID: doc.ID.Hex()}}, nil
+		})
+		if err != nil {
+			return marked, err
+		}
+		if ok {
+			db.log(ctx, doc.ID.Hex()).Info("cart abandoned", slog.Time("updated_at", doc.UpdatedAt))
+			marked++
+		}
+	}
+	return marked, errors.Wrap(cur.Err(), "could not read carts")
+}
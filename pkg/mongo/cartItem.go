@@ -2,56 +2,192 @@ package mongo
 
 import (
 	"context"
+	"log/slog"
 
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
 	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
 
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// maxMergeAttempts limits retries of AddItemToCart when the cart is modified concurrently.
+const maxMergeAttempts = 3
+
 // AddItemToCart adds item to item list of a cart with a specified ID.
+// Quantity is added to an existing line of the same product in a compatible unit, converted to unit of the line.
 // Func returns ErrNotFound if no cart was found.
-func (db *DB) AddItemToCart(ctx context.Context, cartID, productName string, quantity float64) (*service.CartItem, error) {
+func (db *DB) AddItemToCart(ctx context.Context, cartID string, item service.CartItem) (_ *service.CartItem, err error) {
+	ctx, finish := db.startOp(ctx, "AddItemToCart", cartsCollectionName, cartID)
+	defer finish(&err)
 	cartObjID, err := primitive.ObjectIDFromHex(cartID)
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not convert %s to ObjectID", cartID)
 	}
-	cartItemID := primitive.NewObjectID()
+	item = normalizeItem(item, cartObjID)
+
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		var (
+			added  *service.CartItem
+			merged bool
+		)
+		err = db.write(ctx, func(ctx context.Context) ([]events.Event, error) {
+			var cart service.Cart
+			err := db.Carts.FindOne(ctx, bson.M{"_id": cartObjID}).Decode(&cart)
+			switch {
+			case err == mongo.ErrNoDocuments:
+				return nil, errors.Wrap(ErrNotFound, "no carts")
+			case err != nil:
+				return nil, errors.Wrap(err, "could not decode document")
+			}
+
+			i := mergeableIndex(cart.Items, item)
+			merged = i >= 0
+			if merged {
+				line := cart.Items[i]
+				if line.Unit == "" {
+					line.Unit = units.Piece
+				}
+				added, err = db.mergeItem(ctx, line, item)
+			} else {
+				added, err = db.pushItem(ctx, item)
+			}
+			if err != nil || added == nil {
+				return nil, err
+			}
+			e := events.Event{Type: events.ItemAdded, CartID: cartID, ItemID: added.ID.Hex(), Item: added}
+			if merged {
+				e.Type = events.ItemUpdated
+				e.Previous = &cart.Items[i]
+			}
+			return []events.Event{e}, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if added == nil {
+			// cart was modified between read and update
+			continue
+		}
+		db.log(ctx, cartID).Info("item added to cart",
+			slog.String(logger.ItemIDKey, added.ID.Hex()),
+			slog.String("product", added.ProductName),
+			slog.Float64("quantity", added.Quantity),
+			slog.String("unit", string(added.Unit)),
+			slog.Bool("merged", merged))
+		return added, nil
+	}
+	return nil, errors.New("could not add item: cart is modified concurrently")
+}
+
+// normalizeItem returns item of a cart with a specified ID with default unit and no empty attributes.
+func normalizeItem(item service.CartItem, cartID primitive.ObjectID) service.CartItem {
+	item.CartID = cartID
+	if item.Unit == "" {
+		item.Unit = units.Piece
+	}
+	if len(item.Attributes) == 0 {
+		item.Attributes = nil
+	}
+	return item
+}
+
+// mergeableIndex returns index of the first line of items of the same variant as item in unit compatible
+// with unit of item or -1 if there is none.
+func mergeableIndex(items []service.CartItem, item service.CartItem) int {
+	for i, line := range items {
+		if line.Unit == "" {
+			line.Unit = units.Piece
+		}
+		if line.SameVariant(item) && units.Compatible(line.Unit, item.Unit) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeItem adds quantity of item to line, unless line is changed or removed, in which case nil is returned.
+func (db *DB) mergeItem(ctx context.Context, line, item service.CartItem) (*service.CartItem, error) {
+	q, err := units.Convert(item.Quantity, item.Unit, line.Unit)
+	if err != nil {
+		return nil, err
+	}
+	oldQuantity := line.Quantity
+	line.Quantity = units.Round(line.Quantity+q, units.MaxPrecision)
 	updateResult, err := db.Carts.UpdateOne(
 		ctx,
-		bson.M{"_id": cartObjID},
 		bson.D{
-			bson.E{Key: "$addToSet", Value: bson.D{
-				bson.E{Key: "items", Value: bson.M{
-					"id":       cartItemID,
-					"cart_id":  cartObjID,
-					"product":  productName,
-					"quantity": quantity,
-				}},
+			bson.E{Key: "_id", Value: line.CartID},
+			bson.E{Key: "items", Value: bson.M{
+				"$elemMatch": bson.M{"id": line.ID, "quantity": oldQuantity},
 			}},
-		})
+		},
+		touch(bson.M{
+			"$set": bson.M{"items.$.quantity": line.Quantity, "items.$.unit": line.Unit},
+			"$inc": bson.M{"version": 1},
+		}))
+	switch {
+	case err != nil:
+		return nil, errors.Wrap(err, "could not merge item into cart")
+	case updateResult.MatchedCount == 0:
+		return nil, nil
+	default:
+		return &line, nil
+	}
+}
+
+// optional returns condition matching value of a field omitted from documents when empty.
+func optional(value interface{}, empty bool) interface{} {
+	if empty {
+		return bson.M{"$exists": false}
+	}
+	return value
+}
+
+// pushItem appends item as a new line, unless a line it could be merged into appeared, in which case nil is returned.
+func (db *DB) pushItem(ctx context.Context, item service.CartItem) (*service.CartItem, error) {
+	compatible := bson.A{}
+	for _, u := range units.CompatibleWith(item.Unit) {
+		compatible = append(compatible, u)
+	}
+	if item.Unit == units.Piece {
+		// lines stored before units were introduced have no unit
+		compatible = append(compatible, nil)
+	}
+	sameVariant := bson.M{
+		"product":    item.ProductName,
+		"unit":       bson.M{"$in": compatible},
+		"variant_id": optional(item.VariantID, item.VariantID == ""),
+		"attributes": optional(item.Attributes, len(item.Attributes) == 0),
+	}
+	item.ID = primitive.NewObjectID()
+	updateResult, err := db.Carts.UpdateOne(
+		ctx,
+		bson.D{
+			bson.E{Key: "_id", Value: item.CartID},
+			bson.E{Key: "items", Value: bson.M{"$not": bson.M{"$elemMatch": sameVariant}}},
+		},
+		touch(bson.M{"$push": bson.M{"items": item}, "$inc": bson.M{"version": 1}}))
 	switch {
 	case err != nil:
 		return nil, errors.Wrap(err, "could not add item to cart")
 	case updateResult.MatchedCount == 0:
-		return nil, errors.Wrap(ErrNotFound, "no carts")
-	case updateResult.ModifiedCount == 0:
-		return nil, errors.New("could not add item")
+		return nil, nil
 	default:
-		return &service.CartItem{
-			ID:          cartItemID,
-			CartID:      cartObjID,
-			ProductName: productName,
-			Quantity:    quantity,
-		}, nil
+		return &item, nil
 	}
 }
 
 // RemoveItemFromCart removes an item with a specified ID from a cart with a specified ID.
 // Func returns ErrNotFound if no cart was found or item.
-func (db *DB) RemoveItemFromCart(ctx context.Context, cartID, cartItemID string) error {
+func (db *DB) RemoveItemFromCart(ctx context.Context, cartID, cartItemID string) (err error) {
+	ctx, finish := db.startOp(ctx, "RemoveItemFromCart", cartsCollectionName, cartID)
+	defer finish(&err)
 	cartObjID, err := primitive.ObjectIDFromHex(cartID)
 	if err != nil {
 		return errors.Wrapf(err, "could not convert %s to ObjectID", cartID)
@@ -61,25 +197,39 @@ func (db *DB) RemoveItemFromCart(ctx context.Context, cartID, cartItemID string)
 		return errors.Wrapf(err, "could not convert %s to ObjectID", cartItemID)
 	}
 
-	updateResult, err := db.Carts.UpdateOne(
-		ctx,
-		bson.M{"_id": cartObjID},
-		bson.M{"$pull": bson.M{"items": bson.M{"id": cartItemObjID}}})
-	switch {
-	case err != nil:
-		return errors.Wrap(err, "could not delete item from cart")
-	case updateResult.MatchedCount == 0:
-		return errors.Wrap(ErrNotFound, "no carts")
-	case updateResult.ModifiedCount == 0:
-		return errors.Wrap(ErrNotFound, "no items")
-	default:
-		return nil
+	err = db.write(ctx, func(ctx context.Context) ([]events.Event, error) {
+		// cart before the update holds only the removed line
+		var cart service.Cart
+		err := db.Carts.FindOneAndUpdate(
+			ctx,
+			bson.M{"_id": cartObjID, "items.id": cartItemObjID},
+			touch(bson.M{"$pull": bson.M{"items": bson.M{"id": cartItemObjID}}, "$inc": bson.M{"version": 1}}),
+			options.FindOneAndUpdate().SetProjection(bson.M{"items": bson.M{"$elemMatch": bson.M{"id": cartItemObjID}}}),
+		).Decode(&cart)
+		switch {
+		case err == mongo.ErrNoDocuments:
+			return nil, errors.Wrap(ErrNotFound, "no carts or items")
+		case err != nil:
+			return nil, errors.Wrap(err, "could not delete item from cart")
+		}
+		e := events.Event{Type: events.ItemRemoved, CartID: cartID, ItemID: cartItemID}
+		if len(cart.Items) > 0 {
+			e.Previous = &cart.Items[0]
+		}
+		return []events.Event{e}, nil
+	})
+	if err != nil {
+		return err
 	}
+	db.log(ctx, cartID).Info("item removed from cart", slog.String(logger.ItemIDKey, cartItemID))
+	return nil
 }
 
 // ItemFromCart get an item with a specified ID from a cart with a specified ID.
 // Func returns ErrNotFound if no cart was found or item.
-func (db *DB) ItemFromCart(ctx context.Context, cartID, cartItemID string) (*service.CartItem, error) {
+func (db *DB) ItemFromCart(ctx context.Context, cartID, cartItemID string) (_ *service.CartItem, err error) {
+	ctx, finish := db.startOp(ctx, "ItemFromCart", cartsCollectionName, cartID)
+	defer finish(&err)
 	cartObjID, err := primitive.ObjectIDFromHex(cartID)
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not convert %s to ObjectID", cartID)
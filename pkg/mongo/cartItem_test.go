@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -12,20 +13,20 @@ import (
 
 func TestAddItemToCart(t *testing.T) {
 	cartObjIDSet := generatePrimObjIDSet(3)
+	cartItemObjIDSet := generatePrimObjIDSet(1)
 	tt := []struct {
 		name                string
 		initColParams       initCollectionParams
 		cartID              string
-		productName         string
-		quantity            float64
+		item                service.CartItem
+		expectedItem        *service.CartItem
 		expectedErr         error
 		isCustomErrExpected bool
 	}{
 		{
-			name:        "correct test",
-			cartID:      cartObjIDSet[0].Hex(),
-			productName: "product_1",
-			quantity:    10.0,
+			name:   "correct test",
+			cartID: cartObjIDSet[0].Hex(),
+			item:   service.CartItem{ProductName: "product_1", Quantity: 10.0, Unit: units.Piece},
 			initColParams: initCollectionParams{
 				CollectionName: cartsCollectionName,
 				Documents: []interface{}{
@@ -43,6 +44,130 @@ func TestAddItemToCart(t *testing.T) {
 			isCustomErrExpected: false,
 			expectedErr:         nil,
 		},
+		{
+			name:   "correct test: merged into line in compatible unit",
+			cartID: cartObjIDSet[0].Hex(),
+			item:   service.CartItem{ProductName: "product_1", Quantity: 500, Unit: units.Gram},
+			initColParams: initCollectionParams{
+				CollectionName: cartsCollectionName,
+				Documents: []interface{}{
+					service.Cart{
+						ID: cartObjIDSet[0],
+						Items: []service.CartItem{
+							{
+								ID:          cartItemObjIDSet[0],
+								CartID:      cartObjIDSet[0],
+								ProductName: "product_1",
+								Quantity:    1.2,
+								Unit:        units.Kilogram,
+							},
+						},
+					},
+				},
+				Opts: nil,
+			},
+			expectedItem: &service.CartItem{
+				ID:          cartItemObjIDSet[0],
+				CartID:      cartObjIDSet[0],
+				ProductName: "product_1",
+				Quantity:    1.7,
+				Unit:        units.Kilogram,
+			},
+		},
+		{
+			name:   "correct test: line in other dimension is not merged",
+			cartID: cartObjIDSet[0].Hex(),
+			item:   service.CartItem{ProductName: "product_1", Quantity: 2, Unit: units.Piece},
+			initColParams: initCollectionParams{
+				CollectionName: cartsCollectionName,
+				Documents: []interface{}{
+					service.Cart{
+						ID: cartObjIDSet[0],
+						Items: []service.CartItem{
+							{
+								ID:          cartItemObjIDSet[0],
+								CartID:      cartObjIDSet[0],
+								ProductName: "product_1",
+								Quantity:    1.2,
+								Unit:        units.Kilogram,
+							},
+						},
+					},
+				},
+				Opts: nil,
+			},
+		},
+		{
+			name:   "correct test: line with other attributes is not merged",
+			cartID: cartObjIDSet[0].Hex(),
+			item: service.CartItem{
+				ProductName: "product_1",
+				Quantity:    1,
+				Unit:        units.Piece,
+				VariantID:   "sku-1",
+				Attributes:  service.Attributes{"color": "red", "gift_wrap": true},
+			},
+			initColParams: initCollectionParams{
+				CollectionName: cartsCollectionName,
+				Documents: []interface{}{
+					service.Cart{
+						ID: cartObjIDSet[0],
+						Items: []service.CartItem{
+							{
+								ID:          cartItemObjIDSet[0],
+								CartID:      cartObjIDSet[0],
+								ProductName: "product_1",
+								Quantity:    1,
+								Unit:        units.Piece,
+								VariantID:   "sku-1",
+								Attributes:  service.Attributes{"color": "red"},
+							},
+						},
+					},
+				},
+				Opts: nil,
+			},
+		},
+		{
+			name:   "correct test: merged into line with equal attributes",
+			cartID: cartObjIDSet[0].Hex(),
+			item: service.CartItem{
+				ProductName: "product_1",
+				Quantity:    2,
+				Unit:        units.Piece,
+				VariantID:   "sku-1",
+				Attributes:  service.Attributes{"gift_wrap": true, "color": "red"},
+			},
+			initColParams: initCollectionParams{
+				CollectionName: cartsCollectionName,
+				Documents: []interface{}{
+					service.Cart{
+						ID: cartObjIDSet[0],
+						Items: []service.CartItem{
+							{
+								ID:          cartItemObjIDSet[0],
+								CartID:      cartObjIDSet[0],
+								ProductName: "product_1",
+								Quantity:    1,
+								Unit:        units.Piece,
+								VariantID:   "sku-1",
+								Attributes:  service.Attributes{"color": "red", "gift_wrap": true},
+							},
+						},
+					},
+				},
+				Opts: nil,
+			},
+			expectedItem: &service.CartItem{
+				ID:          cartItemObjIDSet[0],
+				CartID:      cartObjIDSet[0],
+				ProductName: "product_1",
+				Quantity:    3,
+				Unit:        units.Piece,
+				VariantID:   "sku-1",
+				Attributes:  service.Attributes{"color": "red", "gift_wrap": true},
+			},
+		},
 		{
 			name:   "incorrect test: bad cartID provided",
 			cartID: "bad_id",
@@ -99,12 +224,15 @@ func TestAddItemToCart(t *testing.T) {
 			err = initCollection(connTest, tc.initColParams)
 			require.NoError(t, err, "initCollection")
 
-			expectedCartItem, err := connTest.AddItemToCart(context.Background(), tc.cartID, tc.productName, tc.quantity)
+			expectedCartItem, err := connTest.AddItemToCart(context.Background(), tc.cartID, tc.item)
 			switch {
 			case tc.isCustomErrExpected && tc.expectedErr != nil && err != nil:
 				assert.Contains(t, err.Error(), tc.expectedErr.Error(), "Actual error should contain text from expected error")
 			default:
 				assert.Equal(t, tc.expectedErr, errors.Cause(err), "Two errors should be the same")
+				if tc.expectedItem != nil {
+					assert.Equal(t, tc.expectedItem, expectedCartItem, "Two objects should be the same")
+				}
 				if expectedCartItem != nil {
 					actualCartItem, cartErr := connTest.ItemFromCart(context.Background(), tc.cartID, expectedCartItem.ID.Hex())
 					assert.Equal(t, expectedCartItem, actualCartItem, "Two objects should be the same")
@@ -182,6 +310,7 @@ func TestRemoveItemFromCart(t *testing.T) {
 						Quantity:    20.0,
 					},
 				},
+				Version: 1,
 			},
 			isCustomRemoveItemErrExpected: false,
 			expectedGetCartErr:            nil,
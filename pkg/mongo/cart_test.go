@@ -160,3 +160,111 @@ func TestCart(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteCart(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(3)
+	tt := []struct {
+		name                string
+		initColParams       initCollectionParams
+		id                  string
+		expectedCarts       []service.Cart
+		isCustomErrExpected bool
+		expectedErr         error
+	}{
+		{
+			name: "correct test",
+			id:   cartObjIDSet[0].Hex(),
+			initColParams: initCollectionParams{
+				CollectionName: cartsCollectionName,
+				Documents: []interface{}{
+					service.Cart{
+						ID:    cartObjIDSet[0],
+						Items: []service.CartItem{},
+					},
+					service.Cart{
+						ID:    cartObjIDSet[1],
+						Items: []service.CartItem{},
+					},
+				},
+				Opts: nil,
+			},
+			expectedCarts: []service.Cart{
+				{
+					ID:    cartObjIDSet[1],
+					Items: []service.CartItem{},
+				},
+			},
+			isCustomErrExpected: false,
+			expectedErr:         nil,
+		},
+		{
+			name: "incorrect test: ErrNotFound",
+			id:   cartObjIDSet[2].Hex(),
+			initColParams: initCollectionParams{
+				CollectionName: cartsCollectionName,
+				Documents: []interface{}{
+					service.Cart{
+						ID:    cartObjIDSet[0],
+						Items: []service.CartItem{},
+					},
+				},
+				Opts: nil,
+			},
+			expectedCarts: []service.Cart{
+				{
+					ID:    cartObjIDSet[0],
+					Items: []service.CartItem{},
+				},
+			},
+			isCustomErrExpected: false,
+			expectedErr:         ErrNotFound,
+		},
+		{
+			name: "incorrect test: bad id provided",
+			id:   "bad_id",
+			initColParams: initCollectionParams{
+				CollectionName: cartsCollectionName,
+				Documents: []interface{}{
+					service.Cart{
+						ID:    cartObjIDSet[0],
+						Items: []service.CartItem{},
+					},
+				},
+				Opts: nil,
+			},
+			isCustomErrExpected: true,
+			expectedErr:         errors.New("could not convert"),
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			connTest, err := Connect(context.Background(), dbTestConnString, dbTestName)
+			require.NoError(t, err, "could not create db instance")
+
+			defer func() {
+				err = cleanUpCollection(connTest, tc.initColParams.CollectionName)
+				assert.NoError(t, err, "cleanUpCollection")
+			}()
+
+			err = initCollection(connTest, tc.initColParams)
+			require.NoError(t, err, "initCollection")
+
+			actualErr := connTest.DeleteCart(context.Background(), tc.id)
+			switch {
+			case tc.isCustomErrExpected && tc.expectedErr != nil && actualErr != nil:
+				assert.Contains(t, actualErr.Error(), tc.expectedErr.Error(), "Actual error should contain text from expected error")
+				return
+			default:
+				assert.Equal(t, tc.expectedErr, errors.Cause(actualErr), "Two errors should be the same")
+			}
+
+			for _, expected := range tc.expectedCarts {
+				actualCart, err := connTest.Cart(context.Background(), expected.ID.Hex())
+				require.NoError(t, err)
+				assert.Equal(t, &expected, actualCart, "Remaining carts should be kept")
+			}
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package mongo
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	inventoryCollectionName    = "inventory"
+	reservationsCollectionName = "reservations"
+
+	defaultReservationTTL = 2 * time.Hour
+)
+
+// stockDocument is stock of a product variant. Reserved is the sum of its reservations converted to Unit.
+type stockDocument struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Product   string             `bson:"product"`
+	VariantID string             `bson:"variant_id"`
+	OnHand    float64            `bson:"on_hand"`
+	Reserved  float64            `bson:"reserved"`
+	Unit      units.Unit         `bson:"unit"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+func (doc stockDocument) stock() *inventory.Stock {
+	return &inventory.Stock{
+		Product:   doc.Product,
+		VariantID: doc.VariantID,
+		OnHand:    doc.OnHand,
+		Reserved:  doc.Reserved,
+		Available: doc.available(),
+		Unit:      doc.Unit,
+		UpdatedAt: doc.UpdatedAt,
+	}
+}
+
+// available returns quantity not reserved. It is zero if quantity on hand is set below reserved.
+func (doc stockDocument) available() float64 {
+	if a := units.Round(doc.OnHand-doc.Reserved, units.MaxPrecision); a > 0 {
+		return a
+	}
+	return 0
+}
+
+// reservationDocument is quantity of stock held for a line of a cart, measured in unit of the stock
+// at the time of reservation.
+type reservationDocument struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	CartID    string             `bson:"cart_id"`
+	ItemID    string             `bson:"item_id"`
+	Product   string             `bson:"product"`
+	VariantID string             `bson:"variant_id"`
+	Quantity  float64            `bson:"quantity"`
+	Unit      units.Unit         `bson:"unit"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// WithInventory makes DB reserve stock of tracked products for lines of carts in the transaction changing them
+// and create indexes of inventory in Connect. Reservations of a cart not changed for ttl expire
+// and are returned to stock by ReleaseExpiredReservations. Transactions require a replica set.
+func WithInventory(ttl time.Duration) Option {
+	return func(db *DB) {
+		db.inventory = true
+		if ttl > 0 {
+			db.reservationTTL = ttl
+		}
+	}
+}
+
+// createInventoryIndexes creates indexes keeping a single stock per product variant and a single reservation
+// per line and the one used to find expired reservations.
+func (db *DB) createInventoryIndexes(ctx context.Context) error {
+	_, err := db.Inventory.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "product", Value: 1}, {Key: "variant_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not create inventory index")
+	}
+	_, err = db.Reservations.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "cart_id", Value: 1}, {Key: "item_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+	})
+	return errors.Wrap(err, "could not create reservations indexes")
+}
+
+// SetStock sets quantity of a product variant on hand, starting to track it, and returns the stock.
+// Reserved quantity is converted to unit, ErrIncompatibleUnit is returned if it cannot be.
+func (db *DB) SetStock(ctx context.Context, product, variantID string, onHand float64, unit units.Unit) (_ *inventory.Stock, err error) {
+	ctx, finish := db.startOp(ctx, "SetStock", inventoryCollectionName, "")
+	defer finish(&err)
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		current, err := db.findStock(ctx, product, variantID)
+		switch {
+		case errors.Cause(err) == ErrNotFound:
+			doc := stockDocument{
+				ID:        primitive.NewObjectID(),
+				Product:   product,
+				VariantID: variantID,
+				OnHand:    onHand,
+				Unit:      unit,
+				UpdatedAt: now,
+			}
+			_, err := db.Inventory.InsertOne(ctx, doc)
+			if isDuplicateKey(err) {
+				// stock was set between read and insert
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "could not insert stock")
+			}
+			return doc.stock(), nil
+		case err != nil:
+			return nil, err
+		}
+
+		doc := *current
+		if unit != current.Unit {
+			reserved, err := units.Convert(current.Reserved, current.Unit, unit)
+			if err != nil && current.Reserved > 0 {
+				return nil, errors.Wrapf(inventory.ErrIncompatibleUnit, "stock of %s is reserved in %s", product, current.Unit)
+			}
+			doc.Reserved = units.Round(reserved, units.MaxPrecision)
+		}
+		doc.OnHand, doc.Unit, doc.UpdatedAt = onHand, unit, now
+		updateResult, err := db.Inventory.UpdateOne(ctx,
+			bson.M{"_id": current.ID, "reserved": current.Reserved, "unit": current.Unit},
+			bson.M{"$set": bson.M{"on_hand": doc.OnHand, "reserved": doc.Reserved, "unit": doc.Unit, "updated_at": now}})
+		switch {
+		case err != nil:
+			return nil, errors.Wrap(err, "could not update stock")
+		case updateResult.MatchedCount == 0:
+			// stock was reserved between read and update
+			continue
+		}
+		logger.FromContext(ctx, db.logger).Info("stock set",
+			slog.String("product", product),
+			slog.String("variant_id", variantID),
+			slog.Float64("on_hand", onHand),
+			slog.String("unit", string(unit)))
+		return doc.stock(), nil
+	}
+	return nil, errors.New("could not set stock: stock is modified concurrently")
+}
+
+// Stock returns stock of a product variant and ErrNotFound if it is not tracked.
+func (db *DB) Stock(ctx context.Context, product, variantID string) (_ *inventory.Stock, err error) {
+	ctx, finish := db.startOp(ctx, "Stock", inventoryCollectionName, "")
+	defer finish(&err)
+	doc, err := db.findStock(ctx, product, variantID)
+	if err != nil {
+		return nil, err
+	}
+	return doc.stock(), nil
+}
+
+func (db *DB) findStock(ctx context.Context, product, variantID string) (*stockDocument, error) {
+	var doc stockDocument
+	err := db.Inventory.FindOne(ctx, bson.M{"product": product, "variant_id": variantID}).Decode(&doc)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil, errors.Wrapf(ErrNotFound, "stock of %s is not tracked", product)
+	case err != nil:
+		return nil, errors.Wrap(err, "could not decode stock")
+	default:
+		return &doc, nil
+	}
+}
+
+// setReserved sets reserved quantity of stock unless it is changed since stock was read, in which case false is returned.
+func (db *DB) setReserved(ctx context.Context, stock *stockDocument, reserved float64) (bool, error) {
+	if reserved < 0 {
+		reserved = 0
+	}
+	updateResult, err := db.Inventory.UpdateOne(ctx,
+		bson.M{"_id": stock.ID, "reserved": stock.Reserved, "unit": stock.Unit},
+		bson.M{"$set": bson.M{"reserved": units.Round(reserved, units.MaxPrecision), "updated_at": time.Now().UTC()}})
+	if err != nil {
+		return false, errors.Wrap(err, "could not update reserved stock")
+	}
+	return updateResult.MatchedCount > 0, nil
+}
+
+// reserveItems reserves stock for lines added or updated by evs and releases stock of removed lines and deleted carts.
+// Reservations of changed carts are extended.
+func (db *DB) reserveItems(ctx context.Context, evs []events.Event) error {
+	changed := make(map[string]bool)
+	for _, e := range evs {
+		var err error
+		switch {
+		case e.Type == events.CartDeleted:
+			err = db.ReleaseCart(ctx, e.CartID)
+			delete(changed, e.CartID)
+		case e.Type == events.ItemRemoved:
+			err = db.Release(ctx, e.CartID, e.ItemID)
+			changed[e.CartID] = true
+		case e.Item != nil:
+			err = db.Reserve(ctx, inventory.Reservation{
+				CartID:    e.CartID,
+				ItemID:    e.Item.ID.Hex(),
+				Product:   e.Item.ProductName,
+				VariantID: e.Item.VariantID,
+				Quantity:  e.Item.Quantity,
+				Unit:      e.Item.Unit,
+			})
+			changed[e.CartID] = true
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for cartID := range changed {
+		_, err := db.Reservations.UpdateMany(ctx,
+			bson.M{"cart_id": cartID},
+			bson.M{"$set": bson.M{"expires_at": db.reservationExpiry()}})
+		if err != nil {
+			return errors.Wrap(err, "could not extend reservations")
+		}
+	}
+	return nil
+}
+
+func (db *DB) reservationExpiry() time.Time {
+	return time.Now().UTC().Add(db.reservationTTL)
+}
+
+// Reserve sets quantity reserved for a line of a cart to r.Quantity. Products without stock are not tracked.
+// Func returns *inventory.InsufficientStockError if available stock is short and inventory.ErrIncompatibleUnit
+// if r.Unit cannot be converted to unit of the stock.
+func (db *DB) Reserve(ctx context.Context, r inventory.Reservation) error {
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		stock, q, held, err := db.reservation(ctx, r)
+		if err != nil || stock == nil {
+			return err
+		}
+		ok, err := db.setReserved(ctx, stock, stock.Reserved+q-held)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// stock was changed between read and update
+			continue
+		}
+
+		if q == 0 {
+			_, err = db.Reservations.DeleteOne(ctx, bson.M{"cart_id": r.CartID, "item_id": r.ItemID})
+			return errors.Wrap(err, "could not delete reservation")
+		}
+		_, err = db.Reservations.UpdateOne(ctx,
+			bson.M{"cart_id": r.CartID, "item_id": r.ItemID},
+			bson.M{"$set": bson.M{
+				"product":    r.Product,
+				"variant_id": r.VariantID,
+				"quantity":   q,
+				"unit":       stock.Unit,
+				"expires_at": db.reservationExpiry(),
+			}},
+			options.Update().SetUpsert(true))
+		return errors.Wrap(err, "could not save reservation")
+	}
+	return errors.New("could not reserve stock: stock is modified concurrently")
+}
+
+// Check returns the error Reserve would return for r without reserving anything.
+func (db *DB) Check(ctx context.Context, r inventory.Reservation) error {
+	_, _, _, err := db.reservation(ctx, r)
+	return err
+}
+
+// reservation returns stock of the product of r along with quantity of r and quantity already reserved for its line,
+// both in unit of the stock. Stock is nil if the product is not tracked.
+// Func returns *inventory.InsufficientStockError if available stock is short.
+func (db *DB) reservation(ctx context.Context, r inventory.Reservation) (_ *stockDocument, q, held float64, err error) {
+	if r.Unit == "" {
+		r.Unit = units.Piece
+	}
+	stock, err := db.findStock(ctx, r.Product, r.VariantID)
+	switch {
+	case errors.Cause(err) == ErrNotFound:
+		return nil, 0, 0, nil
+	case err != nil:
+		return nil, 0, 0, err
+	}
+	var prev reservationDocument
+	err = db.Reservations.FindOne(ctx, bson.M{"cart_id": r.CartID, "item_id": r.ItemID}).Decode(&prev)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, 0, 0, errors.Wrap(err, "could not decode reservation")
+	}
+
+	q, err = units.Convert(r.Quantity, r.Unit, stock.Unit)
+	if err != nil {
+		return nil, 0, 0, errors.Wrapf(inventory.ErrIncompatibleUnit, "could not reserve %s of stock in %s", r.Unit, stock.Unit)
+	}
+	if held, err = heldQuantity(prev, stock.Unit); err != nil {
+		return nil, 0, 0, err
+	}
+	q = units.Round(q, units.MaxPrecision)
+	if delta := units.Round(q-held, units.MaxPrecision); delta > stock.available() {
+		available, _ := units.Convert(stock.available()+held, stock.Unit, r.Unit)
+		return nil, 0, 0, &inventory.InsufficientStockError{
+			Product:   r.Product,
+			VariantID: r.VariantID,
+			Requested: r.Quantity,
+			Available: units.Round(available, units.MaxPrecision),
+			Unit:      r.Unit,
+		}
+	}
+	return stock, q, held, nil
+}
+
+// heldQuantity returns quantity of reservation r converted to unit, zero if there is no reservation.
+func heldQuantity(r reservationDocument, unit units.Unit) (float64, error) {
+	if r.Quantity == 0 {
+		return 0, nil
+	}
+	q, err := units.Convert(r.Quantity, r.Unit, unit)
+	if err != nil {
+		return 0, errors.Wrapf(inventory.ErrIncompatibleUnit, "stock is reserved in %s", r.Unit)
+	}
+	return units.Round(q, units.MaxPrecision), nil
+}
+
+// Release returns quantity reserved for a line of a cart to stock.
+func (db *DB) Release(ctx context.Context, cartID, itemID string) error {
+	var doc reservationDocument
+	err := db.Reservations.FindOneAndDelete(ctx, bson.M{"cart_id": cartID, "item_id": itemID}).Decode(&doc)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil
+	case err != nil:
+		return errors.Wrap(err, "could not delete reservation")
+	}
+	return db.returnStock(ctx, doc)
+}
+
+// ReleaseCart returns quantities reserved for all lines of a cart to stock.
+func (db *DB) ReleaseCart(ctx context.Context, cartID string) error {
+	cur, err := db.Reservations.Find(ctx, bson.M{"cart_id": cartID})
+	if err != nil {
+		return errors.Wrap(err, "could not find reservations")
+	}
+	defer cur.Close(ctx)
+	var docs []reservationDocument
+	if err := cur.All(ctx, &docs); err != nil {
+		return errors.Wrap(err, "could not decode reservations")
+	}
+	for _, doc := range docs {
+		if err := db.returnStock(ctx, doc); err != nil {
+			return err
+		}
+	}
+	_, err = db.Reservations.DeleteMany(ctx, bson.M{"cart_id": cartID})
+	return errors.Wrap(err, "could not delete reservations")
+}
+
+// returnStock subtracts quantity of a deleted reservation from reserved stock.
+func (db *DB) returnStock(ctx context.Context, r reservationDocument) error {
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		stock, err := db.findStock(ctx, r.Product, r.VariantID)
+		switch {
+		case errors.Cause(err) == ErrNotFound:
+			return nil
+		case err != nil:
+			return err
+		}
+		held, err := heldQuantity(r, stock.Unit)
+		if err != nil {
+			return err
+		}
+		ok, err := db.setReserved(ctx, stock, stock.Reserved-held)
+		if err != nil || ok {
+			return err
+		}
+	}
+	return errors.New("could not release stock: stock is modified concurrently")
+}
+
+// ReleaseExpiredReservations returns to stock quantities of reservations expired by now, each in its own transaction.
+// Lines of the carts stay, they are reserved again once changed. Func returns number of released reservations.
+func (db *DB) ReleaseExpiredReservations(ctx context.Context, now time.Time) (_ int, err error) {
+	ctx, finish := db.startOp(ctx, "ReleaseExpiredReservations", reservationsCollectionName, "")
+	defer finish(&err)
+	expired := bson.M{"$lte": now.UTC()}
+	cur, err := db.Reservations.Find(ctx, bson.M{"expires_at": expired})
+	if err != nil {
+		return 0, errors.Wrap(err, "could not find expired reservations")
+	}
+	defer cur.Close(ctx)
+
+	released := 0
+	for cur.Next(ctx) {
+		var doc reservationDocument
+		if err := cur.Decode(&doc); err != nil {
+			return released, errors.Wrap(err, "could not decode reservation")
+		}
+		var ok bool
+		err = db.transaction(ctx, func(sc mongo.SessionContext) error {
+			// cart may be changed after the reservation was found
+			deleteResult, err := db.Reservations.DeleteOne(sc, bson.M{"_id": doc.ID, "expires_at": expired})
+			if err != nil {
+				return errors.Wrap(err, "could not delete reservation")
+			}
+			ok = deleteResult.DeletedCount > 0
+			if !ok {
+				return nil
+			}
+			return db.returnStock(sc, doc)
+		})
+		if err != nil {
+			return released, err
+		}
+		if ok {
+			db.log(ctx, doc.CartID).Info("stock reservation expired",
+				slog.String(logger.ItemIDKey, doc.ItemID),
+				slog.String("product", doc.Product),
+				slog.Float64("quantity", doc.Quantity),
+				slog.String("unit", string(doc.Unit)))
+			released++
+		}
+	}
+	return released, errors.Wrap(cur.Err(), "could not read reservations")
+}
@@ -0,0 +1,83 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/inventory"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestInventory requires mongo running as a replica set, e.g. started with --replSet rs0 and rs.initiate().
+func TestInventory(t *testing.T) {
+	ctx := context.Background()
+	connTest, err := Connect(ctx, dbTestConnString, dbTestName, WithInventory(time.Hour))
+	require.NoError(t, err, "could not create db instance")
+	defer func() {
+		assert.NoError(t, cleanUpCollection(connTest, cartsCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, auditCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, inventoryCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, reservationsCollectionName))
+	}()
+
+	_, err = connTest.SetStock(ctx, "flour", "", 3, units.Kilogram)
+	require.NoError(t, err)
+	cart, err := connTest.AddCart(ctx)
+	require.NoError(t, err)
+	cartID := cart.ID.Hex()
+
+	flour, err := connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "flour", Quantity: 2, Unit: units.Kilogram})
+	require.NoError(t, err)
+	_, err = connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "untracked", Quantity: 100})
+	require.NoError(t, err, "Products without stock should not be limited")
+
+	_, err = connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "flour", Quantity: 1500, Unit: units.Gram})
+	assert.Equal(t, &inventory.InsufficientStockError{
+		Product: "flour", Requested: 1500, Available: 1000, Unit: units.Gram,
+	}, errors.Cause(err))
+	cart, err = connTest.Cart(ctx, cartID)
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), cart.Items[0].Quantity, "Line should not change without stock")
+
+	_, err = connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "flour", Quantity: 1, Unit: units.Litre})
+	assert.Equal(t, inventory.ErrIncompatibleUnit, errors.Cause(err))
+
+	err = connTest.Check(ctx, inventory.Reservation{
+		CartID: cartID, ItemID: flour.ID.Hex(), Product: "flour", Quantity: 3500, Unit: units.Gram,
+	})
+	assert.Equal(t, &inventory.InsufficientStockError{
+		Product: "flour", Requested: 3500, Available: 3000, Unit: units.Gram,
+	}, errors.Cause(err), "Quantity reserved for the line should be available to it")
+
+	stock, err := connTest.Stock(ctx, "flour", "")
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), stock.Reserved)
+	assert.Equal(t, float64(1), stock.Available)
+
+	stock, err = connTest.SetStock(ctx, "flour", "", 5000, units.Gram)
+	require.NoError(t, err)
+	assert.Equal(t, float64(2000), stock.Reserved, "Reserved stock should be converted to new unit")
+
+	require.NoError(t, connTest.RemoveItemFromCart(ctx, cartID, flour.ID.Hex()))
+	stock, err = connTest.Stock(ctx, "flour", "")
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), stock.Reserved, "Stock of removed line should be released")
+
+	_, err = connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "flour", Quantity: 5, Unit: units.Kilogram})
+	require.NoError(t, err)
+	released, err := connTest.ReleaseExpiredReservations(ctx, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, released)
+	stock, err = connTest.Stock(ctx, "flour", "")
+	require.NoError(t, err)
+	assert.Equal(t, float64(5000), stock.Available, "Stock of expired reservation should be released")
+
+	_, err = connTest.Stock(ctx, "salt", "")
+	assert.Equal(t, ErrNotFound, errors.Cause(err))
+}
@@ -0,0 +1,228 @@
+package mongo
+
+import (
+	"context"
+	"log/slog"
+	"math"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ApplyItemOperations applies all operations to items of a cart with a specified ID in order and atomically.
+// Items are replaced in a single update conditioned on version of the read cart.
+// Func returns ErrNotFound if no cart was found, service.ErrVersionConflict if version is not service.AnyVersion
+// and differs from version of the cart and service.ErrOperationsFailed along with results if any operation failed.
+func (db *DB) ApplyItemOperations(ctx context.Context, cartID string, version int64, ops []service.ItemOperation) (_ []service.ItemOperationResult, err error) {
+	ctx, finish := db.startOp(ctx, "ApplyItemOperations", cartsCollectionName, cartID)
+	defer finish(&err)
+	cartObjID, err := primitive.ObjectIDFromHex(cartID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not convert %s to ObjectID", cartID)
+	}
+
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		var (
+			results []service.ItemOperationResult
+			applied bool
+		)
+		err = db.write(ctx, func(ctx context.Context) ([]events.Event, error) {
+			var cart service.Cart
+			err := db.Carts.FindOne(ctx, bson.M{"_id": cartObjID}).Decode(&cart)
+			switch {
+			case err == mongo.ErrNoDocuments:
+				return nil, errors.Wrap(ErrNotFound, "no carts")
+			case err != nil:
+				return nil, errors.Wrap(err, "could not decode document")
+			}
+			if version != service.AnyVersion && cart.Version != version {
+				return nil, errors.Wrapf(service.ErrVersionConflict, "cart version is %d", cart.Version)
+			}
+
+			items, res, ok := applyOperations(cart, ops)
+			results = res
+			if !ok {
+				return nil, errors.Wrap(service.ErrOperationsFailed, "no operations are applied")
+			}
+			updateResult, err := db.Carts.UpdateOne(
+				ctx,
+				bson.M{"_id": cartObjID, "version": versionFilter(cart.Version)},
+				touch(bson.M{"$set": bson.M{"items": items}, "$inc": bson.M{"version": 1}}))
+			switch {
+			case err != nil:
+				return nil, errors.Wrap(err, "could not update items of cart")
+			case updateResult.MatchedCount == 0:
+				// cart was modified between read and update
+				applied = false
+				return nil, nil
+			}
+			applied = true
+			return operationEvents(cart, ops, results), nil
+		})
+		if errors.Cause(err) == service.ErrOperationsFailed {
+			return results, err
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !applied {
+			continue
+		}
+		db.log(ctx, cartID).Info("item operations applied to cart", slog.Int("operations", len(ops)))
+		return results, nil
+	}
+	return nil, errors.New("could not apply operations: cart is modified concurrently")
+}
+
+// versionFilter returns condition matching cart version v. Carts stored before versions were introduced have none.
+func versionFilter(v int64) interface{} {
+	if v == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return v
+}
+
+// operationEvents returns events of applied ops. Additions merged into existing lines are reported as updates.
+// Previous line of every event is the line as it was left by preceding ops.
+func operationEvents(cart service.Cart, ops []service.ItemOperation, results []service.ItemOperationResult) []events.Event {
+	lines := make(map[primitive.ObjectID]service.CartItem, len(cart.Items))
+	for _, item := range cart.Items {
+		lines[item.ID] = item
+	}
+	evs := make([]events.Event, 0, len(ops))
+	for i, op := range ops {
+		e := events.Event{CartID: cart.ID.Hex(), ItemID: op.ItemID, Item: results[i].Item}
+		id, _ := primitive.ObjectIDFromHex(op.ItemID)
+		if e.Item != nil {
+			id = e.Item.ID
+		}
+		prev, existed := lines[id]
+		if existed {
+			e.Previous = &prev
+		}
+		switch {
+		case op.Op == service.OpRemove:
+			e.Type = events.ItemRemoved
+			delete(lines, id)
+		case op.Op == service.OpAdd && !existed:
+			e.Type = events.ItemAdded
+			e.ItemID = id.Hex()
+		default:
+			e.Type = events.ItemUpdated
+			e.ItemID = id.Hex()
+		}
+		if e.Item != nil {
+			lines[id] = *e.Item
+		}
+		evs = append(evs, e)
+	}
+	return evs
+}
+
+// applyOperations applies ops to a copy of cart items. It returns new items, result of every operation
+// and whether all operations succeeded.
+func applyOperations(cart service.Cart, ops []service.ItemOperation) ([]service.CartItem, []service.ItemOperationResult, bool) {
+	items := make([]service.CartItem, len(cart.Items))
+	copy(items, cart.Items)
+	results := make([]service.ItemOperationResult, 0, len(ops))
+	ok := true
+	for _, op := range ops {
+		var (
+			item *service.CartItem
+			err  error
+		)
+		switch op.Op {
+		case service.OpAdd:
+			items, item, err = addItem(items, cart.ID, op.Item)
+		case service.OpUpdate:
+			item, err = updateItem(items, op.ItemID, op.Item)
+		case service.OpRemove:
+			items, err = removeItem(items, op.ItemID)
+		default:
+			err = errors.Errorf("unknown operation %q", op.Op)
+		}
+		res := service.ItemOperationResult{Op: op.Op, Item: item}
+		if err != nil {
+			res.Error = err.Error()
+			ok = false
+		}
+		results = append(results, res)
+	}
+	return items, results, ok
+}
+
+func addItem(items []service.CartItem, cartID primitive.ObjectID, item service.CartItem) ([]service.CartItem, *service.CartItem, error) {
+	item = normalizeItem(item, cartID)
+	i := mergeableIndex(items, item)
+	if i < 0 {
+		item.ID = primitive.NewObjectID()
+		return append(items, item), &item, nil
+	}
+	line := &items[i]
+	if line.Unit == "" {
+		line.Unit = units.Piece
+	}
+	q, err := units.Convert(item.Quantity, item.Unit, line.Unit)
+	if err != nil {
+		return items, nil, err
+	}
+	line.Quantity = units.Round(line.Quantity+q, units.MaxPrecision)
+	merged := *line
+	return items, &merged, nil
+}
+
+func updateItem(items []service.CartItem, itemID string, update service.CartItem) (*service.CartItem, error) {
+	i, err := findItem(items, itemID)
+	if err != nil {
+		return nil, err
+	}
+	line := &items[i]
+	if line.Unit == "" {
+		line.Unit = units.Piece
+	}
+	unit := line.Unit
+	if update.Unit != "" {
+		if !units.Compatible(line.Unit, update.Unit) {
+			return nil, errors.Errorf("could not change unit %s to %s", line.Unit, update.Unit)
+		}
+		unit = update.Unit
+	}
+	if unit == units.Piece && update.Quantity != math.Trunc(update.Quantity) {
+		return nil, errors.New("quantity of pieces must be a whole number")
+	}
+	line.Quantity = update.Quantity
+	line.Unit = unit
+	if update.Price != 0 {
+		line.Price = update.Price
+	}
+	updated := *line
+	return &updated, nil
+}
+
+func removeItem(items []service.CartItem, itemID string) ([]service.CartItem, error) {
+	i, err := findItem(items, itemID)
+	if err != nil {
+		return items, err
+	}
+	return append(items[:i:i], items[i+1:]...), nil
+}
+
+// findItem returns index of an item with a specified ID.
+func findItem(items []service.CartItem, itemID string) (int, error) {
+	id, err := primitive.ObjectIDFromHex(itemID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not convert %s to ObjectID", itemID)
+	}
+	for i, item := range items {
+		if item.ID == id {
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("item %s is not found", itemID)
+}
@@ -0,0 +1,168 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_applyOperations(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	itemObjIDSet := generatePrimObjIDSet(3)
+	cart := service.Cart{
+		ID: cartObjIDSet[0],
+		Items: []service.CartItem{
+			{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "apples", Quantity: 1, Unit: units.Kilogram},
+			{ID: itemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "milk", Quantity: 2},
+		},
+	}
+	tt := []struct {
+		name            string
+		ops             []service.ItemOperation
+		expectedItems   []service.CartItem
+		expectedResults []service.ItemOperationResult
+		expectedOK      bool
+	}{
+		{
+			name: "all operations succeed",
+			ops: []service.ItemOperation{
+				{Op: service.OpAdd, Item: service.CartItem{ProductName: "apples", Quantity: 250, Unit: units.Gram}},
+				{Op: service.OpUpdate, ItemID: itemObjIDSet[1].Hex(), Item: service.CartItem{Quantity: 3}},
+				{Op: service.OpRemove, ItemID: itemObjIDSet[0].Hex()},
+			},
+			expectedItems: []service.CartItem{
+				{ID: itemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "milk", Quantity: 3, Unit: units.Piece},
+			},
+			expectedResults: []service.ItemOperationResult{
+				{Op: service.OpAdd, Item: &service.CartItem{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "apples", Quantity: 1.25, Unit: units.Kilogram}},
+				{Op: service.OpUpdate, Item: &service.CartItem{ID: itemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "milk", Quantity: 3, Unit: units.Piece}},
+				{Op: service.OpRemove},
+			},
+			expectedOK: true,
+		},
+		{
+			name: "update sets price only if given",
+			ops: []service.ItemOperation{
+				{Op: service.OpUpdate, ItemID: itemObjIDSet[0].Hex(), Item: service.CartItem{Quantity: 1, Price: 2.5}},
+				{Op: service.OpUpdate, ItemID: itemObjIDSet[0].Hex(), Item: service.CartItem{Quantity: 2}},
+			},
+			expectedItems: []service.CartItem{
+				{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "apples", Quantity: 2, Unit: units.Kilogram, Price: 2.5},
+				{ID: itemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "milk", Quantity: 2},
+			},
+			expectedResults: []service.ItemOperationResult{
+				{Op: service.OpUpdate, Item: &service.CartItem{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "apples", Quantity: 1, Unit: units.Kilogram, Price: 2.5}},
+				{Op: service.OpUpdate, Item: &service.CartItem{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "apples", Quantity: 2, Unit: units.Kilogram, Price: 2.5}},
+			},
+			expectedOK: true,
+		},
+		{
+			name: "every failure is reported",
+			ops: []service.ItemOperation{
+				{Op: service.OpRemove, ItemID: itemObjIDSet[2].Hex()},
+				{Op: service.OpUpdate, ItemID: itemObjIDSet[0].Hex(), Item: service.CartItem{Quantity: 1, Unit: units.Litre}},
+				{Op: service.OpUpdate, ItemID: itemObjIDSet[1].Hex(), Item: service.CartItem{Quantity: 1.5}},
+				{Op: "clear"},
+			},
+			expectedResults: []service.ItemOperationResult{
+				{Op: service.OpRemove, Error: "item " + itemObjIDSet[2].Hex() + " is not found"},
+				{Op: service.OpUpdate, Error: "could not change unit kg to l"},
+				{Op: service.OpUpdate, Error: "quantity of pieces must be a whole number"},
+				{Op: "clear", Error: `unknown operation "clear"`},
+			},
+			expectedOK: false,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			items, results, ok := applyOperations(cart, tc.ops)
+			assert.Equal(t, tc.expectedOK, ok)
+			assert.Equal(t, tc.expectedResults, results)
+			if tc.expectedOK {
+				assert.Equal(t, tc.expectedItems, items)
+			}
+			assert.Len(t, cart.Items, 2, "Items of the read cart should not be changed")
+			assert.Equal(t, 1.0, cart.Items[0].Quantity, "Items of the read cart should not be changed")
+		})
+	}
+}
+
+func Test_operationEvents(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	itemObjIDSet := generatePrimObjIDSet(2)
+	cart := service.Cart{
+		ID: cartObjIDSet[0],
+		Items: []service.CartItem{
+			{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "apples", Quantity: 1, Unit: units.Kilogram},
+			{ID: itemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "milk", Quantity: 2},
+		},
+	}
+	ops := []service.ItemOperation{
+		{Op: service.OpAdd, Item: service.CartItem{ProductName: "apples", Quantity: 250, Unit: units.Gram}},
+		{Op: service.OpAdd, Item: service.CartItem{ProductName: "bread", Quantity: 1}},
+		{Op: service.OpRemove, ItemID: itemObjIDSet[1].Hex()},
+	}
+	_, results, ok := applyOperations(cart, ops)
+	require.True(t, ok)
+
+	evs := operationEvents(cart, ops, results)
+	require.Len(t, evs, 3)
+	assert.Equal(t, events.Event{Type: events.ItemUpdated, CartID: cart.ID.Hex(), ItemID: itemObjIDSet[0].Hex(), Item: results[0].Item,
+		Previous: &cart.Items[0]}, evs[0], "Merged addition should be reported as update")
+	assert.Equal(t, events.Event{Type: events.ItemAdded, CartID: cart.ID.Hex(), ItemID: results[1].Item.ID.Hex(), Item: results[1].Item}, evs[1])
+	assert.Equal(t, events.Event{Type: events.ItemRemoved, CartID: cart.ID.Hex(), ItemID: itemObjIDSet[1].Hex(), Previous: &cart.Items[1]}, evs[2])
+}
+
+func TestApplyItemOperations(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	itemObjIDSet := generatePrimObjIDSet(2)
+	connTest, err := Connect(context.Background(), dbTestConnString, dbTestName)
+	require.NoError(t, err, "could not create db instance")
+	defer func() {
+		assert.NoError(t, cleanUpCollection(connTest, cartsCollectionName), "cleanUpCollection")
+	}()
+	err = initCollection(connTest, initCollectionParams{
+		CollectionName: cartsCollectionName,
+		Documents: []interface{}{
+			service.Cart{
+				ID: cartObjIDSet[0],
+				Items: []service.CartItem{
+					{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "product_1", Quantity: 1, Unit: units.Piece},
+				},
+			},
+		},
+	})
+	require.NoError(t, err, "initCollection")
+
+	_, err = connTest.ApplyItemOperations(context.Background(), cartObjIDSet[0].Hex(), service.AnyVersion, []service.ItemOperation{
+		{Op: service.OpRemove, ItemID: itemObjIDSet[0].Hex()},
+		{Op: service.OpRemove, ItemID: itemObjIDSet[1].Hex()},
+	})
+	assert.Equal(t, service.ErrOperationsFailed, errors.Cause(err))
+	cart, err := connTest.Cart(context.Background(), cartObjIDSet[0].Hex())
+	require.NoError(t, err)
+	assert.Len(t, cart.Items, 1, "Failed batch should not be applied")
+
+	results, err := connTest.ApplyItemOperations(context.Background(), cartObjIDSet[0].Hex(), service.AnyVersion, []service.ItemOperation{
+		{Op: service.OpAdd, Item: service.CartItem{ProductName: "product_2", Quantity: 2}},
+		{Op: service.OpRemove, ItemID: itemObjIDSet[0].Hex()},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	cart, err = connTest.Cart(context.Background(), cartObjIDSet[0].Hex())
+	require.NoError(t, err)
+	assert.Equal(t, []service.CartItem{*results[0].Item}, cart.Items)
+	assert.Equal(t, int64(1), cart.Version)
+
+	_, err = connTest.ApplyItemOperations(context.Background(), cartObjIDSet[0].Hex(), 0, []service.ItemOperation{
+		{Op: service.OpRemove, ItemID: results[0].Item.ID.Hex()},
+	})
+	assert.Equal(t, service.ErrVersionConflict, errors.Cause(err), "Operations on outdated version should be rejected")
+}
@@ -0,0 +1,342 @@
+package mongo
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/lists"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const listsCollectionName = "lists"
+
+// errChangedConcurrently aborts a transaction of a move when a cart or list is changed between read and update.
+var errChangedConcurrently = errors.New("changed concurrently")
+
+// listDocument is a list of a customer. Version is incremented by every change of items.
+type listDocument struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	CustomerID string             `bson:"customer_id"`
+	Name       string             `bson:"name"`
+	Items      []service.CartItem `bson:"items"`
+	Version    int64              `bson:"version"`
+	UpdatedAt  time.Time          `bson:"updated_at"`
+}
+
+func (doc listDocument) list() *lists.List {
+	l := &lists.List{
+		CustomerID: doc.CustomerID,
+		Name:       doc.Name,
+		Items:      doc.Items,
+		UpdatedAt:  doc.UpdatedAt,
+	}
+	if l.Items == nil {
+		l.Items = []service.CartItem{}
+	}
+	return l
+}
+
+// WithLists makes Connect create index of lists collection. Moves between carts and lists run in transactions,
+// which require a replica set.
+func WithLists() Option {
+	return func(db *DB) {
+		db.lists = true
+	}
+}
+
+// createListsIndex creates index keeping a single list of a name per customer.
+func (db *DB) createListsIndex(ctx context.Context) error {
+	_, err := db.Lists.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "customer_id", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return errors.Wrap(err, "could not create lists index")
+}
+
+// CustomerLists returns all lists of a customer ordered by name.
+func (db *DB) CustomerLists(ctx context.Context, customerID string) (_ []lists.List, err error) {
+	ctx, finish := db.startOp(ctx, "CustomerLists", listsCollectionName, "")
+	defer finish(&err)
+	cur, err := db.Lists.Find(ctx, bson.M{"customer_id": customerID}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find lists")
+	}
+	defer cur.Close(ctx)
+	ls := []lists.List{}
+	for cur.Next(ctx) {
+		var doc listDocument
+		if err := cur.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "could not decode list")
+		}
+		ls = append(ls, *doc.list())
+	}
+	return ls, errors.Wrap(cur.Err(), "could not read lists")
+}
+
+// List returns a list of a customer with a specified name, empty if nothing was put in it.
+func (db *DB) List(ctx context.Context, customerID, name string) (_ *lists.List, err error) {
+	ctx, finish := db.startOp(ctx, "List", listsCollectionName, "")
+	defer finish(&err)
+	var doc listDocument
+	err = db.Lists.FindOne(ctx, listFilter(customerID, name)).Decode(&doc)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return &lists.List{CustomerID: customerID, Name: name, Items: []service.CartItem{}}, nil
+	case err != nil:
+		return nil, errors.Wrap(err, "could not decode list")
+	default:
+		return doc.list(), nil
+	}
+}
+
+// AddItemToList puts item to a list of a customer and returns the added or merged line.
+func (db *DB) AddItemToList(ctx context.Context, customerID, name string, item service.CartItem) (_ *service.CartItem, err error) {
+	ctx, finish := db.startOp(ctx, "AddItemToList", listsCollectionName, "")
+	defer finish(&err)
+	var added *service.CartItem
+	err = db.updateList(ctx, customerID, name, func(items []service.CartItem) ([]service.CartItem, error) {
+		var err error
+		items, added, err = addItem(items, primitive.NilObjectID, item)
+		return items, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	db.log(ctx, "").Info("item added to list",
+		slog.String(logger.ItemIDKey, added.ID.Hex()),
+		slog.String("list", name))
+	return added, nil
+}
+
+// RemoveItemFromList removes a line with a specified ID from a list of a customer.
+// Func returns ErrNotFound if there is no such line.
+func (db *DB) RemoveItemFromList(ctx context.Context, customerID, name, itemID string) (err error) {
+	ctx, finish := db.startOp(ctx, "RemoveItemFromList", listsCollectionName, "")
+	defer finish(&err)
+	err = db.updateList(ctx, customerID, name, func(items []service.CartItem) ([]service.CartItem, error) {
+		items, _, err := takeItem(items, itemID)
+		return items, err
+	})
+	if err != nil {
+		return err
+	}
+	db.log(ctx, "").Info("item removed from list", slog.String(logger.ItemIDKey, itemID), slog.String("list", name))
+	return nil
+}
+
+// MoveToList removes a line of a cart and puts it to a list of a customer in a single transaction.
+// The line is merged into a line of the list of the same variant. Func returns the line of the list
+// and ErrNotFound if there is no such cart or line.
+func (db *DB) MoveToList(ctx context.Context, cartID, itemID, customerID, name string) (_ *service.CartItem, err error) {
+	ctx, finish := db.startOp(ctx, "MoveToList", "", cartID)
+	defer finish(&err)
+	cartObjID, err := primitive.ObjectIDFromHex(cartID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not convert %s to ObjectID", cartID)
+	}
+
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		var moved *service.CartItem
+		err = db.writeChange(ctx, "", true, func(ctx context.Context) ([]events.Event, error) {
+			cart, err := db.readCart(ctx, cartObjID)
+			if err != nil {
+				return nil, err
+			}
+			items, line, err := takeItem(cart.Items, itemID)
+			if err != nil {
+				return nil, err
+			}
+			if err := db.replaceItems(ctx, cart, items); err != nil {
+				return nil, err
+			}
+			ok, err := db.changeList(ctx, customerID, name, func(items []service.CartItem) ([]service.CartItem, error) {
+				var err error
+				items, moved, err = addItem(items, primitive.NilObjectID, line)
+				return items, err
+			})
+			switch {
+			case err != nil:
+				return nil, err
+			case !ok:
+				return nil, errChangedConcurrently
+			}
+			return []events.Event{{Type: events.ItemRemoved, CartID: cartID, ItemID: itemID, Previous: &line}}, nil
+		})
+		if errors.Cause(err) == errChangedConcurrently {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		db.log(ctx, cartID).Info("item moved to list",
+			slog.String(logger.ItemIDKey, itemID),
+			slog.String("list", name))
+		return moved, nil
+	}
+	return nil, errors.New("could not move item: cart or list is modified concurrently")
+}
+
+// MoveToCart removes a line of a list of a customer and adds it to a cart in a single transaction.
+// The line is merged into a line of the cart of the same variant. Func returns the line of the cart
+// and ErrNotFound if there is no such cart or line.
+func (db *DB) MoveToCart(ctx context.Context, customerID, name, itemID, cartID string) (_ *service.CartItem, err error) {
+	ctx, finish := db.startOp(ctx, "MoveToCart", "", cartID)
+	defer finish(&err)
+	cartObjID, err := primitive.ObjectIDFromHex(cartID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not convert %s to ObjectID", cartID)
+	}
+
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		var moved *service.CartItem
+		err = db.writeChange(ctx, "", true, func(ctx context.Context) ([]events.Event, error) {
+			var line service.CartItem
+			ok, err := db.changeList(ctx, customerID, name, func(items []service.CartItem) ([]service.CartItem, error) {
+				var err error
+				items, line, err = takeItem(items, itemID)
+				return items, err
+			})
+			switch {
+			case err != nil:
+				return nil, err
+			case !ok:
+				return nil, errChangedConcurrently
+			}
+
+			cart, err := db.readCart(ctx, cartObjID)
+			if err != nil {
+				return nil, err
+			}
+			items := make([]service.CartItem, len(cart.Items))
+			copy(items, cart.Items)
+			items, moved, err = addItem(items, cartObjID, line)
+			if err != nil {
+				return nil, err
+			}
+			if err := db.replaceItems(ctx, cart, items); err != nil {
+				return nil, err
+			}
+			e := events.Event{Type: events.ItemAdded, CartID: cartID, ItemID: moved.ID.Hex(), Item: moved}
+			if i := itemIndex(cart.Items, moved.ID); i >= 0 {
+				e.Type = events.ItemUpdated
+				e.Previous = &cart.Items[i]
+			}
+			return []events.Event{e}, nil
+		})
+		if errors.Cause(err) == errChangedConcurrently {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		db.log(ctx, cartID).Info("item moved to cart",
+			slog.String(logger.ItemIDKey, moved.ID.Hex()),
+			slog.String("list", name))
+		return moved, nil
+	}
+	return nil, errors.New("could not move item: cart or list is modified concurrently")
+}
+
+func listFilter(customerID, name string) bson.M {
+	return bson.M{"customer_id": customerID, "name": name}
+}
+
+// updateList replaces items of a list of a customer with items returned by fn, retrying if the list is
+// changed concurrently.
+func (db *DB) updateList(ctx context.Context, customerID, name string, fn func([]service.CartItem) ([]service.CartItem, error)) error {
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		ok, err := db.changeList(ctx, customerID, name, fn)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("could not change list: list is modified concurrently")
+}
+
+// changeList replaces items of a list of a customer with items returned by fn, creating the list if it does
+// not exist. Func returns false if the list is changed between read and update.
+func (db *DB) changeList(ctx context.Context, customerID, name string, fn func([]service.CartItem) ([]service.CartItem, error)) (bool, error) {
+	var doc listDocument
+	err := db.Lists.FindOne(ctx, listFilter(customerID, name)).Decode(&doc)
+	exists := err == nil
+	switch {
+	case err == mongo.ErrNoDocuments:
+		doc = listDocument{ID: primitive.NewObjectID(), CustomerID: customerID, Name: name, Items: []service.CartItem{}}
+	case err != nil:
+		return false, errors.Wrap(err, "could not decode list")
+	}
+
+	items, err := fn(doc.Items)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	if !exists {
+		doc.Items, doc.Version, doc.UpdatedAt = items, 1, now
+		_, err := db.Lists.InsertOne(ctx, doc)
+		if isDuplicateKey(err) {
+			// list was created concurrently
+			return false, nil
+		}
+		return err == nil, errors.Wrap(err, "could not insert list")
+	}
+	updateResult, err := db.Lists.UpdateOne(
+		ctx,
+		bson.M{"_id": doc.ID, "version": doc.Version},
+		bson.M{"$set": bson.M{"items": items, "updated_at": now}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return false, errors.Wrap(err, "could not update list")
+	}
+	return updateResult.MatchedCount > 0, nil
+}
+
+// readCart returns cart with a specified ID or ErrNotFound.
+func (db *DB) readCart(ctx context.Context, cartID primitive.ObjectID) (*service.Cart, error) {
+	var cart service.Cart
+	err := db.Carts.FindOne(ctx, bson.M{"_id": cartID}).Decode(&cart)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil, errors.Wrap(ErrNotFound, "no carts")
+	case err != nil:
+		return nil, errors.Wrap(err, "could not decode document")
+	default:
+		return &cart, nil
+	}
+}
+
+// replaceItems sets items of cart unless it is changed since it was read, errChangedConcurrently is returned then.
+func (db *DB) replaceItems(ctx context.Context, cart *service.Cart, items []service.CartItem) error {
+	updateResult, err := db.Carts.UpdateOne(
+		ctx,
+		bson.M{"_id": cart.ID, "version": versionFilter(cart.Version)},
+		touch(bson.M{"$set": bson.M{"items": items}, "$inc": bson.M{"version": 1}}))
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "could not update items of cart")
+	case updateResult.MatchedCount == 0:
+		return errChangedConcurrently
+	default:
+		return nil
+	}
+}
+
+// takeItem returns items without a line with a specified ID and the line, or ErrNotFound if there is none.
+func takeItem(items []service.CartItem, itemID string) ([]service.CartItem, service.CartItem, error) {
+	i, err := findItem(items, itemID)
+	if err != nil {
+		return nil, service.CartItem{}, errors.Wrap(ErrNotFound, err.Error())
+	}
+	return append(items[:i:i], items[i+1:]...), items[i], nil
+}
@@ -0,0 +1,67 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/lists"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestLists requires mongo running as a replica set, e.g. started with --replSet rs0 and rs.initiate().
+func TestLists(t *testing.T) {
+	ctx := context.Background()
+	connTest, err := Connect(ctx, dbTestConnString, dbTestName, WithLists())
+	require.NoError(t, err, "could not create db instance")
+	defer func() {
+		assert.NoError(t, cleanUpCollection(connTest, cartsCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, auditCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, listsCollectionName))
+	}()
+
+	cart, err := connTest.AddCart(ctx)
+	require.NoError(t, err)
+	cartID := cart.ID.Hex()
+	milk, err := connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "milk", Quantity: 2})
+	require.NoError(t, err)
+	saved, err := connTest.AddItemToList(ctx, "c-1", lists.SavedForLater, service.CartItem{ProductName: "milk", Quantity: 1, Unit: units.Piece})
+	require.NoError(t, err)
+	assert.Equal(t, primitive.NilObjectID, saved.CartID, "Lines of lists should have no cart")
+
+	moved, err := connTest.MoveToList(ctx, cartID, milk.ID.Hex(), "c-1", lists.SavedForLater)
+	require.NoError(t, err)
+	assert.Equal(t, saved.ID, moved.ID, "Moved line should be merged into line of the same variant")
+	assert.Equal(t, float64(3), moved.Quantity)
+	cart, err = connTest.Cart(ctx, cartID)
+	require.NoError(t, err)
+	assert.Empty(t, cart.Items, "Moved line should be removed from cart")
+
+	_, err = connTest.MoveToList(ctx, cartID, milk.ID.Hex(), "c-1", lists.SavedForLater)
+	assert.Equal(t, ErrNotFound, errors.Cause(err), "Line should be moved once")
+
+	back, err := connTest.MoveToCart(ctx, "c-1", lists.SavedForLater, moved.ID.Hex(), cartID)
+	require.NoError(t, err)
+	assert.Equal(t, cart.ID, back.CartID)
+	assert.Equal(t, float64(3), back.Quantity)
+	cart, err = connTest.Cart(ctx, cartID)
+	require.NoError(t, err)
+	assert.Equal(t, []service.CartItem{*back}, cart.Items)
+
+	ls, err := connTest.CustomerLists(ctx, "c-1")
+	require.NoError(t, err)
+	require.Len(t, ls, 1)
+	assert.Equal(t, lists.SavedForLater, ls[0].Name)
+	assert.Empty(t, ls[0].Items, "Moved line should be removed from list")
+
+	wishlist, err := connTest.List(ctx, "c-1", lists.Wishlist)
+	require.NoError(t, err)
+	assert.Empty(t, wishlist.Items, "List nothing was put in should be empty")
+	err = connTest.RemoveItemFromList(ctx, "c-1", lists.Wishlist, moved.ID.Hex())
+	assert.Equal(t, ErrNotFound, errors.Cause(err))
+}
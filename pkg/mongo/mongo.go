@@ -2,28 +2,120 @@ package mongo
 
 import (
 	"context"
+	"log/slog"
 	"time"
 
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/metrics"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/tracing"
+
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // DB is the repository, with all of the methods that are required to get info from the db.
 type DB struct {
-	Carts *mongo.Collection
+	Carts  *mongo.Collection
+	Outbox *mongo.Collection
+	Audit  *mongo.Collection
+	Lists  *mongo.Collection
+
+	Inventory    *mongo.Collection
+	Reservations *mongo.Collection
+
+	Webhooks          *mongo.Collection
+	WebhookDeliveries *mongo.Collection
+
+	logger   *slog.Logger
+	metrics  *metrics.Metrics
+	events   events.Publisher
+	outbox   bool
+	webhooks bool
+	lists    bool
+
+	inventory      bool
+	reservationTTL time.Duration
+
+	connectTimeout time.Duration
+	minPoolSize    uint64
+	maxPoolSize    uint64
 }
 
-const cartsCollectionName = "carts"
+// Option configures optional dependencies of DB.
+type Option func(*DB)
+
+// WithLogger sets logger used when context of a call carries no request scoped logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(db *DB) {
+		db.logger = l
+	}
+}
+
+const (
+	cartsCollectionName = "carts"
+
+	defaultConnectTimeout = 5 * time.Second
+)
 
 // ErrNotFound is used when result of select statement is empty.
-var ErrNotFound = errors.New("not found")
+// It is service.ErrNotFound, so callers of service.Service can recognize it.
+var ErrNotFound = service.ErrNotFound
+
+// WithMetrics enables collection of operation latencies, errors and cart sizes.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(db *DB) {
+		db.metrics = m
+	}
+}
+
+// WithEvents makes DB notify p about every change of cart items.
+func WithEvents(p events.Publisher) Option {
+	return func(db *DB) {
+		db.events = p
+	}
+}
+
+// WithConnectTimeout limits time spent on connecting to and pinging the database in Connect.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(db *DB) {
+		db.connectTimeout = d
+	}
+}
+
+// WithPoolSize sets minimum and maximum number of connections kept by the client. Zero means driver default.
+func WithPoolSize(minSize, maxSize uint64) Option {
+	return func(db *DB) {
+		db.minPoolSize = minSize
+		db.maxPoolSize = maxSize
+	}
+}
 
 // Connect connects to mongo DB with url, gets database with dbName and returns DB.
-func Connect(ctx context.Context, url, dbName string) (*DB, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+func Connect(ctx context.Context, url, dbName string, opts ...Option) (*DB, error) {
+	conn := &DB{connectTimeout: defaultConnectTimeout, reservationTTL: defaultReservationTTL}
+	for _, opt := range opts {
+		opt(conn)
+	}
+
+	clientOpts := options.Client().ApplyURI(url)
+	if conn.minPoolSize > 0 {
+		clientOpts.SetMinPoolSize(conn.minPoolSize)
+	}
+	if conn.maxPoolSize > 0 {
+		clientOpts.SetMaxPoolSize(conn.maxPoolSize)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, conn.connectTimeout)
 	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not create mongo client")
 	}
@@ -33,7 +125,95 @@ func Connect(ctx context.Context, url, dbName string) (*DB, error) {
 	}
 
 	db := client.Database(dbName)
-	carts := db.Collection(cartsCollectionName)
+	conn.Carts = db.Collection(cartsCollectionName)
+	conn.Outbox = db.Collection(outboxCollectionName)
+	conn.Audit = db.Collection(auditCollectionName)
+	conn.Lists = db.Collection(listsCollectionName)
+	conn.Inventory = db.Collection(inventoryCollectionName)
+	conn.Reservations = db.Collection(reservationsCollectionName)
+	conn.Webhooks = db.Collection(webhooksCollectionName)
+	conn.WebhookDeliveries = db.Collection(deliveriesCollectionName)
+	if err = conn.createAuditIndex(ctx); err != nil {
+		return nil, err
+	}
+	if conn.outbox {
+		if err = conn.createOutboxIndex(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if conn.webhooks {
+		if err = conn.createWebhookIndexes(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if conn.lists {
+		if err = conn.createListsIndex(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if conn.inventory {
+		if err = conn.createInventoryIndexes(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
 
-	return &DB{Carts: carts}, nil
+// log returns logger of the call carrying cart ID field.
+func (db *DB) log(ctx context.Context, cartID string) *slog.Logger {
+	return logger.FromContext(ctx, db.logger).With(slog.String(logger.CartIDKey, cartID))
+}
+
+// startOp starts span of the operation on a collection and a cart and returns context carrying it along with
+// finish func, that ends the span and records operation metrics. Finish must be deferred with pointer to
+// the operation error. Collection is empty for operations spanning several collections, cart ID for operations
+// on no single cart. ErrNotFound is an expected outcome, so it is not counted as a failure.
+func (db *DB) startOp(ctx context.Context, method, collection, cartID string) (context.Context, func(err *error)) {
+	start := time.Now()
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemMongoDB,
+		semconv.DBOperationName(method),
+	}
+	if collection != "" {
+		attrs = append(attrs, semconv.DBCollectionName(collection))
+	}
+	if cartID != "" {
+		attrs = append(attrs, attribute.String(logger.CartIDKey, cartID))
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "mongo.DB."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+
+	return ctx, func(err *error) {
+		opErr := *err
+		if errors.Cause(opErr) == ErrNotFound {
+			opErr = nil
+		}
+		if opErr != nil {
+			span.RecordError(opErr)
+			span.SetStatus(codes.Error, opErr.Error())
+		}
+		span.End()
+		db.metrics.ObserveDBOperation(method, time.Since(start), opErr)
+	}
+}
+
+// Ping checks that the database server responds.
+func (db *DB) Ping(ctx context.Context) error {
+	err := db.Carts.Database().Client().Ping(ctx, readpref.Primary())
+	if err != nil {
+		return errors.Wrap(err, "could not ping mongo client")
+	}
+	return nil
+}
+
+// publish notifies publisher set by WithEvents about changes of a cart.
+func (db *DB) publish(evs ...events.Event) {
+	if db.events == nil {
+		return
+	}
+	for _, e := range evs {
+		db.events.Publish(e)
+	}
 }
@@ -2,8 +2,11 @@ package mongo
 
 import (
 	"context"
+	"testing"
 
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -33,6 +36,8 @@ func initCollection(db *DB, initColParams initCollectionParams) error {
 	switch initColParams.CollectionName {
 	case cartsCollectionName:
 		_, err = db.Carts.InsertMany(context.TODO(), initColParams.Documents, initColParams.Opts)
+	case outboxCollectionName:
+		_, err = db.Outbox.InsertMany(context.TODO(), initColParams.Documents, initColParams.Opts)
 	default:
 		return errors.New("no such collection")
 	}
@@ -48,6 +53,20 @@ func cleanUpCollection(db *DB, colName string) error {
 	switch colName {
 	case cartsCollectionName:
 		err = db.Carts.Drop(context.TODO())
+	case outboxCollectionName:
+		err = db.Outbox.Drop(context.TODO())
+	case auditCollectionName:
+		err = db.Audit.Drop(context.TODO())
+	case webhooksCollectionName:
+		err = db.Webhooks.Drop(context.TODO())
+	case deliveriesCollectionName:
+		err = db.WebhookDeliveries.Drop(context.TODO())
+	case listsCollectionName:
+		err = db.Lists.Drop(context.TODO())
+	case inventoryCollectionName:
+		err = db.Inventory.Drop(context.TODO())
+	case reservationsCollectionName:
+		err = db.Reservations.Drop(context.TODO())
 	default:
 		return errors.New("no such collection")
 	}
@@ -57,3 +76,14 @@ func cleanUpCollection(db *DB, colName string) error {
 
 	return nil
 }
+
+func TestPing(t *testing.T) {
+	connTest, err := Connect(context.Background(), dbTestConnString, dbTestName)
+	require.NoError(t, err, "could not create db instance")
+
+	assert.NoError(t, connTest.Ping(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, connTest.Ping(ctx), "Ping with canceled context should fail")
+}
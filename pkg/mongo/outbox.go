@@ -0,0 +1,198 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/outbox"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const outboxCollectionName = "outbox"
+
+// transientTransactionError labels errors after which a transaction may be retried.
+const transientTransactionError = "TransientTransactionError"
+
+// outboxDocument is an event stored in outbox collection until it is delivered.
+type outboxDocument struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Event       outbox.CartEvent   `bson:"event"`
+	Attempts    int                `bson:"attempts"`
+	AvailableAt time.Time          `bson:"available_at"`
+	LastError   string             `bson:"last_error,omitempty"`
+}
+
+// WithOutbox makes DB write events of every change of carts to outbox collection in the same transaction
+// as the change, so they can be delivered by outbox.Relay. Transactions require a replica set.
+func WithOutbox() Option {
+	return func(db *DB) {
+		db.outbox = true
+	}
+}
+
+// write runs fn changing carts, records returned events in audit log and publishes them once the changes are made.
+// With outbox, webhooks or inventory enabled fn runs in a transaction, which also writes the events to audit log
+// and outbox collection, schedules their webhook deliveries and reserves stock for changed lines. Otherwise
+// the events are recorded after the change; failure to record them is logged and counted, as the change is made.
+// fn must make all changes with the context it gets and may be run again if the transaction is retried.
+func (db *DB) write(ctx context.Context, fn func(ctx context.Context) ([]events.Event, error)) error {
+	return db.writeChange(ctx, "", db.atomic(), fn)
+}
+
+// atomic reports whether changes of carts must run in transactions to write outbox, schedule webhook deliveries
+// or reserve stock along with them.
+func (db *DB) atomic() bool {
+	return db.outbox || db.webhooks || db.inventory
+}
+
+// writeChange is write recording the change as undo of a change with ID undoes, unless it is empty.
+// With atomic set fn runs in a transaction even if outbox is disabled, e.g. to change several collections.
+func (db *DB) writeChange(ctx context.Context, undoes string, atomic bool, fn func(ctx context.Context) ([]events.Event, error)) error {
+	if !atomic {
+		evs, err := fn(ctx)
+		if err != nil {
+			return err
+		}
+		if err := db.insertAudit(ctx, evs, undoes); err != nil {
+			// the change is made already, so it is not answered with an error, which would make clients repeat it
+			logger.FromContext(ctx, db.logger).Error("could not record change", logger.Err(err))
+			db.metrics.ObserveLostAudit()
+		}
+		db.publish(evs...)
+		return nil
+	}
+
+	var evs []events.Event
+	err := db.transaction(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		evs, err = fn(sc)
+		if err == nil && db.inventory {
+			err = db.reserveItems(sc, evs)
+		}
+		if err == nil {
+			err = db.insertAudit(sc, evs, undoes)
+		}
+		ces := cartEvents(evs)
+		if err == nil && db.outbox {
+			err = db.insertOutbox(sc, ces)
+		}
+		if err == nil && db.webhooks {
+			err = db.enqueueDeliveries(sc, ces)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	db.publish(evs...)
+	return nil
+}
+
+// transaction runs fn in a transaction, which is retried on transient errors.
+func (db *DB) transaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	return db.Carts.Database().Client().UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+			err := fn(sc)
+			if cerr, ok := errors.Cause(err).(mongo.CommandError); ok && cerr.HasErrorLabel(transientTransactionError) {
+				// transaction recognizes only unwrapped errors as retryable
+				return nil, cerr
+			}
+			return nil, err
+		})
+		return err
+	})
+}
+
+// cartEvents converts events of a change to events delivered to downstream systems, giving them new IDs.
+func cartEvents(evs []events.Event) []outbox.CartEvent {
+	now := time.Now().UTC()
+	ces := make([]outbox.CartEvent, 0, len(evs))
+	for _, e := range evs {
+		ces = append(ces, outbox.CartEvent{
+			ID:     primitive.NewObjectID().Hex(),
+			Type:   e.Type,
+			CartID: e.CartID,
+			ItemID: e.ItemID,
+			Item:   e.Item,
+			Time:   now,
+		})
+	}
+	return ces
+}
+
+// insertOutbox writes events to outbox collection.
+func (db *DB) insertOutbox(ctx context.Context, evs []outbox.CartEvent) error {
+	if len(evs) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, 0, len(evs))
+	for _, e := range evs {
+		id, err := primitive.ObjectIDFromHex(e.ID)
+		if err != nil {
+			return errors.Wrapf(err, "could not convert %s to ObjectID", e.ID)
+		}
+		docs = append(docs, outboxDocument{ID: id, Event: e, AvailableAt: e.Time})
+	}
+	_, err := db.Outbox.InsertMany(ctx, docs)
+	return errors.Wrap(err, "could not write events to outbox")
+}
+
+// ClaimEvent returns the oldest event of outbox available for delivery and hides it from other relays for lease.
+// Func returns outbox.ErrNoEvents if there is none.
+func (db *DB) ClaimEvent(ctx context.Context, lease time.Duration) (*outbox.Record, error) {
+	now := time.Now().UTC()
+	var doc outboxDocument
+	err := db.Outbox.FindOneAndUpdate(
+		ctx,
+		bson.M{"available_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"available_at": now.Add(lease)}, "$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetSort(bson.M{"_id": 1}).SetReturnDocument(options.After),
+	).Decode(&doc)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil, outbox.ErrNoEvents
+	case err != nil:
+		return nil, errors.Wrap(err, "could not claim outbox event")
+	default:
+		return &outbox.Record{Event: doc.Event, Attempts: doc.Attempts}, nil
+	}
+}
+
+// DeleteEvent removes a delivered event from outbox.
+func (db *DB) DeleteEvent(ctx context.Context, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.Wrapf(err, "could not convert %s to ObjectID", id)
+	}
+	_, err = db.Outbox.DeleteOne(ctx, bson.M{"_id": objID})
+	return errors.Wrap(err, "could not delete outbox event")
+}
+
+// RetryEvent makes an outbox event available for delivery again at a specified time and records the failure.
+func (db *DB) RetryEvent(ctx context.Context, id string, at time.Time, reason string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.Wrapf(err, "could not convert %s to ObjectID", id)
+	}
+	_, err = db.Outbox.UpdateOne(
+		ctx,
+		bson.M{"_id": objID},
+		bson.M{"$set": bson.M{"available_at": at.UTC(), "last_error": reason}})
+	return errors.Wrap(err, "could not reschedule outbox event")
+}
+
+// createOutboxIndex creates index used to claim events. It also creates outbox collection,
+// which cannot be created inside a transaction.
+func (db *DB) createOutboxIndex(ctx context.Context) error {
+	_, err := db.Outbox.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "available_at", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	return errors.Wrap(err, "could not create outbox index")
+}
@@ -0,0 +1,69 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/outbox"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestOutbox requires mongo running as a replica set, e.g. started with --replSet rs0 and rs.initiate().
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	connTest, err := Connect(ctx, dbTestConnString, dbTestName, WithOutbox())
+	require.NoError(t, err, "could not create db instance")
+	defer func() {
+		assert.NoError(t, cleanUpCollection(connTest, cartsCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, outboxCollectionName))
+	}()
+
+	cart, err := connTest.AddCart(ctx)
+	require.NoError(t, err)
+	cartID := cart.ID.Hex()
+	item, err := connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "apple", Quantity: 1})
+	require.NoError(t, err)
+	require.NoError(t, connTest.RemoveItemFromCart(ctx, cartID, item.ID.Hex()))
+	err = connTest.RemoveItemFromCart(ctx, cartID, item.ID.Hex())
+	assert.Equal(t, ErrNotFound, errors.Cause(err), "Failed change should write no event")
+
+	var (
+		claimed []*outbox.Record
+		types   []string
+	)
+	for {
+		rec, err := connTest.ClaimEvent(ctx, time.Minute)
+		if err == outbox.ErrNoEvents {
+			break
+		}
+		require.NoError(t, err)
+		claimed = append(claimed, rec)
+		types = append(types, rec.Event.Type)
+	}
+	assert.Equal(t, []string{events.CartCreated, events.ItemAdded, events.ItemRemoved}, types, "Events should be claimed in order")
+	for _, rec := range claimed {
+		assert.Equal(t, cartID, rec.Event.CartID)
+		assert.Equal(t, 1, rec.Attempts)
+	}
+	assert.Equal(t, item, claimed[1].Event.Item)
+
+	require.NoError(t, connTest.RetryEvent(ctx, claimed[0].Event.ID, time.Now().Add(-time.Second), "unavailable"))
+	rec, err := connTest.ClaimEvent(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, claimed[0].Event.ID, rec.Event.ID, "Retried event should be claimed again")
+	assert.Equal(t, 2, rec.Attempts)
+
+	for _, rec := range claimed {
+		require.NoError(t, connTest.DeleteEvent(ctx, rec.Event.ID))
+	}
+	n, err := connTest.Outbox.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Zero(t, n, "Delivered events should be deleted")
+}
@@ -0,0 +1,270 @@
+package mongo
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/audit"
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CartAt returns cart with a specified ID as it was at a specified time. The cart is rewound from its current
+// state by reverting item changes recorded in the audit log after at.
+// Func returns ErrNotFound if the cart does not exist now or was created after at and audit.ErrIncompleteHistory
+// if creation of the cart is not recorded or recorded changes of items do not add up to its version.
+func (db *DB) CartAt(ctx context.Context, cartID string, at time.Time) (_ *service.Cart, err error) {
+	ctx, finish := db.startOp(ctx, "CartAt", "", cartID)
+	defer finish(&err)
+	cartObjID, err := primitive.ObjectIDFromHex(cartID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not convert %s to ObjectID", cartID)
+	}
+
+	var cart service.Cart
+	err = db.Carts.FindOne(ctx, bson.M{"_id": cartObjID}).Decode(&cart)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil, errors.Wrap(ErrNotFound, "no carts")
+	case err != nil:
+		return nil, errors.Wrap(err, "could not decode document")
+	}
+
+	// the whole history is read to check it covers every version of the cart
+	cur, err := db.Audit.Find(ctx, bson.M{"cart_id": cartID}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find audit entries")
+	}
+	defer cur.Close(ctx)
+	changes, later := make(map[string]bool), make(map[string]bool)
+	created := false
+	for cur.Next(ctx) {
+		var doc auditDocument
+		if err := cur.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "could not decode audit entry")
+		}
+		e := doc.entry()
+		switch {
+		case e.Action == events.CartCreated && e.Time.After(at):
+			return nil, errors.Wrapf(ErrNotFound, "cart is created at %s", e.Time.Format(time.RFC3339))
+		case e.Action == events.CartCreated:
+			created = true
+		case isItemAction(e.Action):
+			changes[changeOf(e)] = true
+			if e.Time.After(at) {
+				cart.Items = undoEntry(cart.Items, e)
+				later[changeOf(e)] = true
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read audit entries")
+	}
+	// carts are created with version 0 and every change of items increments it once
+	if !created {
+		return nil, errors.Wrap(audit.ErrIncompleteHistory, "creation of cart is not recorded")
+	}
+	if int64(len(changes)) != cart.Version {
+		return nil, errors.Wrapf(audit.ErrIncompleteHistory, "%d changes of items are recorded, cart version is %d",
+			len(changes), cart.Version)
+	}
+	cart.Version -= int64(len(later))
+	return &cart, nil
+}
+
+// Undo reverts the latest change of items of a cart with a specified ID which is not undone yet.
+// The revert is recorded as a new change referring to the reverted one, so undo may be repeated to go further back.
+// Func returns ErrNotFound if no cart was found, audit.ErrNothingToUndo if there is no change to revert
+// and service.ErrVersionConflict if lines of the change were changed afterwards.
+func (db *DB) Undo(ctx context.Context, cartID string) (_ *service.Cart, err error) {
+	ctx, finish := db.startOp(ctx, "Undo", "", cartID)
+	defer finish(&err)
+	cartObjID, err := primitive.ObjectIDFromHex(cartID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not convert %s to ObjectID", cartID)
+	}
+
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		change, err := db.lastChange(ctx, cartID)
+		if err != nil {
+			return nil, err
+		}
+		changeID := changeOf(change[0])
+		var cart *service.Cart
+		err = db.writeChange(ctx, changeID, db.atomic(), func(ctx context.Context) ([]events.Event, error) {
+			var current service.Cart
+			err := db.Carts.FindOne(ctx, bson.M{"_id": cartObjID}).Decode(&current)
+			switch {
+			case err == mongo.ErrNoDocuments:
+				return nil, errors.Wrap(ErrNotFound, "no carts")
+			case err != nil:
+				return nil, errors.Wrap(err, "could not decode document")
+			}
+
+			items, evs, err := revert(current, change)
+			if err != nil {
+				return nil, err
+			}
+			updateResult, err := db.Carts.UpdateOne(
+				ctx,
+				bson.M{"_id": cartObjID, "version": versionFilter(current.Version)},
+				touch(bson.M{"$set": bson.M{"items": items}, "$inc": bson.M{"version": 1}}))
+			switch {
+			case err != nil:
+				return nil, errors.Wrap(err, "could not update items of cart")
+			case updateResult.MatchedCount == 0:
+				// cart was modified between read and update
+				return nil, nil
+			}
+			current.Items = items
+			current.Version++
+			cart = &current
+			return evs, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if cart == nil {
+			continue
+		}
+		db.log(ctx, cartID).Info("cart change undone",
+			slog.String("change_id", changeID),
+			slog.Int("entries", len(change)))
+		return cart, nil
+	}
+	return nil, errors.New("could not undo change: cart is modified concurrently")
+}
+
+// lastChange returns entries of the latest change of items of a cart which is neither an undo nor undone, latest first.
+func (db *DB) lastChange(ctx context.Context, cartID string) ([]audit.Entry, error) {
+	cur, err := db.Audit.Find(ctx, bson.M{"cart_id": cartID}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find audit entries")
+	}
+	defer cur.Close(ctx)
+	undone := make(map[string]bool)
+	var change []audit.Entry
+	for cur.Next(ctx) {
+		var doc auditDocument
+		if err := cur.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "could not decode audit entry")
+		}
+		e := doc.entry()
+		id := changeOf(e)
+		if len(change) > 0 && id != changeOf(change[0]) {
+			break
+		}
+		switch {
+		case e.Undoes != "":
+			// undo entries are recorded after the change they revert
+			undone[e.Undoes] = true
+		case undone[id] || !isItemAction(e.Action):
+		default:
+			change = append(change, e)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read audit entries")
+	}
+	if len(change) == 0 {
+		return nil, errors.Wrap(audit.ErrNothingToUndo, "no changes of items")
+	}
+	return change, nil
+}
+
+// changeOf returns ID of a change e belongs to. Entries recorded before change IDs were introduced are changes of their own.
+func changeOf(e audit.Entry) string {
+	if e.ChangeID == "" {
+		return e.ID
+	}
+	return e.ChangeID
+}
+
+func isItemAction(action string) bool {
+	return action == events.ItemAdded || action == events.ItemUpdated || action == events.ItemRemoved
+}
+
+// revert returns items of cart with change reverted and events of the revert. Entries of change are applied
+// latest first, each of them must match the line it left. Func returns service.ErrVersionConflict otherwise.
+func revert(cart service.Cart, change []audit.Entry) ([]service.CartItem, []events.Event, error) {
+	items := make([]service.CartItem, len(cart.Items))
+	copy(items, cart.Items)
+	evs := make([]events.Event, 0, len(change))
+	for _, e := range change {
+		ev := events.Event{CartID: cart.ID.Hex(), ItemID: e.ItemID}
+		if e.After != nil {
+			i := itemIndex(items, e.After.ID)
+			if i < 0 || !sameQuantity(items[i], *e.After) {
+				return nil, nil, errors.Wrapf(service.ErrVersionConflict, "item %s is changed after %s", e.ItemID, e.Action)
+			}
+			line := items[i]
+			ev.Previous = &line
+		} else if e.Before != nil && itemIndex(items, e.Before.ID) >= 0 {
+			return nil, nil, errors.Wrapf(service.ErrVersionConflict, "item %s is added after %s", e.ItemID, e.Action)
+		}
+		switch {
+		case e.Before == nil:
+			ev.Type = events.ItemRemoved
+		case e.After == nil:
+			ev.Type = events.ItemAdded
+			ev.Item = e.Before
+		default:
+			ev.Type = events.ItemUpdated
+			ev.Item = e.Before
+		}
+		items = undoEntry(items, e)
+		evs = append(evs, ev)
+	}
+	return items, evs, nil
+}
+
+// undoEntry returns items with the change of a line recorded in e reverted.
+// A line restored after removal is put back in order of item IDs.
+func undoEntry(items []service.CartItem, e audit.Entry) []service.CartItem {
+	switch {
+	case e.Before != nil:
+		if i := itemIndex(items, e.Before.ID); i >= 0 {
+			items[i] = *e.Before
+			return items
+		}
+		i := 0
+		for i < len(items) && items[i].ID.Hex() < e.Before.ID.Hex() {
+			i++
+		}
+		restored := append(items[:i:i], *e.Before)
+		return append(restored, items[i:]...)
+	case e.After != nil:
+		if i := itemIndex(items, e.After.ID); i >= 0 {
+			return append(items[:i:i], items[i+1:]...)
+		}
+	}
+	return items
+}
+
+func itemIndex(items []service.CartItem, id primitive.ObjectID) int {
+	for i, item := range items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameQuantity reports whether line and item have equal quantity. Lines without unit count pieces.
+func sameQuantity(line, item service.CartItem) bool {
+	if line.Unit == "" {
+		line.Unit = units.Piece
+	}
+	if item.Unit == "" {
+		item.Unit = units.Piece
+	}
+	return line.Quantity == item.Quantity && line.Unit == item.Unit
+}
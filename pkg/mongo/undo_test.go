@@ -0,0 +1,136 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/audit"
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_revert(t *testing.T) {
+	cartObjIDSet := generatePrimObjIDSet(1)
+	itemObjIDSet := generatePrimObjIDSet(3)
+	apples := service.CartItem{ID: itemObjIDSet[0], CartID: cartObjIDSet[0], ProductName: "apples", Quantity: 1, Unit: units.Kilogram}
+	milk := service.CartItem{ID: itemObjIDSet[1], CartID: cartObjIDSet[0], ProductName: "milk", Quantity: 2}
+	moreMilk := milk
+	moreMilk.Quantity = 3
+	moreMilk.Unit = units.Piece
+	bread := service.CartItem{ID: itemObjIDSet[2], CartID: cartObjIDSet[0], ProductName: "bread", Quantity: 1, Unit: units.Piece}
+	cart := service.Cart{ID: cartObjIDSet[0], Items: []service.CartItem{moreMilk, bread}}
+
+	tt := []struct {
+		name           string
+		change         []audit.Entry
+		expectedItems  []service.CartItem
+		expectedEvents []events.Event
+		expectedErr    error
+	}{
+		{
+			name: "batch is reverted latest first",
+			change: []audit.Entry{
+				{Action: events.ItemAdded, ItemID: bread.ID.Hex(), After: &bread},
+				{Action: events.ItemUpdated, ItemID: milk.ID.Hex(), Before: &milk, After: &moreMilk},
+				{Action: events.ItemRemoved, ItemID: apples.ID.Hex(), Before: &apples},
+			},
+			expectedItems: []service.CartItem{apples, milk},
+			expectedEvents: []events.Event{
+				{Type: events.ItemRemoved, CartID: cart.ID.Hex(), ItemID: bread.ID.Hex(), Previous: &bread},
+				{Type: events.ItemUpdated, CartID: cart.ID.Hex(), ItemID: milk.ID.Hex(), Item: &milk, Previous: &moreMilk},
+				{Type: events.ItemAdded, CartID: cart.ID.Hex(), ItemID: apples.ID.Hex(), Item: &apples},
+			},
+		},
+		{
+			name: "line is changed afterwards",
+			change: []audit.Entry{
+				{Action: events.ItemUpdated, ItemID: milk.ID.Hex(), Before: &moreMilk, After: &milk},
+			},
+			expectedErr: service.ErrVersionConflict,
+		},
+		{
+			name: "removed line is back",
+			change: []audit.Entry{
+				{Action: events.ItemRemoved, ItemID: bread.ID.Hex(), Before: &bread},
+			},
+			expectedErr: service.ErrVersionConflict,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			items, evs, err := revert(cart, tc.change)
+			assert.Equal(t, tc.expectedErr, errors.Cause(err))
+			assert.Equal(t, tc.expectedItems, items)
+			assert.Equal(t, tc.expectedEvents, evs)
+		})
+	}
+	assert.Equal(t, []service.CartItem{moreMilk, bread}, cart.Items, "Items of cart should not be changed")
+}
+
+func TestUndo(t *testing.T) {
+	connTest, err := Connect(context.Background(), dbTestConnString, dbTestName)
+	require.NoError(t, err, "could not create db instance")
+	defer func() {
+		assert.NoError(t, cleanUpCollection(connTest, cartsCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, auditCollectionName))
+	}()
+	ctx := context.Background()
+
+	cart, err := connTest.AddCart(ctx)
+	require.NoError(t, err)
+	cartID := cart.ID.Hex()
+	_, err = connTest.Undo(ctx, cartID)
+	assert.Equal(t, audit.ErrNothingToUndo, errors.Cause(err), "Cart without items should have nothing to undo")
+
+	milk, err := connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "milk", Quantity: 1})
+	require.NoError(t, err)
+	bread, err := connTest.AddItemToCart(ctx, cartID, service.CartItem{ProductName: "bread", Quantity: 1})
+	require.NoError(t, err)
+	// entries of a single change share time, so the cart is rewound to a moment between changes
+	time.Sleep(10 * time.Millisecond)
+	beforeRemoval := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, connTest.RemoveItemFromCart(ctx, cartID, milk.ID.Hex()))
+
+	past, err := connTest.CartAt(ctx, cartID, beforeRemoval)
+	require.NoError(t, err)
+	assert.Equal(t, []service.CartItem{*milk, *bread}, past.Items)
+	assert.Equal(t, int64(2), past.Version)
+	_, err = connTest.CartAt(ctx, cartID, beforeRemoval.Add(-time.Hour))
+	assert.Equal(t, ErrNotFound, errors.Cause(err), "Cart should not exist before it is created")
+
+	restored, err := connTest.Undo(ctx, cartID)
+	require.NoError(t, err)
+	assert.Equal(t, []service.CartItem{*milk, *bread}, restored.Items, "Removed item should be restored in place")
+	assert.Equal(t, int64(4), restored.Version)
+
+	undone, err := connTest.Undo(ctx, cartID)
+	require.NoError(t, err)
+	assert.Equal(t, []service.CartItem{*milk}, undone.Items, "Undo should skip undone changes")
+
+	entries, err := connTest.CartHistory(ctx, cartID, "", 1)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, events.ItemRemoved, entries[0].Action)
+	assert.NotEmpty(t, entries[0].Undoes)
+
+	other, err := connTest.AddCart(ctx)
+	require.NoError(t, err)
+	_, err = connTest.AddItemToCart(ctx, other.ID.Hex(), service.CartItem{ProductName: "milk", Quantity: 1})
+	require.NoError(t, err)
+	_, err = connTest.Audit.DeleteOne(ctx, bson.M{"cart_id": other.ID.Hex(), "action": events.ItemAdded})
+	require.NoError(t, err)
+	_, err = connTest.CartAt(ctx, other.ID.Hex(), time.Now())
+	assert.Equal(t, audit.ErrIncompleteHistory, errors.Cause(err), "Cart should not be rewound over lost entries")
+	_, err = connTest.Audit.DeleteMany(ctx, bson.M{"cart_id": other.ID.Hex()})
+	require.NoError(t, err)
+	_, err = connTest.CartAt(ctx, other.ID.Hex(), time.Now())
+	assert.Equal(t, audit.ErrIncompleteHistory, errors.Cause(err), "Cart created before history should not be rewound")
+}
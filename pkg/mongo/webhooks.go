@@ -0,0 +1,387 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/outbox"
+	"github.com/HarlamovBuldog/cart_api/pkg/webhook"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	webhooksCollectionName   = "webhooks"
+	deliveriesCollectionName = "webhook_deliveries"
+
+	// duplicateKeyCode is code of write errors violating unique index.
+	duplicateKeyCode = 11000
+)
+
+type subscriptionDocument struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	URL       string             `bson:"url"`
+	Events    []string           `bson:"events"`
+	Secret    string             `bson:"secret"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+func (doc subscriptionDocument) subscription() *webhook.Subscription {
+	return &webhook.Subscription{
+		ID:        doc.ID.Hex(),
+		URL:       doc.URL,
+		Events:    doc.Events,
+		Secret:    doc.Secret,
+		CreatedAt: doc.CreatedAt,
+	}
+}
+
+// deliveryDocument is a delivery of an event to a subscription. Pending deliveries are due at AvailableAt.
+type deliveryDocument struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	SubscriptionID primitive.ObjectID `bson:"subscription_id"`
+	Event          outbox.CartEvent   `bson:"event"`
+	Status         string             `bson:"status"`
+	Attempts       []webhook.Attempt  `bson:"attempts"`
+	AvailableAt    time.Time          `bson:"available_at"`
+	CreatedAt      time.Time          `bson:"created_at"`
+}
+
+func (doc deliveryDocument) delivery() *webhook.Delivery {
+	d := &webhook.Delivery{
+		ID:             doc.ID.Hex(),
+		SubscriptionID: doc.SubscriptionID.Hex(),
+		Event:          doc.Event,
+		Status:         doc.Status,
+		Attempts:       doc.Attempts,
+		CreatedAt:      doc.CreatedAt,
+	}
+	if d.Attempts == nil {
+		d.Attempts = []webhook.Attempt{}
+	}
+	if doc.Status == webhook.StatusPending {
+		next := doc.AvailableAt
+		d.NextAttemptAt = &next
+	}
+	return d
+}
+
+// WithWebhooks makes DB schedule webhook deliveries of events of every change of carts in the same transaction
+// as the change and Connect create indexes of webhook collections. Transactions require a replica set.
+func WithWebhooks() Option {
+	return func(db *DB) {
+		db.webhooks = true
+	}
+}
+
+// createWebhookIndexes creates index of event types used to find subscriptions accepting events, index making
+// enqueueing of deliveries idempotent and indexes used to claim and list deliveries.
+func (db *DB) createWebhookIndexes(ctx context.Context) error {
+	_, err := db.Webhooks.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "events", Value: 1}}})
+	if err != nil {
+		return errors.Wrap(err, "could not create subscription indexes")
+	}
+	_, err = db.WebhookDeliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "subscription_id", Value: 1}, {Key: "event.id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "available_at", Value: 1}}},
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	return errors.Wrap(err, "could not create webhook indexes")
+}
+
+// webhookObjectID converts id of a subscription or delivery. Malformed IDs are reported as not found.
+func webhookObjectID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return objID, errors.Wrapf(ErrNotFound, "malformed id %s", id)
+	}
+	return objID, nil
+}
+
+// CreateSubscription stores sub with new ID and creation time.
+func (db *DB) CreateSubscription(ctx context.Context, sub webhook.Subscription) (*webhook.Subscription, error) {
+	doc := subscriptionDocument{
+		ID:        primitive.NewObjectID(),
+		URL:       sub.URL,
+		Events:    sub.Events,
+		Secret:    sub.Secret,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if doc.Events == nil {
+		doc.Events = []string{}
+	}
+	_, err := db.Webhooks.InsertOne(ctx, doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not insert subscription")
+	}
+	return doc.subscription(), nil
+}
+
+// Subscription returns subscription with a specified ID.
+// Func returns ErrNotFound if no subscription was found.
+func (db *DB) Subscription(ctx context.Context, id string) (*webhook.Subscription, error) {
+	objID, err := webhookObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+	var doc subscriptionDocument
+	err = db.Webhooks.FindOne(ctx, bson.M{"_id": objID}).Decode(&doc)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil, errors.Wrap(ErrNotFound, "no subscriptions")
+	case err != nil:
+		return nil, errors.Wrap(err, "could not decode subscription")
+	default:
+		return doc.subscription(), nil
+	}
+}
+
+// Subscriptions returns all subscriptions in order of creation.
+func (db *DB) Subscriptions(ctx context.Context) ([]webhook.Subscription, error) {
+	cur, err := db.Webhooks.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find subscriptions")
+	}
+	defer cur.Close(ctx)
+	subs := []webhook.Subscription{}
+	for cur.Next(ctx) {
+		var doc subscriptionDocument
+		if err := cur.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "could not decode subscription")
+		}
+		subs = append(subs, *doc.subscription())
+	}
+	return subs, errors.Wrap(cur.Err(), "could not read subscriptions")
+}
+
+// UpdateSubscription replaces URL and event types of a subscription and its secret unless it is empty.
+// Func returns ErrNotFound if no subscription was found.
+func (db *DB) UpdateSubscription(ctx context.Context, sub webhook.Subscription) (*webhook.Subscription, error) {
+	objID, err := webhookObjectID(sub.ID)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	set := bson.M{"url": sub.URL, "events": sub.Events}
+	if sub.Secret != "" {
+		set["secret"] = sub.Secret
+	}
+	var doc subscriptionDocument
+	err = db.Webhooks.FindOneAndUpdate(ctx, bson.M{"_id": objID}, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil, errors.Wrap(ErrNotFound, "no subscriptions")
+	case err != nil:
+		return nil, errors.Wrap(err, "could not update subscription")
+	default:
+		return doc.subscription(), nil
+	}
+}
+
+// DeleteSubscription deletes a subscription with all its deliveries.
+// Func returns ErrNotFound if no subscription was found.
+func (db *DB) DeleteSubscription(ctx context.Context, id string) error {
+	objID, err := webhookObjectID(id)
+	if err != nil {
+		return err
+	}
+	deleteResult, err := db.Webhooks.DeleteOne(ctx, bson.M{"_id": objID})
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "could not delete subscription")
+	case deleteResult.DeletedCount == 0:
+		return errors.Wrap(ErrNotFound, "no subscriptions")
+	}
+	_, err = db.WebhookDeliveries.DeleteMany(ctx, bson.M{"subscription_id": objID})
+	return errors.Wrap(err, "could not delete deliveries of subscription")
+}
+
+// EnqueueDelivery schedules delivery of e to a subscription, unless it is already scheduled.
+func (db *DB) EnqueueDelivery(ctx context.Context, subscriptionID string, e outbox.CartEvent) error {
+	subObjID, err := webhookObjectID(subscriptionID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = db.WebhookDeliveries.InsertOne(ctx, deliveryDocument{
+		ID:             primitive.NewObjectID(),
+		SubscriptionID: subObjID,
+		Event:          e,
+		Status:         webhook.StatusPending,
+		Attempts:       []webhook.Attempt{},
+		AvailableAt:    now,
+		CreatedAt:      now,
+	})
+	if isDuplicateKey(err) {
+		// delivery of the event is scheduled already
+		return nil
+	}
+	return errors.Wrap(err, "could not insert delivery")
+}
+
+// enqueueDeliveries schedules delivery of evs to every subscription accepting them.
+func (db *DB) enqueueDeliveries(ctx context.Context, evs []outbox.CartEvent) error {
+	if len(evs) == 0 {
+		return nil
+	}
+	types := make([]string, 0, len(evs))
+	for _, e := range evs {
+		types = append(types, e.Type)
+	}
+	subs, err := db.subscriptionsAccepting(ctx, types)
+	if err != nil {
+		return err
+	}
+	for _, e := range evs {
+		for _, sub := range subs {
+			if !sub.Accepts(e.Type) {
+				continue
+			}
+			if err := db.EnqueueDelivery(ctx, sub.ID, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// subscriptionsAccepting returns IDs and event types of subscriptions accepting any of types, so changes of carts
+// do not read subscriptions which receive none of their events.
+func (db *DB) subscriptionsAccepting(ctx context.Context, types []string) ([]webhook.Subscription, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"events": bson.M{"$in": types}},
+		// subscriptions listing no event types receive events of all types
+		bson.M{"events": bson.A{}},
+	}}
+	cur, err := db.Webhooks.Find(ctx, filter, options.Find().SetProjection(bson.M{"events": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find subscriptions")
+	}
+	defer cur.Close(ctx)
+	var subs []webhook.Subscription
+	for cur.Next(ctx) {
+		var doc subscriptionDocument
+		if err := cur.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "could not decode subscription")
+		}
+		subs = append(subs, *doc.subscription())
+	}
+	return subs, errors.Wrap(cur.Err(), "could not read subscriptions")
+}
+
+// isDuplicateKey reports whether err is caused by violation of unique index.
+func isDuplicateKey(err error) bool {
+	we, ok := errors.Cause(err).(mongo.WriteException)
+	if !ok {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == duplicateKeyCode {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimDelivery returns the oldest pending delivery due and hides it from other workers for lease.
+// Func returns webhook.ErrNoDeliveries if there is none.
+func (db *DB) ClaimDelivery(ctx context.Context, lease time.Duration) (*webhook.Delivery, error) {
+	now := time.Now().UTC()
+	var doc deliveryDocument
+	err := db.WebhookDeliveries.FindOneAndUpdate(
+		ctx,
+		bson.M{"status": webhook.StatusPending, "available_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"available_at": now.Add(lease)}},
+		options.FindOneAndUpdate().SetSort(bson.M{"_id": 1}).SetReturnDocument(options.After),
+	).Decode(&doc)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil, webhook.ErrNoDeliveries
+	case err != nil:
+		return nil, errors.Wrap(err, "could not claim delivery")
+	default:
+		return doc.delivery(), nil
+	}
+}
+
+// RecordAttempt appends a to attempts of a delivery and sets its status.
+// Pending deliveries are attempted again at next.
+func (db *DB) RecordAttempt(ctx context.Context, id string, a webhook.Attempt, status string, next time.Time) error {
+	objID, err := webhookObjectID(id)
+	if err != nil {
+		return err
+	}
+	_, err = db.WebhookDeliveries.UpdateOne(
+		ctx,
+		bson.M{"_id": objID},
+		bson.M{
+			"$push": bson.M{"attempts": a},
+			"$set":  bson.M{"status": status, "available_at": next.UTC()},
+		})
+	return errors.Wrap(err, "could not record delivery attempt")
+}
+
+// Deliveries returns up to limit latest deliveries of a subscription, of a status unless it is empty.
+func (db *DB) Deliveries(ctx context.Context, subscriptionID, status string, limit int) ([]webhook.Delivery, error) {
+	subObjID, err := webhookObjectID(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"subscription_id": subObjID}
+	if status != "" {
+		filter["status"] = status
+	}
+	cur, err := db.WebhookDeliveries.Find(ctx, filter,
+		options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find deliveries")
+	}
+	defer cur.Close(ctx)
+	deliveries := []webhook.Delivery{}
+	for cur.Next(ctx) {
+		var doc deliveryDocument
+		if err := cur.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "could not decode delivery")
+		}
+		deliveries = append(deliveries, *doc.delivery())
+	}
+	return deliveries, errors.Wrap(cur.Err(), "could not read deliveries")
+}
+
+// RetryDelivery makes a dead delivery of a subscription pending again.
+// Func returns ErrNotFound if the subscription has no such dead delivery.
+func (db *DB) RetryDelivery(ctx context.Context, subscriptionID, id string) (*webhook.Delivery, error) {
+	subObjID, err := webhookObjectID(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	objID, err := webhookObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+	var doc deliveryDocument
+	err = db.WebhookDeliveries.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": objID, "subscription_id": subObjID, "status": webhook.StatusDead},
+		bson.M{"$set": bson.M{"status": webhook.StatusPending, "available_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&doc)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return nil, errors.Wrap(ErrNotFound, "no dead deliveries")
+	case err != nil:
+		return nil, errors.Wrap(err, "could not retry delivery")
+	default:
+		return doc.delivery(), nil
+	}
+}
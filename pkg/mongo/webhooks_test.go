@@ -0,0 +1,119 @@
+package mongo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/outbox"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+	"github.com/HarlamovBuldog/cart_api/pkg/webhook"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	connTest, err := Connect(ctx, dbTestConnString, dbTestName, WithWebhooks())
+	require.NoError(t, err, "could not create db instance")
+	defer func() {
+		assert.NoError(t, cleanUpCollection(connTest, webhooksCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, deliveriesCollectionName))
+	}()
+
+	sub, err := connTest.CreateSubscription(ctx, webhook.Subscription{
+		URL:    "https://example.com/hook",
+		Events: []string{"item_added"},
+		Secret: "0123456789abcdef",
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, sub.ID)
+
+	updated, err := connTest.UpdateSubscription(ctx, webhook.Subscription{ID: sub.ID, URL: "https://example.com/other"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/other", updated.URL)
+	assert.Equal(t, sub.Secret, updated.Secret, "Secret should be kept unless given")
+
+	_, err = connTest.Subscription(ctx, "malformed")
+	assert.Equal(t, ErrNotFound, errors.Cause(err))
+
+	e := outbox.CartEvent{ID: "5dcc1bd0a4a8f5c7d1e4e0a0", Type: "item_added", CartID: "5dcc1bd0a4a8f5c7d1e4e0a1"}
+	require.NoError(t, connTest.EnqueueDelivery(ctx, sub.ID, e))
+	require.NoError(t, connTest.EnqueueDelivery(ctx, sub.ID, e), "Enqueueing an event again should be ignored")
+
+	d, err := connTest.ClaimDelivery(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, e.ID, d.Event.ID)
+	_, err = connTest.ClaimDelivery(ctx, time.Minute)
+	assert.Equal(t, webhook.ErrNoDeliveries, errors.Cause(err), "Claimed delivery should be hidden")
+
+	a := webhook.Attempt{Time: time.Now().UTC().Truncate(time.Millisecond), StatusCode: http.StatusGone, Error: "gone"}
+	require.NoError(t, connTest.RecordAttempt(ctx, d.ID, a, webhook.StatusDead, time.Time{}))
+	dead, err := connTest.Deliveries(ctx, sub.ID, webhook.StatusDead, 10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, []webhook.Attempt{a}, dead[0].Attempts)
+
+	retried, err := connTest.RetryDelivery(ctx, sub.ID, d.ID)
+	require.NoError(t, err)
+	assert.Equal(t, webhook.StatusPending, retried.Status)
+	d, err = connTest.ClaimDelivery(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, retried.ID, d.ID, "Retried delivery should be claimed again")
+
+	require.NoError(t, connTest.DeleteSubscription(ctx, sub.ID))
+	deliveries, err := connTest.Deliveries(ctx, sub.ID, "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries, "Deliveries should be deleted with the subscription")
+}
+
+// TestWebhookDeliveriesOfChanges requires mongo running as a replica set, e.g. started with --replSet rs0 and rs.initiate().
+func TestWebhookDeliveriesOfChanges(t *testing.T) {
+	ctx := context.Background()
+	connTest, err := Connect(ctx, dbTestConnString, dbTestName, WithWebhooks())
+	require.NoError(t, err, "could not create db instance")
+	defer func() {
+		assert.NoError(t, cleanUpCollection(connTest, cartsCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, auditCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, webhooksCollectionName))
+		assert.NoError(t, cleanUpCollection(connTest, deliveriesCollectionName))
+	}()
+
+	sub, err := connTest.CreateSubscription(ctx, webhook.Subscription{
+		URL:    "https://example.com/hook",
+		Events: []string{"item_added"},
+		Secret: "0123456789abcdef",
+	})
+	require.NoError(t, err)
+	all, err := connTest.CreateSubscription(ctx, webhook.Subscription{URL: "https://example.com/all", Secret: "0123456789abcdef"})
+	require.NoError(t, err)
+	deleted, err := connTest.CreateSubscription(ctx, webhook.Subscription{
+		URL:    "https://example.com/deleted",
+		Events: []string{"cart_deleted"},
+		Secret: "0123456789abcdef",
+	})
+	require.NoError(t, err)
+	cart, err := connTest.AddCart(ctx)
+	require.NoError(t, err)
+	item, err := connTest.AddItemToCart(ctx, cart.ID.Hex(), service.CartItem{ProductName: "apple", Quantity: 1})
+	require.NoError(t, err)
+
+	deliveries, err := connTest.Deliveries(ctx, sub.ID, webhook.StatusPending, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1, "Only accepted events should be delivered")
+	assert.Equal(t, "item_added", deliveries[0].Event.Type)
+	assert.Equal(t, item, deliveries[0].Event.Item)
+	deliveries, err = connTest.Deliveries(ctx, all.ID, webhook.StatusPending, 10)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 2, "Subscription listing no events should receive all of them")
+	deliveries, err = connTest.Deliveries(ctx, deleted.ID, webhook.StatusPending, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries, "Subscription should not receive events it does not list")
+	count, err := connTest.Outbox.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Zero(t, count, "Outbox should not be written without outbox publisher")
+}
@@ -0,0 +1,172 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+
+	"github.com/pkg/errors"
+)
+
+// Defaults of Relay.
+const (
+	DefaultPollInterval = time.Second
+	DefaultLease        = 30 * time.Second
+	DefaultMaxBackoff   = 5 * time.Minute
+)
+
+// CartEvent is a change of a cart delivered to downstream systems.
+// Events are delivered at least once, consumers drop duplicates by ID.
+type CartEvent struct {
+	ID     string            `json:"id" bson:"id"`
+	Type   string            `json:"type" bson:"type"`
+	CartID string            `json:"cart_id" bson:"cart_id"`
+	ItemID string            `json:"item_id,omitempty" bson:"item_id,omitempty"`
+	Item   *service.CartItem `json:"item,omitempty" bson:"item,omitempty"`
+	Time   time.Time         `json:"time" bson:"time"`
+}
+
+// Record is an event waiting for delivery. Attempts counts claims of the event including the current one.
+type Record struct {
+	Event    CartEvent
+	Attempts int
+}
+
+// ErrNoEvents is returned by Store when no event is available for delivery.
+var ErrNoEvents = errors.New("no events")
+
+// Store keeps events written along with changes of carts until they are delivered.
+type Store interface {
+	// ClaimEvent returns the oldest event available for delivery and hides it from other relays for lease.
+	// It returns ErrNoEvents if there is none.
+	ClaimEvent(ctx context.Context, lease time.Duration) (*Record, error)
+	// DeleteEvent removes a delivered event.
+	DeleteEvent(ctx context.Context, id string) error
+	// RetryEvent makes an event available for delivery again at a specified time.
+	RetryEvent(ctx context.Context, id string, at time.Time, reason string) error
+}
+
+// EventPublisher delivers events to downstream systems.
+type EventPublisher interface {
+	Publish(ctx context.Context, e CartEvent) error
+}
+
+// Relay delivers events of Store to EventPublisher. An event is removed from Store only after it is published,
+// so it is published again if Relay stops in between. Failed events are retried with exponential backoff;
+// events following a failed one wait for the next poll, so delivery order is kept unless an event is retried.
+type Relay struct {
+	store     Store
+	publisher EventPublisher
+	logger    *slog.Logger
+
+	pollInterval time.Duration
+	lease        time.Duration
+	maxBackoff   time.Duration
+}
+
+// Option configures Relay.
+type Option func(*Relay)
+
+// WithLogger sets logger of delivery failures.
+func WithLogger(l *slog.Logger) Option {
+	return func(r *Relay) {
+		r.logger = l
+	}
+}
+
+// WithPollInterval sets how often Store is checked for new events. It is also the first retry delay.
+func WithPollInterval(d time.Duration) Option {
+	return func(r *Relay) {
+		if d > 0 {
+			r.pollInterval = d
+		}
+	}
+}
+
+// WithLease sets how long a claimed event is hidden from other relays. It must exceed time of publishing.
+func WithLease(d time.Duration) Option {
+	return func(r *Relay) {
+		if d > 0 {
+			r.lease = d
+		}
+	}
+}
+
+// NewRelay creates Relay delivering events of store to publisher.
+func NewRelay(store Store, publisher EventPublisher, opts ...Option) *Relay {
+	r := &Relay{
+		store:        store,
+		publisher:    publisher,
+		logger:       slog.Default(),
+		pollInterval: DefaultPollInterval,
+		lease:        DefaultLease,
+		maxBackoff:   DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run delivers events every poll interval until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		n, err := r.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Warn("could not deliver events", slog.Int("delivered", n), logger.Err(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush delivers events available in Store until there are none or delivery fails.
+// It returns number of delivered events.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	delivered := 0
+	for ctx.Err() == nil {
+		rec, err := r.store.ClaimEvent(ctx, r.lease)
+		switch {
+		case errors.Cause(err) == ErrNoEvents:
+			return delivered, nil
+		case err != nil:
+			return delivered, errors.Wrap(err, "could not claim event")
+		}
+
+		err = r.publisher.Publish(ctx, rec.Event)
+		if err != nil {
+			retryErr := r.store.RetryEvent(ctx, rec.Event.ID, time.Now().Add(r.backoff(rec.Attempts)), err.Error())
+			if retryErr != nil {
+				// event is claimed again once the lease expires
+				r.logger.Warn("could not schedule retry of event", slog.String("event_id", rec.Event.ID), logger.Err(retryErr))
+			}
+			return delivered, errors.Wrapf(err, "could not publish event %s", rec.Event.ID)
+		}
+		err = r.store.DeleteEvent(ctx, rec.Event.ID)
+		if err != nil {
+			return delivered, errors.Wrapf(err, "could not delete delivered event %s", rec.Event.ID)
+		}
+		delivered++
+	}
+	return delivered, ctx.Err()
+}
+
+// backoff returns delay before the next delivery of an event failed attempts times.
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.pollInterval
+	for i := 1; i < attempts && d < r.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.maxBackoff {
+		return r.maxBackoff
+	}
+	return d
+}
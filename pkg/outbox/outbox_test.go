@@ -0,0 +1,152 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is Store keeping events in memory.
+type memStore struct {
+	mu      sync.Mutex
+	records []*memRecord
+}
+
+type memRecord struct {
+	Record
+	availableAt time.Time
+	lastError   string
+}
+
+func (s *memStore) add(evs ...CartEvent) {
+	for _, e := range evs {
+		s.records = append(s.records, &memRecord{Record: Record{Event: e}})
+	}
+}
+
+func (s *memStore) ClaimEvent(ctx context.Context, lease time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, r := range s.records {
+		if !r.availableAt.After(now) {
+			r.availableAt = now.Add(lease)
+			r.Attempts++
+			rec := r.Record
+			return &rec, nil
+		}
+	}
+	return nil, ErrNoEvents
+}
+
+func (s *memStore) DeleteEvent(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.records {
+		if r.Event.ID == id {
+			s.records = append(s.records[:i], s.records[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("no event")
+}
+
+func (s *memStore) RetryEvent(ctx context.Context, id string, at time.Time, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.records {
+		if r.Event.ID == id {
+			r.availableAt = at
+			r.lastError = reason
+			return nil
+		}
+	}
+	return errors.New("no event")
+}
+
+// recorder is EventPublisher remembering IDs of published events and failing events listed in fail.
+type recorder struct {
+	published []string
+	fail      map[string]bool
+}
+
+func (p *recorder) Publish(ctx context.Context, e CartEvent) error {
+	if p.fail[e.ID] {
+		return errors.New("unavailable")
+	}
+	p.published = append(p.published, e.ID)
+	return nil
+}
+
+func TestRelay_Flush(t *testing.T) {
+	store := &memStore{}
+	store.add(CartEvent{ID: "1"}, CartEvent{ID: "2"}, CartEvent{ID: "3"})
+	pub := &recorder{fail: map[string]bool{"2": true}}
+	r := NewRelay(store, pub, WithPollInterval(time.Minute))
+
+	n, err := r.Flush(context.Background())
+	assert.EqualError(t, err, "could not publish event 2: unavailable")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"1"}, pub.published, "Events after a failed one should wait")
+	require.Len(t, store.records, 2, "Only delivered events should be deleted")
+	assert.Equal(t, "unavailable", store.records[0].lastError)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), store.records[0].availableAt, time.Second)
+
+	n, err = r.Flush(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"1", "3"}, pub.published, "Event waiting for retry should be skipped")
+
+	store.records[0].availableAt = time.Time{}
+	delete(pub.fail, "2")
+	n, err = r.Flush(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"1", "3", "2"}, pub.published)
+	assert.Empty(t, store.records)
+}
+
+func TestRelay_backoff(t *testing.T) {
+	r := NewRelay(nil, nil, WithPollInterval(time.Second))
+	tt := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: time.Second},
+		{attempts: 2, expected: 2 * time.Second},
+		{attempts: 5, expected: 16 * time.Second},
+		{attempts: 9, expected: 256 * time.Second},
+		{attempts: 10, expected: DefaultMaxBackoff},
+		{attempts: 1000, expected: DefaultMaxBackoff},
+	}
+	for _, tc := range tt {
+		assert.Equal(t, tc.expected, r.backoff(tc.attempts), "attempts %d", tc.attempts)
+	}
+}
+
+func TestRelay_Run(t *testing.T) {
+	store := &memStore{}
+	store.add(CartEvent{ID: "1"})
+	pub := &recorder{}
+	r := NewRelay(store, pub, WithPollInterval(10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.records) == 0
+	}, time.Second, 10*time.Millisecond, "Event should be delivered")
+	cancel()
+	<-done
+	assert.Equal(t, []string{"1"}, pub.published)
+}
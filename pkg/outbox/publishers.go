@@ -0,0 +1,127 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PublisherStdout is spec of publisher writing events to stdout.
+const PublisherStdout = "stdout"
+
+// defaultHTTPTimeout limits delivery of a single event by HTTPPublisher created with NewPublisher.
+const defaultHTTPTimeout = 10 * time.Second
+
+// NewPublisher returns publisher described by spec: "stdout", "file:<path>" or http(s) URL.
+// Publisher writing to a file must be closed. Nothing else may write to stdout while "stdout" publisher is used,
+// otherwise consumers get lines which are not events.
+func NewPublisher(spec string) (EventPublisher, error) {
+	switch {
+	case spec == PublisherStdout:
+		return NewWriterPublisher(os.Stdout), nil
+	case strings.HasPrefix(spec, "file:"):
+		return OpenFilePublisher(strings.TrimPrefix(spec, "file:"))
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return NewHTTPPublisher(spec, &http.Client{Timeout: defaultHTTPTimeout}), nil
+	default:
+		return nil, errors.Errorf("unknown publisher %q", spec)
+	}
+}
+
+// WriterPublisher writes events to a writer as JSON lines.
+type WriterPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterPublisher creates WriterPublisher writing to w.
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+	return &WriterPublisher{w: w}
+}
+
+// Publish writes e as a single line.
+func (p *WriterPublisher) Publish(ctx context.Context, e CartEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "could not encode event")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(b, '\n'))
+	return errors.Wrap(err, "could not write event")
+}
+
+// FilePublisher appends events to a file as JSON lines. The file is synced after every event,
+// so published events survive a crash.
+type FilePublisher struct {
+	WriterPublisher
+	f *os.File
+}
+
+// OpenFilePublisher opens file at path for appending, creating it if needed.
+func OpenFilePublisher(path string) (*FilePublisher, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open events file")
+	}
+	return &FilePublisher{WriterPublisher: WriterPublisher{w: f}, f: f}, nil
+}
+
+// Publish appends e to the file.
+func (p *FilePublisher) Publish(ctx context.Context, e CartEvent) error {
+	if err := p.WriterPublisher.Publish(ctx, e); err != nil {
+		return err
+	}
+	return errors.Wrap(p.f.Sync(), "could not sync events file")
+}
+
+// Close closes the file.
+func (p *FilePublisher) Close() error {
+	return p.f.Close()
+}
+
+// HTTPPublisher posts every event as JSON to a URL. Any 2xx response means the event is delivered.
+// Event ID is sent in Idempotency-Key header.
+type HTTPPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPPublisher creates HTTPPublisher posting to url with client.
+func NewHTTPPublisher(url string, client *http.Client) *HTTPPublisher {
+	return &HTTPPublisher{url: url, client: client}
+}
+
+// Publish posts e.
+func (p *HTTPPublisher) Publish(ctx context.Context, e CartEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "could not encode event")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "could not create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", e.ID)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not post event")
+	}
+	defer resp.Body.Close()
+	// drain body, so the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("event is rejected with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package outbox
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testEvent = CartEvent{
+	ID:     "5dcc1bd0a4a8f5c7d1e4e0a0",
+	Type:   "item_removed",
+	CartID: "5dcc1bd0a4a8f5c7d1e4e0a1",
+	ItemID: "5dcc1bd0a4a8f5c7d1e4e0a2",
+	Time:   time.Unix(0, 0).UTC(),
+}
+
+const testEventJSON = `{"id":"5dcc1bd0a4a8f5c7d1e4e0a0","type":"item_removed","cart_id":"5dcc1bd0a4a8f5c7d1e4e0a1",` +
+	`"item_id":"5dcc1bd0a4a8f5c7d1e4e0a2","time":"1970-01-01T00:00:00Z"}`
+
+func TestNewPublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	tt := []struct {
+		spec        string
+		expected    interface{}
+		expectedErr string
+	}{
+		{spec: "stdout", expected: &WriterPublisher{}},
+		{spec: "file:" + path, expected: &FilePublisher{}},
+		{spec: "https://example.com/events", expected: &HTTPPublisher{}},
+		{spec: "kafka://localhost", expectedErr: `unknown publisher "kafka://localhost"`},
+		{spec: "file:" + filepath.Join(path, "missing", "events.jsonl"), expectedErr: "could not open events file"},
+	}
+	for _, tc := range tt {
+		p, err := NewPublisher(tc.spec)
+		if tc.expectedErr != "" {
+			require.Error(t, err, tc.spec)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+			continue
+		}
+		require.NoError(t, err, tc.spec)
+		assert.IsType(t, tc.expected, p, tc.spec)
+		if f, ok := p.(*FilePublisher); ok {
+			assert.NoError(t, f.Close())
+		}
+	}
+}
+
+func TestFilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	for i := 0; i < 2; i++ {
+		// reopening appends
+		p, err := OpenFilePublisher(path)
+		require.NoError(t, err)
+		require.NoError(t, p.Publish(context.Background(), testEvent))
+		require.NoError(t, p.Close())
+	}
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, testEventJSON+"\n"+testEventJSON+"\n", string(b))
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, err := ioutil.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.Equal(t, testEvent.ID, req.Header.Get("Idempotency-Key"))
+		assert.JSONEq(t, testEventJSON, string(b))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	p := NewHTTPPublisher(server.URL, server.Client())
+
+	assert.NoError(t, p.Publish(context.Background(), testEvent))
+
+	status = http.StatusServiceUnavailable
+	err := p.Publish(context.Background(), testEvent)
+	require.Error(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "status 503"), err.Error())
+}
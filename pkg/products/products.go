@@ -0,0 +1,41 @@
+package products
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Product holds lifecycle data and price of a product. Discontinued products and changed prices are reported
+// by cart validation. Zero price means the product is not priced.
+type Product struct {
+	Discontinued bool    `yaml:"discontinued"`
+	Price        float64 `yaml:"price"`
+}
+
+// Catalog holds products by product name.
+type Catalog map[string]Product
+
+// Product returns product with a specified name or zero Product if it is not listed.
+func (c Catalog) Product(name string) Product {
+	return c[name]
+}
+
+// LoadCatalog reads catalog from YAML file mapping product names to products.
+func LoadCatalog(path string) (Catalog, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read products file")
+	}
+	var c Catalog
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, errors.Wrapf(err, "could not parse products file %s", path)
+	}
+	for name, p := range c {
+		if p.Price < 0 {
+			return nil, errors.Errorf("price of %s in products file %s must not be negative", name, path)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,36 @@
+package products
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCatalog(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "products.yaml")
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	c, err := LoadCatalog(write(`
+milk:
+  discontinued: true
+apples:
+  price: 2.49
+`))
+	require.NoError(t, err)
+	assert.True(t, c.Product("milk").Discontinued)
+	assert.False(t, c.Product("apples").Discontinued)
+	assert.Equal(t, 2.49, c.Product("apples").Price)
+	assert.Equal(t, Product{}, c.Product("bread"), "Unlisted product should be zero")
+
+	_, err = LoadCatalog(write(`milk: {units: [l]}`))
+	assert.Error(t, err, "Unknown field should be rejected")
+
+	_, err = LoadCatalog(write(`milk: {price: -1}`))
+	assert.Error(t, err, "Negative price should be rejected")
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Attributes holds custom options of an item by name, e.g. size, color or gift wrap.
+// Values are strings, float64 numbers or booleans.
+type Attributes map[string]interface{}
+
+// Equal reports whether a and other hold the same values. Nil and empty attributes are equal.
+func (a Attributes) Equal(other Attributes) bool {
+	if len(a) != len(other) {
+		return false
+	}
+	for k, v := range a {
+		ov, ok := other[k]
+		if !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether every attribute of filter is set to the value formatted as in filter,
+// e.g. "true" matches boolean true and "42" matches number 42.
+func (a Attributes) Matches(filter map[string]string) bool {
+	for k, want := range filter {
+		v, ok := a[k]
+		if !ok || fmt.Sprint(v) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalBSONValue encodes attributes as a document with sorted keys,
+// so that equal attributes are equal documents in queries.
+func (a Attributes) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	doc := make(bson.D, 0, len(a))
+	for _, k := range keys {
+		doc = append(doc, bson.E{Key: k, Value: a[k]})
+	}
+	b, err := bson.Marshal(doc)
+	return bsontype.EmbeddedDocument, b, err
+}
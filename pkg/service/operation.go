@@ -0,0 +1,43 @@
+package service
+
+import "github.com/pkg/errors"
+
+// ItemOp is a kind of change of cart items.
+type ItemOp string
+
+// Supported item operations.
+const (
+	// OpAdd adds Item as AddItemToCart does.
+	OpAdd ItemOp = "add"
+	// OpUpdate sets quantity of an item to quantity of Item, in unit of Item if it is set,
+	// and price of the item to price of Item if it is set.
+	OpUpdate ItemOp = "update"
+	// OpRemove removes an item.
+	OpRemove ItemOp = "remove"
+)
+
+// ItemOperation is a single change of cart items applied by ApplyItemOperations.
+// ItemID addresses the changed item of update and remove operations.
+type ItemOperation struct {
+	Op     ItemOp
+	ItemID string
+	Item   CartItem
+}
+
+// ItemOperationResult is an outcome of an ItemOperation.
+// Item is the added or updated line, Error tells why the operation failed.
+type ItemOperationResult struct {
+	Op    ItemOp    `json:"op"`
+	Item  *CartItem `json:"item,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// AnyVersion lets ApplyItemOperations change a cart of any version.
+const AnyVersion int64 = -1
+
+var (
+	// ErrOperationsFailed is returned by ApplyItemOperations when a batch is rejected because of failed operations.
+	ErrOperationsFailed = errors.New("operations failed")
+	// ErrVersionConflict is returned by ApplyItemOperations when the cart is not of the expected version.
+	ErrVersionConflict = errors.New("version conflict")
+)
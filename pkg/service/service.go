@@ -4,32 +4,62 @@ package service
 import (
 	"context"
 
+	"github.com/HarlamovBuldog/cart_api/pkg/units"
+
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Cart represents shopping cart.
-// It holds zero or more CartItems.
+// It holds zero or more CartItems. Version is incremented by every change of items.
 type Cart struct {
-	ID    primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Items []CartItem         `json:"items" bson:"items"`
+	ID      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Items   []CartItem         `json:"items" bson:"items"`
+	Version int64              `json:"version" bson:"version"`
 }
 
 // CartItem represents anytype of goods from shop.
+// Quantity is measured in Unit. Items stored before units were introduced have no unit and count pieces.
+// VariantID and Attributes tell apart lines of the same product, e.g. of different sizes or with gift wrap.
+// Price is the catalog price of the product when the line was added, zero if the product was not priced then.
 type CartItem struct {
 	ID          primitive.ObjectID `json:"id" bson:"id"`
 	CartID      primitive.ObjectID `json:"cart_id" bson:"cart_id"`
 	ProductName string             `json:"product" bson:"product"`
 	Quantity    float64            `json:"quantity" bson:"quantity"`
+	Unit        units.Unit         `json:"unit,omitempty" bson:"unit,omitempty"`
+	VariantID   string             `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Attributes  Attributes         `json:"attributes,omitempty" bson:"attributes,omitempty"`
+	Price       float64            `json:"price,omitempty" bson:"price,omitempty"`
+}
+
+// SameVariant reports whether item and other are the same variant of the same product with equal attributes.
+func (item CartItem) SameVariant(other CartItem) bool {
+	return item.ProductName == other.ProductName &&
+		item.VariantID == other.VariantID &&
+		item.Attributes.Equal(other.Attributes)
 }
 
+// ErrNotFound is returned when a requested cart or item does not exist.
+var ErrNotFound = errors.New("not found")
+
 // Service describes all functions for working with database.
 type Service interface {
 	// AddCart inserts cart to collection with primitiveObjectID generated by mongo.
 	AddCart(ctx context.Context) (*Cart, error)
 	// Cart returns cart with a specified id.
 	Cart(ctx context.Context, id string) (*Cart, error)
+	// DeleteCart deletes a cart with a specified id with all its items.
+	DeleteCart(ctx context.Context, id string) error
 	// AddItemToCart adds item to item list of a cart with a specified ID.
-	AddItemToCart(ctx context.Context, cartID, productName string, quantity float64) (*CartItem, error)
+	// Quantity is added to an existing line of the same variant in a compatible unit, converted to unit of the line.
+	// ID and CartID of item are ignored. Returned item is the added or merged line.
+	AddItemToCart(ctx context.Context, cartID string, item CartItem) (*CartItem, error)
 	// RemoveItemFromCart removes an item with a specified ID from a cart with a specified ID.
 	RemoveItemFromCart(ctx context.Context, cartID, cartItemID string) error
+	// ApplyItemOperations applies all operations to items of a cart with a specified ID in order and atomically.
+	// It returns result of every operation. If any operation fails, none is applied and ErrOperationsFailed is returned.
+	// Unless version is AnyVersion, operations are applied only to the cart of that version, ErrVersionConflict
+	// is returned otherwise.
+	ApplyItemOperations(ctx context.Context, cartID string, version int64, ops []ItemOperation) ([]ItemOperationResult, error)
 }
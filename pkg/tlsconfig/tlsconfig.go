@@ -0,0 +1,190 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+
+	"github.com/pkg/errors"
+)
+
+// Client certificate verification modes.
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// Options describe certificate files and client authentication of a TLS server.
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// ClientAuth is one of ClientAuthNone, ClientAuthOptional, ClientAuthRequire.
+	// Optional mode verifies certificates of clients that present one and lets the rest in.
+	ClientAuth string
+	// ReloadInterval is the minimum time between checks whether files were changed.
+	ReloadInterval time.Duration
+}
+
+// Reloader serves TLS configuration built from certificate files and rebuilds it
+// when the files are rotated, so that certificates are renewed without restart.
+type Reloader struct {
+	opts   Options
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	config    *tls.Config
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+// New loads files described by opts and returns Reloader of them.
+func New(opts Options, l *slog.Logger) (*Reloader, error) {
+	if _, err := clientAuthType(opts.ClientAuth); err != nil {
+		return nil, err
+	}
+	if opts.ClientAuth != ClientAuthNone && opts.ClientAuth != "" && opts.ClientCAFile == "" {
+		return nil, errors.Errorf("client CA file is required for client auth %q", opts.ClientAuth)
+	}
+	r := &Reloader{opts: opts, logger: l}
+
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.config = cfg
+	r.modTimes = modTimes
+	r.lastCheck = time.Now()
+
+	return r, nil
+}
+
+// TLSConfig returns server configuration, that asks Reloader for the current certificates on every handshake.
+// If nextProtos are given, they are offered to clients with ALPN, e.g. h2 required by gRPC clients.
+func (r *Reloader) TLSConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := r.current()
+			if len(nextProtos) > 0 {
+				cfg = cfg.Clone()
+				cfg.NextProtos = nextProtos
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// current returns configuration built from the latest readable files.
+// If rotated files can not be loaded, previous configuration is kept.
+func (r *Reloader) current() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < r.opts.ReloadInterval {
+		return r.config
+	}
+	r.lastCheck = time.Now()
+
+	modTimes, err := r.statFiles()
+	if err != nil {
+		r.logger.Error("could not check certificate files", logger.Err(err))
+		return r.config
+	}
+	if !changed(r.modTimes, modTimes) {
+		return r.config
+	}
+
+	cfg, err := r.load()
+	if err != nil {
+		r.logger.Error("could not reload certificates, keeping previous ones", logger.Err(err))
+		return r.config
+	}
+	r.config = cfg
+	r.modTimes = modTimes
+	r.logger.Info("certificates reloaded", slog.String("cert_file", r.opts.CertFile))
+
+	return r.config
+}
+
+func (r *Reloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load certificate")
+	}
+	clientAuth, err := clientAuthType(r.opts.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.opts.ClientCAFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := ioutil.ReadFile(r.opts.ClientCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read client CA file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificates found in client CA file %s", r.opts.ClientCAFile)
+	}
+	cfg.ClientCAs = pool
+
+	return cfg, nil
+}
+
+func (r *Reloader) statFiles() ([]time.Time, error) {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	res := make([]time.Time, 0, len(files))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not stat file")
+		}
+		res = append(res, info.ModTime())
+	}
+	return res, nil
+}
+
+func changed(prev, cur []time.Time) bool {
+	for i := range cur {
+		if !prev[i].Equal(cur[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func clientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case ClientAuthNone, "":
+		return tls.NoClientCert, nil
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, errors.Errorf("unknown client auth mode %q", mode)
+	}
+}
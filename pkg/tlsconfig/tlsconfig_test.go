@@ -0,0 +1,182 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM encoded certificate and key signed by ca.
+func (ca *testCA) issue(t *testing.T, cn string, serial int64, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, b []byte, modTime time.Time) {
+	require.NoError(t, ioutil.WriteFile(path, b, 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	opts := Options{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server-key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		ClientAuth:   ClientAuthOptional,
+	}
+	start := time.Now().Add(-time.Minute)
+	certPEM, keyPEM := ca.issue(t, "server 1", 10, x509.ExtKeyUsageServerAuth)
+	writeFile(t, opts.CertFile, certPEM, start)
+	writeFile(t, opts.KeyFile, keyPEM, start)
+	writeFile(t, opts.ClientCAFile, ca.pem, start)
+
+	r, err := New(opts, slog.Default())
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.VerifiedChains) > 0 {
+			w.Write([]byte(req.TLS.VerifiedChains[0][0].Subject.CommonName))
+		}
+	}))
+	server.TLS = r.TLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCertPEM, clientKeyPEM := ca.issue(t, "checkout", 20, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+
+	get := func(certs ...tls.Certificate) (string, string) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err, "could not get response")
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.TLS.PeerCertificates[0].Subject.CommonName, string(b)
+	}
+
+	serverCN, clientCN := get()
+	assert.Equal(t, "server 1", serverCN)
+	assert.Equal(t, "", clientCN, "Client without certificate should be let in")
+
+	_, clientCN = get(clientCert)
+	assert.Equal(t, "checkout", clientCN, "Client certificate should be verified")
+
+	certPEM, keyPEM = ca.issue(t, "server 2", 11, x509.ExtKeyUsageServerAuth)
+	writeFile(t, opts.CertFile, certPEM, start.Add(time.Second))
+	writeFile(t, opts.KeyFile, keyPEM, start.Add(time.Second))
+	serverCN, _ = get()
+	assert.Equal(t, "server 2", serverCN, "Rotated certificate should be served")
+
+	writeFile(t, opts.CertFile, []byte("garbage"), start.Add(2*time.Second))
+	serverCN, _ = get()
+	assert.Equal(t, "server 2", serverCN, "Previous certificate should be kept if rotated one is broken")
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Options{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuth: "sometimes"}, slog.Default())
+	assert.Error(t, err, "Unknown client auth mode should be rejected")
+
+	_, err = New(Options{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuth: ClientAuthRequire}, slog.Default())
+	assert.Error(t, err, "Client auth without CA should be rejected")
+}
+
+func TestReloaderNextProtos(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	opts := Options{
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server-key.pem"),
+	}
+	certPEM, keyPEM := ca.issue(t, "server", 10, x509.ExtKeyUsageServerAuth)
+	writeFile(t, opts.CertFile, certPEM, time.Now())
+	writeFile(t, opts.KeyFile, keyPEM, time.Now())
+	r, err := New(opts, slog.Default())
+	require.NoError(t, err)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig("h2"))
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots, NextProtos: []string{"h2"}})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "h2", conn.ConnectionState().NegotiatedProtocol, "h2 should be negotiated with ALPN")
+}
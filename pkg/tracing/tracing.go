@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Supported span exporters.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// instrumentationName identifies spans created by the service code.
+const instrumentationName = "github.com/HarlamovBuldog/cart_api"
+
+// ShutdownFunc flushes pending spans and stops the exporter.
+type ShutdownFunc func(ctx context.Context) error
+
+// Setup installs global tracer provider sending spans to a specified exporter
+// and W3C trace context propagator used to continue traces of incoming requests.
+// Stdout exporter writes spans to w, OTLP exporter is configured by standard OTEL_EXPORTER_OTLP_* variables.
+// With ExporterNone only the propagator is installed and spans are not recorded.
+func Setup(ctx context.Context, exporter, serviceName string, w io.Writer) (ShutdownFunc, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var (
+		spanExporter sdktrace.SpanExporter
+		err          error
+	)
+	switch exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, errors.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create %s exporter", exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create resource")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns tracer of the service from the global tracer provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
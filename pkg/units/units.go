@@ -0,0 +1,145 @@
+package units
+
+import (
+	"io/ioutil"
+	"math"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Unit is a unit of measure of item quantity.
+type Unit string
+
+// Supported units.
+const (
+	Piece    Unit = "piece"
+	Kilogram Unit = "kg"
+	Gram     Unit = "g"
+	Litre    Unit = "l"
+	Metre    Unit = "m"
+)
+
+// All lists supported units.
+var All = []Unit{Piece, Kilogram, Gram, Litre, Metre}
+
+// MaxPrecision is the number of decimal places quantities are rounded to after conversion.
+const MaxPrecision = 6
+
+type dimension int
+
+const (
+	count dimension = iota
+	mass
+	volume
+	length
+)
+
+// measures holds dimension of every unit and its size in base unit of the dimension.
+var measures = map[Unit]struct {
+	dim  dimension
+	size float64
+}{
+	Piece:    {count, 1},
+	Kilogram: {mass, 1},
+	Gram:     {mass, 0.001},
+	Litre:    {volume, 1},
+	Metre:    {length, 1},
+}
+
+// Valid reports whether u is supported.
+func (u Unit) Valid() bool {
+	_, ok := measures[u]
+	return ok
+}
+
+// Compatible reports whether quantities in a and b can be converted into each other.
+func Compatible(a, b Unit) bool {
+	ma, okA := measures[a]
+	mb, okB := measures[b]
+	return okA && okB && ma.dim == mb.dim
+}
+
+// CompatibleWith returns all units quantities in u can be converted to, including u.
+func CompatibleWith(u Unit) []Unit {
+	var res []Unit
+	for _, other := range All {
+		if Compatible(u, other) {
+			res = append(res, other)
+		}
+	}
+	return res
+}
+
+// Convert converts quantity q from one unit to another and rounds it to MaxPrecision.
+func Convert(q float64, from, to Unit) (float64, error) {
+	if !Compatible(from, to) {
+		return 0, errors.Errorf("could not convert %s to %s", from, to)
+	}
+	return Round(q*measures[from].size/measures[to].size, MaxPrecision), nil
+}
+
+// Round rounds q to precision decimal places.
+func Round(q float64, precision int) float64 {
+	p := math.Pow10(precision)
+	return math.Round(q*p) / p
+}
+
+// Spec lists units a product may be measured in and number of decimal places of its quantities.
+// The first unit is used when a request sets none.
+type Spec struct {
+	Units     []Unit `yaml:"units"`
+	Precision int    `yaml:"precision"`
+}
+
+// DefaultSpec applies to products missing from a Catalog.
+var DefaultSpec = Spec{Units: All, Precision: 3}
+
+// DefaultUnit returns unit used for quantities of the product when none is set.
+func (s Spec) DefaultUnit() Unit {
+	return s.Units[0]
+}
+
+func (s Spec) validate() error {
+	if len(s.Units) == 0 {
+		return errors.New("units must not be empty")
+	}
+	for _, u := range s.Units {
+		if !u.Valid() {
+			return errors.Errorf("unknown unit %q", u)
+		}
+	}
+	if s.Precision < 0 || s.Precision > MaxPrecision {
+		return errors.Errorf("precision must be between 0 and %d", MaxPrecision)
+	}
+	return nil
+}
+
+// Catalog holds specs of products by product name.
+type Catalog map[string]Spec
+
+// Spec returns spec of the product or DefaultSpec if the product is not listed.
+func (c Catalog) Spec(product string) Spec {
+	if s, ok := c[product]; ok {
+		return s
+	}
+	return DefaultSpec
+}
+
+// LoadCatalog reads catalog from YAML file mapping product names to specs.
+func LoadCatalog(path string) (Catalog, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read units file")
+	}
+	var c Catalog
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, errors.Wrapf(err, "could not parse units file %s", path)
+	}
+	for product, s := range c {
+		if err := s.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid spec of %q", product)
+		}
+	}
+	return c, nil
+}
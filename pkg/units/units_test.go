@@ -0,0 +1,74 @@
+package units
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvert(t *testing.T) {
+	tt := []struct {
+		name        string
+		quantity    float64
+		from, to    Unit
+		expected    float64
+		expectedErr bool
+	}{
+		{name: "grams to kilograms", quantity: 300, from: Gram, to: Kilogram, expected: 0.3},
+		{name: "kilograms to grams", quantity: 1.25, from: Kilogram, to: Gram, expected: 1250},
+		{name: "same unit", quantity: 2, from: Piece, to: Piece, expected: 2},
+		{name: "float noise is rounded", quantity: 0.1 + 0.2, from: Litre, to: Litre, expected: 0.3},
+		{name: "different dimensions", quantity: 1, from: Kilogram, to: Litre, expectedErr: true},
+		{name: "unknown unit", quantity: 1, from: "lb", to: Kilogram, expectedErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := Convert(tc.quantity, tc.from, tc.to)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, q)
+		})
+	}
+}
+
+func TestCompatibleWith(t *testing.T) {
+	assert.Equal(t, []Unit{Kilogram, Gram}, CompatibleWith(Gram))
+	assert.Equal(t, []Unit{Piece}, CompatibleWith(Piece))
+	assert.Empty(t, CompatibleWith("lb"))
+}
+
+func TestLoadCatalog(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "units.yaml")
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	c, err := LoadCatalog(write(`
+apples:
+  units: [kg, g, piece]
+  precision: 3
+milk:
+  units: [l]
+  precision: 1
+`))
+	require.NoError(t, err)
+	assert.Equal(t, Spec{Units: []Unit{Kilogram, Gram, Piece}, Precision: 3}, c.Spec("apples"))
+	assert.Equal(t, Kilogram, c.Spec("apples").DefaultUnit())
+	assert.Equal(t, DefaultSpec, c.Spec("bread"), "Unlisted product should get default spec")
+
+	_, err = LoadCatalog(write(`apples: {units: [lb], precision: 1}`))
+	assert.Error(t, err, "Unknown unit should be rejected")
+
+	_, err = LoadCatalog(write(`apples: {units: [], precision: 1}`))
+	assert.Error(t, err, "Empty unit list should be rejected")
+
+	_, err = LoadCatalog(write(`apples: {units: [kg], precision: 9}`))
+	assert.Error(t, err, "Precision above MaxPrecision should be rejected")
+}
@@ -0,0 +1,189 @@
+package validation
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes a rule violated by a field of a payload.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors lists every rule violated by a payload.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Violation is a failed rule of a field.
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// Rule checks a value of a field and returns nil if the value satisfies it.
+type Rule[T any] func(value T) *Violation
+
+// Number is a type numeric rules can be applied to.
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+// Field checks value of a field named name against all rules and returns errors of violated ones.
+func Field[T any](name string, value T, rules ...Rule[T]) []FieldError {
+	var errs []FieldError
+	for _, rule := range rules {
+		if v := rule(value); v != nil {
+			errs = append(errs, FieldError{Field: name, Rule: v.Rule, Message: v.Message})
+		}
+	}
+	return errs
+}
+
+// Validate collects errors of all fields. It returns Errors if any rule was violated and nil otherwise.
+func Validate(fields ...[]FieldError) error {
+	var errs Errors
+	for _, f := range fields {
+		errs = append(errs, f...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// When applies rule only if cond is true.
+func When[T any](cond bool, rule Rule[T]) Rule[T] {
+	return func(value T) *Violation {
+		if !cond {
+			return nil
+		}
+		return rule(value)
+	}
+}
+
+// Required is violated by zero value.
+func Required[T comparable]() Rule[T] {
+	return func(value T) *Violation {
+		var zero T
+		if value == zero {
+			return &Violation{Rule: "required", Message: "must be set"}
+		}
+		return nil
+	}
+}
+
+// Absent is violated by non-zero value. It rejects fields not used in a context.
+func Absent[T comparable]() Rule[T] {
+	return func(value T) *Violation {
+		var zero T
+		if value != zero {
+			return &Violation{Rule: "absent", Message: "must not be set"}
+		}
+		return nil
+	}
+}
+
+// MaxLength is violated by strings longer than n characters.
+func MaxLength(n int) Rule[string] {
+	return func(value string) *Violation {
+		if utf8.RuneCountInString(value) > n {
+			return &Violation{Rule: "max_length", Message: fmt.Sprintf("must not be longer than %d characters", n)}
+		}
+		return nil
+	}
+}
+
+// MinLength is violated by non-empty strings shorter than n characters.
+func MinLength(n int) Rule[string] {
+	return func(value string) *Violation {
+		if value != "" && utf8.RuneCountInString(value) < n {
+			return &Violation{Rule: "min_length", Message: fmt.Sprintf("must not be shorter than %d characters", n)}
+		}
+		return nil
+	}
+}
+
+// Matches is violated by non-empty strings not matching re. Description tells clients what is allowed.
+func Matches(re *regexp.Regexp, description string) Rule[string] {
+	return func(value string) *Violation {
+		if value != "" && !re.MatchString(value) {
+			return &Violation{Rule: "pattern", Message: "must contain only " + description}
+		}
+		return nil
+	}
+}
+
+// OneOf is violated by values not listed in allowed.
+func OneOf[T comparable](allowed ...T) Rule[T] {
+	return func(value T) *Violation {
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return &Violation{Rule: "one_of", Message: fmt.Sprintf("must be one of %v", allowed)}
+	}
+}
+
+// Positive is violated by zero and negative numbers.
+func Positive[T Number]() Rule[T] {
+	return func(value T) *Violation {
+		if value <= 0 {
+			return &Violation{Rule: "positive", Message: "must be greater than 0"}
+		}
+		return nil
+	}
+}
+
+// Min is violated by numbers less than n.
+func Min[T Number](n T) Rule[T] {
+	return func(value T) *Violation {
+		if value < n {
+			return &Violation{Rule: "min", Message: fmt.Sprintf("must not be less than %v", n)}
+		}
+		return nil
+	}
+}
+
+// Max is violated by numbers greater than n.
+func Max[T Number](n T) Rule[T] {
+	return func(value T) *Violation {
+		if value > n {
+			return &Violation{Rule: "max", Message: fmt.Sprintf("must not be greater than %v", n)}
+		}
+		return nil
+	}
+}
+
+// Integer is violated by numbers with fractional part.
+func Integer() Rule[float64] {
+	return func(value float64) *Violation {
+		if value != math.Trunc(value) {
+			return &Violation{Rule: "integer", Message: "must be a whole number"}
+		}
+		return nil
+	}
+}
+
+// MaxDecimals is violated by numbers with more than n decimal places.
+func MaxDecimals(n int) Rule[float64] {
+	return func(value float64) *Violation {
+		p := math.Pow10(n)
+		if math.Abs(value*p-math.Round(value*p)) > 1e-9*math.Max(1, math.Abs(value*p)) {
+			return &Violation{Rule: "precision", Message: fmt.Sprintf("must not have more than %d decimal places", n)}
+		}
+		return nil
+	}
+}
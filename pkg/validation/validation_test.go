@@ -0,0 +1,111 @@
+package validation
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	nameRe := regexp.MustCompile(`^[a-z ]*$`)
+	tt := []struct {
+		name        string
+		product     string
+		quantity    float64
+		countable   bool
+		expectedErr error
+	}{
+		{
+			name:     "valid",
+			product:  "green tea",
+			quantity: 1.5,
+		},
+		{
+			name:     "every violation is listed",
+			product:  "Green tea!",
+			quantity: -1.5,
+			expectedErr: Errors{
+				{Field: "product", Rule: "max_length", Message: "must not be longer than 9 characters"},
+				{Field: "product", Rule: "pattern", Message: "must contain only lowercase letters"},
+				{Field: "quantity", Rule: "positive", Message: "must be greater than 0"},
+			},
+		},
+		{
+			name:     "empty",
+			quantity: 101,
+			expectedErr: Errors{
+				{Field: "product", Rule: "required", Message: "must be set"},
+				{Field: "quantity", Rule: "max", Message: "must not be greater than 100"},
+			},
+		},
+		{
+			name:      "conditional rule",
+			product:   "tea",
+			quantity:  1.5,
+			countable: true,
+			expectedErr: Errors{
+				{Field: "quantity", Rule: "integer", Message: "must be a whole number"},
+			},
+		},
+		{
+			name:      "multibyte characters are counted once",
+			product:   "ééééééééé",
+			quantity:  2,
+			countable: true,
+			expectedErr: Errors{
+				{Field: "product", Rule: "pattern", Message: "must contain only lowercase letters"},
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(
+				Field("product", tc.product,
+					Required[string](),
+					MaxLength(9),
+					Matches(nameRe, "lowercase letters"),
+				),
+				Field("quantity", tc.quantity,
+					Positive[float64](),
+					Max(100.0),
+					When(tc.countable, Integer()),
+				),
+			)
+			assert.Equal(t, tc.expectedErr, err)
+		})
+	}
+}
+
+func TestOneOf(t *testing.T) {
+	rule := OneOf("kg", "g")
+	assert.Nil(t, rule("kg"))
+	assert.Equal(t, &Violation{Rule: "one_of", Message: "must be one of [kg g]"}, rule("l"))
+}
+
+func TestMin(t *testing.T) {
+	rule := Min(0.0)
+	assert.Nil(t, rule(0))
+	assert.Equal(t, &Violation{Rule: "min", Message: "must not be less than 0"}, rule(-0.5))
+}
+
+func TestMaxDecimals(t *testing.T) {
+	rule := MaxDecimals(2)
+	assert.Nil(t, rule(1.25))
+	assert.Nil(t, rule(0.1+0.2), "Float noise should not count as decimal places")
+	assert.Nil(t, rule(3))
+	assert.Equal(t, &Violation{Rule: "precision", Message: "must not have more than 2 decimal places"}, rule(1.255))
+}
+
+func TestAbsent(t *testing.T) {
+	rule := Absent[string]()
+	assert.Nil(t, rule(""))
+	assert.Equal(t, &Violation{Rule: "absent", Message: "must not be set"}, rule("x"))
+}
+
+func TestMinLength(t *testing.T) {
+	rule := MinLength(3)
+	assert.Nil(t, rule(""), "Empty value should be checked by Required")
+	assert.Nil(t, rule("ééé"))
+	assert.Equal(t, &Violation{Rule: "min_length", Message: "must not be shorter than 3 characters"}, rule("éé"))
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrForbiddenDestination is returned for subscription URLs whose host resolves to loopback, private, link-local,
+// multicast or unspecified addresses, so subscribers can not make the server call internal services.
+var ErrForbiddenDestination = errors.New("destination is not a public address")
+
+// Resolver looks up addresses of a host. net.DefaultResolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not routed on the internet either.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip may be a destination of webhooks.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// CheckURL resolves host of an absolute URL with r and returns an error caused by ErrForbiddenDestination
+// if any of its addresses is not public.
+func CheckURL(ctx context.Context, r Resolver, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.Wrap(err, "could not parse url")
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicIP(ip) {
+			return errors.Wrapf(ErrForbiddenDestination, "address %s", ip)
+		}
+		return nil
+	}
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.Wrapf(err, "could not resolve %s", host)
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return errors.Wrapf(ErrForbiddenDestination, "%s resolves to %s", host, a.IP)
+		}
+	}
+	return nil
+}
+
+// checkDial refuses connections to addresses which are not public. It runs after the host is resolved for
+// every connection, so a host resolving to another address after the subscription was checked is refused too.
+func checkDial(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, "could not split address")
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return errors.Wrapf(ErrForbiddenDestination, "address %s", host)
+	}
+	return nil
+}
+
+// newHTTPClient returns client connecting only to public addresses. Requests are not sent through
+// a proxy from the environment, whose own address would be checked instead of the destination.
+func newHTTPClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: checkDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: DefaultTimeout, Transport: transport}
+}
@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type resolverFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+func (f resolverFunc) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return f(ctx, host)
+}
+
+func TestCheckURL(t *testing.T) {
+	hosts := map[string][]string{
+		"example.com":  {"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"},
+		"internal.lan": {"93.184.216.34", "10.1.2.3"},
+	}
+	resolver := resolverFunc(func(_ context.Context, host string) ([]net.IPAddr, error) {
+		ips, ok := hosts[host]
+		if !ok {
+			return nil, errors.New("no such host")
+		}
+		var addrs []net.IPAddr
+		for _, ip := range ips {
+			addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+		return addrs, nil
+	})
+	tt := []struct {
+		url       string
+		forbidden bool
+		failed    bool
+	}{
+		{url: "https://example.com/hook"},
+		{url: "http://93.184.216.34:8080/hook"},
+		{url: "http://127.0.0.1:9000/hook", forbidden: true},
+		{url: "http://[::1]/hook", forbidden: true},
+		{url: "http://169.254.169.254/latest/meta-data", forbidden: true},
+		{url: "http://192.168.0.10/hook", forbidden: true},
+		{url: "http://100.64.0.1/hook", forbidden: true},
+		{url: "http://0.0.0.0/hook", forbidden: true},
+		{url: "http://[fd00::1]/hook", forbidden: true},
+		{url: "https://internal.lan/hook", forbidden: true},
+		{url: "https://missing.example/hook", failed: true},
+	}
+	for _, tc := range tt {
+		err := CheckURL(context.Background(), resolver, tc.url)
+		switch {
+		case tc.forbidden:
+			assert.Equal(t, ErrForbiddenDestination, errors.Cause(err), tc.url)
+		case tc.failed:
+			assert.Error(t, err, tc.url)
+			assert.NotEqual(t, ErrForbiddenDestination, errors.Cause(err), tc.url)
+		default:
+			assert.NoError(t, err, tc.url)
+		}
+	}
+}
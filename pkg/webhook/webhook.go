@@ -0,0 +1,116 @@
+//go:generate mockgen -source=webhook.go -destination=../mocks/webhook_mock.go -package=mocks
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/events"
+	"github.com/HarlamovBuldog/cart_api/pkg/outbox"
+
+	"github.com/pkg/errors"
+)
+
+// Statuses of deliveries.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// EventTypes lists types of events subscriptions may be filtered by.
+var EventTypes = []string{
+	events.CartCreated,
+	events.ItemAdded,
+	events.ItemUpdated,
+	events.ItemRemoved,
+	events.CartDeleted,
+	events.CartAbandoned,
+}
+
+// Subscription receives events at URL. Subscriptions listing no event types receive events of all types.
+// Payloads are signed with Secret, which is never returned after the subscription is created.
+type Subscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Accepts reports whether events of type typ are delivered to s.
+func (s Subscription) Accepts(typ string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Attempt is a try to deliver an event. StatusCode is set if the subscriber responded.
+type Attempt struct {
+	Time       time.Time `json:"time" bson:"time"`
+	StatusCode int       `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// Delivery is an event sent to a subscription with all attempts made so far.
+// Deliveries which failed every attempt are dead and kept until retried or deleted along with the subscription.
+type Delivery struct {
+	ID             string           `json:"id"`
+	SubscriptionID string           `json:"subscription_id"`
+	Event          outbox.CartEvent `json:"event"`
+	Status         string           `json:"status"`
+	Attempts       []Attempt        `json:"attempts"`
+	NextAttemptAt  *time.Time       `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+}
+
+// ErrNoDeliveries is returned by Store when no delivery is due.
+var ErrNoDeliveries = errors.New("no deliveries")
+
+// Store keeps subscriptions and their deliveries. Missing subscriptions and deliveries are reported
+// with service.ErrNotFound.
+type Store interface {
+	// CreateSubscription stores sub with new ID and creation time.
+	CreateSubscription(ctx context.Context, sub Subscription) (*Subscription, error)
+	// Subscription returns subscription with a specified ID.
+	Subscription(ctx context.Context, id string) (*Subscription, error)
+	// Subscriptions returns all subscriptions.
+	Subscriptions(ctx context.Context) ([]Subscription, error)
+	// UpdateSubscription replaces URL and event types of a subscription and its secret unless it is empty.
+	UpdateSubscription(ctx context.Context, sub Subscription) (*Subscription, error)
+	// DeleteSubscription deletes a subscription with all its deliveries.
+	DeleteSubscription(ctx context.Context, id string) error
+
+	// EnqueueDelivery schedules delivery of e to a subscription, unless it is already scheduled.
+	EnqueueDelivery(ctx context.Context, subscriptionID string, e outbox.CartEvent) error
+	// ClaimDelivery returns the oldest pending delivery due and hides it from other workers for lease.
+	// It returns ErrNoDeliveries if there is none.
+	ClaimDelivery(ctx context.Context, lease time.Duration) (*Delivery, error)
+	// RecordAttempt appends a to attempts of a delivery and sets its status.
+	// Pending deliveries are attempted again at next.
+	RecordAttempt(ctx context.Context, id string, a Attempt, status string, next time.Time) error
+	// Deliveries returns up to limit latest deliveries of a subscription, of a status unless it is empty.
+	Deliveries(ctx context.Context, subscriptionID, status string, limit int) ([]Delivery, error)
+	// RetryDelivery makes a dead delivery of a subscription pending again.
+	RetryDelivery(ctx context.Context, subscriptionID, id string) (*Delivery, error)
+}
+
+// Signature returns value of signature header of a payload sent at t: hex encoded HMAC-SHA256
+// of the Unix time, a dot and the payload, keyed with secret.
+func Signature(secret string, t time.Time, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(t.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscription_Accepts(t *testing.T) {
+	tt := []struct {
+		name     string
+		events   []string
+		typ      string
+		expected bool
+	}{
+		{name: "all events", events: nil, typ: "item_added", expected: true},
+		{name: "listed event", events: []string{"cart_created", "item_added"}, typ: "item_added", expected: true},
+		{name: "not listed event", events: []string{"cart_created"}, typ: "item_added", expected: false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Subscription{Events: tc.events}.Accepts(tc.typ))
+		})
+	}
+}
+
+func TestSignature(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	payload := []byte(`{"id":"1"}`)
+
+	// echo -n '1700000000.{"id":"1"}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "sha256=086f6aff7bd084c98679825129c5a64dbad88c760016d6d2c0fb123f27951d54", Signature("secret", at, payload))
+	assert.NotEqual(t, Signature("secret", at, payload), Signature("other", at, payload))
+	assert.NotEqual(t, Signature("secret", at, payload), Signature("secret", at.Add(time.Second), payload))
+}
@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/logger"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+
+	"github.com/pkg/errors"
+)
+
+// Headers of webhook requests.
+const (
+	SubscriptionHeader = "X-Webhook-Subscription"
+	DeliveryHeader     = "X-Webhook-Delivery"
+	TimestampHeader    = "X-Webhook-Timestamp"
+	SignatureHeader    = "X-Webhook-Signature"
+)
+
+// Defaults of Worker.
+const (
+	DefaultPollInterval = time.Second
+	DefaultMaxAttempts  = 10
+	DefaultMinBackoff   = 10 * time.Second
+	DefaultMaxBackoff   = time.Hour
+	DefaultTimeout      = 10 * time.Second
+)
+
+// Worker sends pending deliveries to subscribers. A delivery succeeds on any 2xx response, failed ones are
+// retried with exponential backoff until maximum attempts are made, then the delivery is dead.
+type Worker struct {
+	store  Store
+	client *http.Client
+	logger *slog.Logger
+
+	pollInterval time.Duration
+	maxAttempts  int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+}
+
+// Option configures Worker.
+type Option func(*Worker)
+
+// WithLogger sets logger of failed deliveries.
+func WithLogger(l *slog.Logger) Option {
+	return func(w *Worker) {
+		w.logger = l
+	}
+}
+
+// WithHTTPClient sets client sending webhook requests instead of the default one,
+// which connects only to public addresses.
+func WithHTTPClient(c *http.Client) Option {
+	return func(w *Worker) {
+		w.client = c
+	}
+}
+
+// WithPollInterval sets how often due deliveries are checked.
+func WithPollInterval(d time.Duration) Option {
+	return func(w *Worker) {
+		if d > 0 {
+			w.pollInterval = d
+		}
+	}
+}
+
+// WithRetries sets maximum attempts of a delivery and delay before the second attempt,
+// doubled after every next one up to an hour.
+func WithRetries(maxAttempts int, minBackoff time.Duration) Option {
+	return func(w *Worker) {
+		if maxAttempts > 0 {
+			w.maxAttempts = maxAttempts
+		}
+		if minBackoff > 0 {
+			w.minBackoff = minBackoff
+		}
+	}
+}
+
+// NewWorker creates Worker sending deliveries of store.
+func NewWorker(store Store, opts ...Option) *Worker {
+	w := &Worker{
+		store:        store,
+		client:       newHTTPClient(),
+		logger:       slog.Default(),
+		pollInterval: DefaultPollInterval,
+		maxAttempts:  DefaultMaxAttempts,
+		minBackoff:   DefaultMinBackoff,
+		maxBackoff:   DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Run sends deliveries every poll interval until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.Flush(ctx); err != nil && ctx.Err() == nil {
+			w.logger.Warn("could not send webhooks", logger.Err(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush sends all due deliveries and returns number of attempts made.
+// Failure of a delivery does not stop the others.
+func (w *Worker) Flush(ctx context.Context) (int, error) {
+	attempts := 0
+	for ctx.Err() == nil {
+		// lease outlives the request, so a delivery is not sent twice at once
+		d, err := w.store.ClaimDelivery(ctx, 2*w.client.Timeout+time.Minute)
+		switch {
+		case errors.Cause(err) == ErrNoDeliveries:
+			return attempts, nil
+		case err != nil:
+			return attempts, errors.Wrap(err, "could not claim delivery")
+		}
+		if err := w.attempt(ctx, d); err != nil {
+			return attempts, err
+		}
+		attempts++
+	}
+	return attempts, ctx.Err()
+}
+
+// attempt sends d once and records the outcome.
+func (w *Worker) attempt(ctx context.Context, d *Delivery) error {
+	var a Attempt
+	sub, err := w.store.Subscription(ctx, d.SubscriptionID)
+	switch {
+	case errors.Cause(err) == service.ErrNotFound:
+		// subscription was deleted after the delivery was claimed
+		return nil
+	case err != nil:
+		return errors.Wrap(err, "could not get subscription")
+	}
+	a.Time = time.Now().UTC()
+	a.StatusCode, err = w.send(ctx, sub, d)
+	if err != nil {
+		a.Error = err.Error()
+	}
+
+	status, next := StatusDelivered, time.Time{}
+	if err != nil {
+		status = StatusPending
+		n := len(d.Attempts) + 1
+		if n >= w.maxAttempts {
+			status = StatusDead
+		} else {
+			next = a.Time.Add(w.backoff(n))
+		}
+		w.logger.Warn("could not deliver webhook",
+			slog.String("subscription_id", sub.ID),
+			slog.String("delivery_id", d.ID),
+			slog.Int("attempt", n),
+			slog.String("status", status),
+			logger.Err(err))
+	}
+	return errors.Wrap(w.store.RecordAttempt(ctx, d.ID, a, status, next), "could not record attempt")
+}
+
+// send posts event of d to sub and returns status code of the response.
+func (w *Worker) send(ctx context.Context, sub *Subscription, d *Delivery) (int, error) {
+	payload, err := json.Marshal(d.Event)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not encode event")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not create request")
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SubscriptionHeader, sub.ID)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, Signature(sub.Secret, now, payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not post event")
+	}
+	defer resp.Body.Close()
+	// drain body, so the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.Errorf("event is rejected with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns delay after a delivery failed attempts times.
+func (w *Worker) backoff(attempts int) time.Duration {
+	d := w.minBackoff
+	for i := 1; i < attempts && d < w.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > w.maxBackoff {
+		return w.maxBackoff
+	}
+	return d
+}
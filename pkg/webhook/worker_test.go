@@ -0,0 +1,254 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HarlamovBuldog/cart_api/pkg/outbox"
+	"github.com/HarlamovBuldog/cart_api/pkg/service"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is Store keeping subscriptions and deliveries in memory.
+type memStore struct {
+	mu         sync.Mutex
+	subs       []Subscription
+	deliveries []*Delivery
+}
+
+func (s *memStore) CreateSubscription(ctx context.Context, sub Subscription) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub.ID = strconv.Itoa(len(s.subs) + 1)
+	s.subs = append(s.subs, sub)
+	return &sub, nil
+}
+
+func (s *memStore) Subscription(ctx context.Context, id string) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		if sub.ID == id {
+			return &sub, nil
+		}
+	}
+	return nil, service.ErrNotFound
+}
+
+func (s *memStore) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Subscription(nil), s.subs...), nil
+}
+
+func (s *memStore) UpdateSubscription(ctx context.Context, sub Subscription) (*Subscription, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *memStore) DeleteSubscription(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sub := range s.subs {
+		if sub.ID == id {
+			s.subs = append(s.subs[:i], s.subs[i+1:]...)
+			return nil
+		}
+	}
+	return service.ErrNotFound
+}
+
+func (s *memStore) EnqueueDelivery(ctx context.Context, subscriptionID string, e outbox.CartEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.deliveries {
+		if d.SubscriptionID == subscriptionID && d.Event.ID == e.ID {
+			return nil
+		}
+	}
+	s.deliveries = append(s.deliveries, &Delivery{
+		ID:             strconv.Itoa(len(s.deliveries) + 1),
+		SubscriptionID: subscriptionID,
+		Event:          e,
+		Status:         StatusPending,
+	})
+	return nil
+}
+
+func (s *memStore) ClaimDelivery(ctx context.Context, lease time.Duration) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, d := range s.deliveries {
+		if d.Status == StatusPending && (d.NextAttemptAt == nil || !d.NextAttemptAt.After(now)) {
+			next := now.Add(lease)
+			d.NextAttemptAt = &next
+			claimed := *d
+			claimed.Attempts = append([]Attempt(nil), d.Attempts...)
+			return &claimed, nil
+		}
+	}
+	return nil, ErrNoDeliveries
+}
+
+func (s *memStore) RecordAttempt(ctx context.Context, id string, a Attempt, status string, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.deliveries {
+		if d.ID == id {
+			d.Attempts = append(d.Attempts, a)
+			d.Status = status
+			d.NextAttemptAt = nil
+			if status == StatusPending {
+				d.NextAttemptAt = &next
+			}
+			return nil
+		}
+	}
+	return service.ErrNotFound
+}
+
+func (s *memStore) Deliveries(ctx context.Context, subscriptionID, status string, limit int) ([]Delivery, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *memStore) RetryDelivery(ctx context.Context, subscriptionID, id string) (*Delivery, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestWorker_Flush(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []*http.Request
+		bodies   [][]byte
+		statuses = []int{http.StatusInternalServerError, http.StatusOK}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(req.Body)
+		requests = append(requests, req)
+		bodies = append(bodies, body)
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+	defer srv.Close()
+
+	store := &memStore{}
+	sub, _ := store.CreateSubscription(context.Background(), Subscription{URL: srv.URL, Secret: "0123456789abcdef"})
+	require.NoError(t, store.EnqueueDelivery(context.Background(), sub.ID, outbox.CartEvent{ID: "e1", Type: "item_added"}))
+	w := NewWorker(store, WithRetries(3, time.Minute), WithHTTPClient(srv.Client()))
+
+	n, err := w.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	d := store.deliveries[0]
+	assert.Equal(t, StatusPending, d.Status)
+	require.Len(t, d.Attempts, 1)
+	assert.Equal(t, http.StatusInternalServerError, d.Attempts[0].StatusCode)
+	assert.Equal(t, "event is rejected with status 500", d.Attempts[0].Error)
+	require.NotNil(t, d.NextAttemptAt)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *d.NextAttemptAt, time.Second)
+
+	n, err = w.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "Delivery waiting for retry should be skipped")
+
+	d.NextAttemptAt = nil
+	n, err = w.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, StatusDelivered, d.Status)
+	require.Len(t, d.Attempts, 2)
+	assert.Empty(t, d.Attempts[1].Error)
+	assert.Nil(t, d.NextAttemptAt)
+
+	require.Len(t, requests, 2)
+	req := requests[1]
+	assert.Equal(t, sub.ID, req.Header.Get(SubscriptionHeader))
+	assert.Equal(t, d.ID, req.Header.Get(DeliveryHeader))
+	ts, err := strconv.ParseInt(req.Header.Get(TimestampHeader), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, Signature(sub.Secret, time.Unix(ts, 0), bodies[1]), req.Header.Get(SignatureHeader))
+	assert.JSONEq(t, `{"id":"e1","type":"item_added","cart_id":"","time":"0001-01-01T00:00:00Z"}`, string(bodies[1]))
+}
+
+func TestWorker_Flush_dead(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer srv.Close()
+
+	store := &memStore{}
+	sub, _ := store.CreateSubscription(context.Background(), Subscription{URL: srv.URL})
+	require.NoError(t, store.EnqueueDelivery(context.Background(), sub.ID, outbox.CartEvent{ID: "e1"}))
+	require.NoError(t, store.EnqueueDelivery(context.Background(), sub.ID, outbox.CartEvent{ID: "e2"}))
+	w := NewWorker(store, WithRetries(2, time.Minute), WithHTTPClient(srv.Client()))
+
+	n, err := w.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "Failed delivery should not stop the others")
+
+	for _, d := range store.deliveries {
+		d.NextAttemptAt = nil
+	}
+	_, err = w.Flush(context.Background())
+	require.NoError(t, err)
+	for _, d := range store.deliveries {
+		assert.Equal(t, StatusDead, d.Status)
+		assert.Len(t, d.Attempts, 2)
+		assert.Nil(t, d.NextAttemptAt)
+	}
+
+	n, err = w.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "Dead deliveries should not be attempted")
+}
+
+func TestWorker_Flush_forbiddenDestination(t *testing.T) {
+	requested := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requested = true
+	}))
+	defer srv.Close()
+
+	store := &memStore{}
+	sub, _ := store.CreateSubscription(context.Background(), Subscription{URL: srv.URL})
+	require.NoError(t, store.EnqueueDelivery(context.Background(), sub.ID, outbox.CartEvent{ID: "e1"}))
+	w := NewWorker(store)
+
+	n, err := w.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.False(t, requested, "Loopback destination should not be requested")
+	d := store.deliveries[0]
+	require.Len(t, d.Attempts, 1)
+	assert.Equal(t, 0, d.Attempts[0].StatusCode)
+	assert.Contains(t, d.Attempts[0].Error, ErrForbiddenDestination.Error())
+}
+
+func TestWorker_backoff(t *testing.T) {
+	w := NewWorker(nil)
+	tt := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: 10 * time.Second},
+		{attempts: 2, expected: 20 * time.Second},
+		{attempts: 5, expected: 160 * time.Second},
+		{attempts: 9, expected: 2560 * time.Second},
+		{attempts: 10, expected: DefaultMaxBackoff},
+		{attempts: 1000, expected: DefaultMaxBackoff},
+	}
+	for _, tc := range tt {
+		assert.Equal(t, tc.expected, w.backoff(tc.attempts), "attempts %d", tc.attempts)
+	}
+}